@@ -4,12 +4,22 @@ import (
 	"errors"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
 )
 
+// hasAccessConditions returns true if the options make an operation
+// conditional on the lease, etag, tags or modified time of the blob
+func hasAccessConditions(options *StorerOptions) bool {
+	return options.leaseID != "" ||
+		options.etagCondition != EtagNotUsed ||
+		options.sinceCondition != IfConditionNotUsed
+}
+
 func storerOptionConditions(options *StorerOptions) (azStorageBlob.BlobAccessConditions, error) {
 
 	var blobAccessConditions azStorageBlob.BlobAccessConditions
-	if options.leaseID == "" && options.etagCondition == EtagNotUsed {
+	if options.leaseID == "" && options.etagCondition == EtagNotUsed && options.sinceCondition == IfConditionNotUsed {
 		return blobAccessConditions, nil
 	}
 	if options.etag == "" && options.etagCondition != EtagNotUsed {
@@ -31,6 +41,9 @@ func storerOptionConditions(options *StorerOptions) (azStorageBlob.BlobAccessCon
 	case ETagNoneMatch:
 		blobAccessConditions.ModifiedAccessConditions.IfNoneMatch = &options.etag
 	case TagsWhere:
+		if _, err := tagfilter.ParseWhere(options.etag); err != nil {
+			return blobAccessConditions, err
+		}
 		blobAccessConditions.ModifiedAccessConditions.IfTags = &options.etag
 	default:
 	}
@@ -0,0 +1,89 @@
+package azblob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorerOptionConditions(t *testing.T) {
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	storerOptions := func(opts ...Option) *StorerOptions {
+		options := &StorerOptions{}
+		for _, opt := range opts {
+			opt(options)
+		}
+		return options
+	}
+
+	t.Run("no conditions", func(t *testing.T) {
+		conditions, err := storerOptionConditions(storerOptions())
+		require.NoError(t, err)
+		assert.Nil(t, conditions.LeaseAccessConditions)
+		assert.Nil(t, conditions.ModifiedAccessConditions)
+	})
+
+	// a since condition is applied without an etag or lease
+	t.Run("modified since alone", func(t *testing.T) {
+		conditions, err := storerOptionConditions(storerOptions(WithModifiedSince(&since)))
+		require.NoError(t, err)
+		require.NotNil(t, conditions.ModifiedAccessConditions)
+		assert.Equal(t, &since, conditions.ModifiedAccessConditions.IfModifiedSince)
+		assert.Nil(t, conditions.ModifiedAccessConditions.IfUnmodifiedSince)
+		assert.Nil(t, conditions.ModifiedAccessConditions.IfMatch)
+		assert.Nil(t, conditions.LeaseAccessConditions)
+	})
+
+	t.Run("unmodified since alone", func(t *testing.T) {
+		conditions, err := storerOptionConditions(storerOptions(WithUnmodifiedSince(&since)))
+		require.NoError(t, err)
+		require.NotNil(t, conditions.ModifiedAccessConditions)
+		assert.Equal(t, &since, conditions.ModifiedAccessConditions.IfUnmodifiedSince)
+	})
+
+	t.Run("etag and lease", func(t *testing.T) {
+		conditions, err := storerOptionConditions(storerOptions(
+			WithEtagMatch("\"etag\""), WithLeaseID("lease"), WithModifiedSince(&since)))
+		require.NoError(t, err)
+		require.NotNil(t, conditions.ModifiedAccessConditions)
+		assert.Equal(t, "\"etag\"", *conditions.ModifiedAccessConditions.IfMatch)
+		assert.Equal(t, &since, conditions.ModifiedAccessConditions.IfModifiedSince)
+		require.NotNil(t, conditions.LeaseAccessConditions)
+		assert.Equal(t, "lease", *conditions.LeaseAccessConditions.LeaseID)
+	})
+
+	t.Run("etag missing", func(t *testing.T) {
+		_, err := storerOptionConditions(storerOptions(WithEtagMatch("")))
+		require.Error(t, err)
+	})
+
+	t.Run("where tags", func(t *testing.T) {
+		conditions, err := storerOptionConditions(storerOptions(WithWhereTags("\"owner\" = 'alice'")))
+		require.NoError(t, err)
+		require.NotNil(t, conditions.ModifiedAccessConditions)
+		assert.Equal(t, "\"owner\" = 'alice'", *conditions.ModifiedAccessConditions.IfTags)
+
+		_, err = storerOptionConditions(storerOptions(WithWhereTags("owner =")))
+		require.Error(t, err)
+	})
+}
+
+func TestHasAccessConditions(t *testing.T) {
+
+	since := time.Now()
+	assert.False(t, hasAccessConditions(&StorerOptions{}))
+	for _, opt := range []Option{
+		WithEtagMatch("\"etag\""), WithWhereTags("\"a\" = 'b'"), WithModifiedSince(&since), WithLeaseID("lease"),
+	} {
+		options := &StorerOptions{}
+		opt(options)
+		assert.True(t, hasAccessConditions(options))
+	}
+	// options which don't make a delete conditional
+	options := &StorerOptions{}
+	WithDeleteSnapshots(DeleteSnapshotsInclude)(options)
+	assert.False(t, hasAccessConditions(options))
+}
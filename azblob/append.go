@@ -0,0 +1,232 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// blobTypeAppend is the localBlob.BlobType of append blobs
+	blobTypeAppend = "AppendBlob"
+
+	// maxAppendBlocks is the maximum number of blocks in an append blob
+	maxAppendBlocks = 50000
+)
+
+// CreateAppendBlob creates an empty append blob, or replaces an existing blob
+// of any type with an empty append blob.
+//
+// Use WithEtagNoneMatch("*") to create the blob only if it does not exist.
+// The metadata and tags are set by the same operation.
+func (azp *Storer) CreateAppendBlob(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("CreateAppendBlob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	appendBlobClient, err := azp.containerClient.NewAppendBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := appendBlobClient.Create(
+		ctx,
+		&azStorageBlob.AppendBlobCreateOptions{
+			BlobAccessConditions: &blobAccessConditions,
+			Metadata:             options.metadata,
+			TagsMap:              options.tags,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return appendCreateWriteResponse(r), nil
+}
+
+// Append appends data, as a single block, to an existing append blob.
+//
+// WithAppendPosition and WithAppendMaxSize, as well as the ETag, since and
+// lease options, make the append conditional. For example, a writer which
+// knows the length of the blob can use WithAppendPosition to be sure that no
+// other writer has appended in the meantime. The response has the offset at
+// which the data was appended and the number of blocks in the blob.
+//
+// An append blob has at most 50,000 blocks.
+func (azp *Storer) Append(
+	ctx context.Context,
+	identity string,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("Append %s: %d bytes", identity, len(data))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	appendBlobClient, err := azp.containerClient.NewAppendBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := appendBlobClient.AppendBlock(
+		ctx,
+		NewBytesReaderCloser(data),
+		&azStorageBlob.AppendBlobAppendBlockOptions{
+			AppendPositionAccessConditions: &azStorageBlob.AppendPositionAccessConditions{
+				AppendPosition: options.appendPosition,
+				MaxSize:        options.appendMaxSize,
+			},
+			BlobAccessConditions: &blobAccessConditions,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return appendBlockWriteResponse(r), nil
+}
+
+func appendCreateWriteResponse(r azStorageBlob.AppendBlobCreateResponse) *WriteResponse {
+	w := WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w
+}
+
+func appendBlockWriteResponse(r azStorageBlob.AppendBlobAppendBlockResponse) *WriteResponse {
+	w := WriteResponse{
+		ETag:                r.ETag,
+		LastModified:        r.LastModified,
+		CommittedBlockCount: r.BlobCommittedBlockCount,
+	}
+	if r.BlobAppendOffset != nil {
+		offset, err := strconv.ParseInt(*r.BlobAppendOffset, 10, 64)
+		if err == nil {
+			w.AppendOffset = &offset
+		}
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w
+}
+
+// CreateAppendBlob creates an empty append blob, see Storer.CreateAppendBlob
+func (s *localStore) CreateAppendBlob(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("CreateAppendBlob local blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return s.put(&localBlob{
+		Name:     identity,
+		Data:     []byte{},
+		Metadata: options.metadata,
+		Tags:     options.tags,
+		BlobType: blobTypeAppend,
+	}, options)
+}
+
+// Append appends data to an append blob, see Storer.Append
+func (s *localStore) Append(
+	ctx context.Context,
+	identity string,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Append local blob %s: %d bytes", identity, len(data))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if existing == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	if existing.BlobType != blobTypeAppend {
+		return nil, newStorageError(
+			fmt.Sprintf("the blob type is invalid for this operation: %s", identity),
+			http.StatusConflict, errCodeInvalidBlobType)
+	}
+	now := s.now()
+	if err = checkLease(existing, options.leaseID, now, true); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(existing, options, false); err != nil {
+		return nil, err
+	}
+	offset := int64(len(existing.Data))
+	if options.appendPosition != nil && *options.appendPosition != offset {
+		return nil, newStorageError(
+			"the append position condition specified was not met",
+			http.StatusPreconditionFailed, errCodeAppendPositionConditionNotMet)
+	}
+	if options.appendMaxSize != nil && offset+int64(len(data)) > *options.appendMaxSize {
+		return nil, newStorageError(
+			"the max blob size condition specified was not met",
+			http.StatusPreconditionFailed, errCodeMaxBlobSizeConditionNotMet)
+	}
+	if existing.CommittedBlockCount >= maxAppendBlocks {
+		return nil, newStorageError(
+			"the committed block count cannot exceed the maximum limit of 50,000 blocks",
+			http.StatusConflict, errCodeBlockCountExceedsLimit)
+	}
+
+	b := *existing
+	b.Data = make([]byte, 0, len(existing.Data)+len(data))
+	b.Data = append(append(b.Data, existing.Data...), data...)
+	b.CommittedBlockCount++
+	b.ETag = s.nextETag(now)
+	b.LastModified = now.UTC().Truncate(time.Second)
+	if err = s.storeBlob(existing, &b, now); err != nil {
+		return nil, err
+	}
+
+	wr := localWriteResponse(&b)
+	wr.AppendOffset = &offset
+	count := b.CommittedBlockCount
+	wr.CommittedBlockCount = &count
+	return wr, nil
+}
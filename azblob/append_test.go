@@ -0,0 +1,61 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreAppend(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Append(ctx, "log", []byte("first"))
+			require.Error(t, err)
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			_, err = store.CreateAppendBlob(ctx, "log", WithEtagNoneMatch("*"), WithTags(map[string]string{"kind": "log"}))
+			require.NoError(t, err)
+			_, err = store.CreateAppendBlob(ctx, "log", WithEtagNoneMatch("*"))
+			require.Error(t, err)
+
+			wr, err := store.Append(ctx, "log", []byte("first"), WithAppendPosition(0))
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), *wr.AppendOffset)
+			assert.Equal(t, int32(1), *wr.CommittedBlockCount)
+
+			wr, err = store.Append(ctx, "log", []byte("second"), WithAppendPosition(5))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), *wr.AppendOffset)
+			assert.Equal(t, int32(2), *wr.CommittedBlockCount)
+
+			// a concurrent writer which is behind fails
+			_, err = store.Append(ctx, "log", []byte("stale"), WithAppendPosition(5))
+			require.Error(t, err)
+			assert.Equal(t, errCodeAppendPositionConditionNotMet, ErrorFromError(err).StorageErrorCode())
+
+			_, err = store.Append(ctx, "log", []byte("too big"), WithAppendMaxSize(12))
+			require.Error(t, err)
+			assert.Equal(t, errCodeMaxBlobSizeConditionNotMet, ErrorFromError(err).StorageErrorCode())
+
+			rr, err := store.Reader(ctx, "log", WithTags(map[string]string{"kind": "log"}))
+			require.NoError(t, err)
+			assert.Equal(t, "firstsecond", readAll(t, rr))
+
+			_, err = store.Put(ctx, "block", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			_, err = store.Append(ctx, "block", []byte("more"))
+			require.Error(t, err)
+			assert.Equal(t, errCodeInvalidBlobType, ErrorFromError(err).StorageErrorCode())
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package azblob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBatchSize is the maximum number of subrequests in a blob batch
+	maxBatchSize = 256
+
+	// batchServiceVersion is the first service version which supports the
+	// cold access tier, container scoped batches are supported from 2020-04-08
+	batchServiceVersion = "2021-12-02"
+)
+
+var (
+	ErrBatchNoCredential = errors.New("blob batch requires a shared key or azure ad credential")
+)
+
+// BatchResult is the outcome of one blob in a batch operation
+type BatchResult struct {
+	Identity   string
+	StatusCode int
+	// Err is nil if the operation succeeded for the blob
+	Err *Error
+}
+
+// batchSubrequest is the operation for one blob in a batch
+type batchSubrequest struct {
+	method   string
+	identity string
+	query    url.Values
+	header   http.Header
+}
+
+// DeleteBatch deletes the identified blobs using the Blob Batch api, which
+// makes a single request for up to 256 blobs.
+//
+// The result for each blob is returned in the same order as identities. As
+// for Delete, it is not an error if a blob does not exist. The error is only
+// set if a batch as a whole failed, in which case the results are incomplete.
+// WithDeleteSnapshots is the only supported option.
+//
+// A batch requires a shared key or azure ad credential. It fails with
+// ErrBatchNoCredential for a storer authorized by a SAS.
+func (azp *Storer) DeleteBatch(
+	ctx context.Context,
+	identities []string,
+	opts ...Option,
+) ([]BatchResult, error) {
+	azp.log.Debugf("DeleteBatch: %d blobs", len(identities))
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	header := http.Header{}
+	switch options.deleteSnapshots {
+	case DeleteSnapshotsInclude:
+		header.Set("x-ms-delete-snapshots", "include")
+	case DeleteSnapshotsOnly:
+		header.Set("x-ms-delete-snapshots", "only")
+	default:
+	}
+	subrequests := make([]batchSubrequest, 0, len(identities))
+	for _, identity := range identities {
+		subrequests = append(subrequests, batchSubrequest{
+			method:   http.MethodDelete,
+			identity: identity,
+			header:   header,
+		})
+	}
+	results, err := azp.batch(ctx, subrequests)
+	for i := range results {
+		if results[i].StatusCode == http.StatusNotFound {
+			results[i].Err = nil
+		}
+	}
+	return results, err
+}
+
+// SetTierBatch sets the access tier of the identified block blobs using the
+// Blob Batch api, which makes a single request for up to 256 blobs. See
+// DeleteBatch for the results.
+func (azp *Storer) SetTierBatch(
+	ctx context.Context,
+	identities []string,
+	tier AccessTier,
+) ([]BatchResult, error) {
+	azp.log.Debugf("SetTierBatch: %d blobs to %s", len(identities), tier)
+
+	header := http.Header{}
+	header.Set("x-ms-access-tier", string(tier))
+	query := url.Values{"comp": {"tier"}}
+	subrequests := make([]batchSubrequest, 0, len(identities))
+	for _, identity := range identities {
+		subrequests = append(subrequests, batchSubrequest{
+			method:   http.MethodPut,
+			identity: identity,
+			query:    query,
+			header:   header,
+		})
+	}
+	return azp.batch(ctx, subrequests)
+}
+
+// batch submits the subrequests in batches of at most maxBatchSize
+func (azp *Storer) batch(ctx context.Context, subrequests []batchSubrequest) ([]BatchResult, error) {
+
+	results := make([]BatchResult, len(subrequests))
+	for i, sub := range subrequests {
+		results[i].Identity = sub.identity
+	}
+	for start := 0; start < len(subrequests); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(subrequests))
+		if err := azp.submitBatch(ctx, subrequests[start:end], results[start:end]); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// submitBatch makes a single batch request and records the outcome of each
+// subrequest in results
+func (azp *Storer) submitBatch(ctx context.Context, subrequests []batchSubrequest, results []BatchResult) error {
+
+	authorize, err := azp.batchAuthorizer(ctx)
+	if err != nil {
+		return err
+	}
+	containerURL, err := url.Parse(azp.containerURL)
+	if err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	boundary := "batch_" + uuid.NewString()
+
+	// each subrequest is an http request, signed in the same way as if it
+	// were sent alone
+	var body bytes.Buffer
+	for i, sub := range subrequests {
+		u := &url.URL{Path: containerURL.Path + "/" + sub.identity, RawQuery: sub.query.Encode()}
+		header := sub.header.Clone()
+		header.Set("x-ms-date", date)
+		header.Set("Content-Length", "0")
+		authorization, err := authorize(sub.method, u, header)
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", authorization)
+
+		fmt.Fprintf(&body, "--%s\r\n", boundary)
+		fmt.Fprintf(&body, "Content-Type: application/http\r\nContent-Transfer-Encoding: binary\r\nContent-ID: %d\r\n\r\n", i)
+		fmt.Fprintf(&body, "%s %s HTTP/1.1\r\n", sub.method, u.RequestURI())
+		if err = header.Write(&body); err != nil {
+			return err
+		}
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+
+	batchURL := *containerURL
+	batchURL.RawQuery = url.Values{"restype": {"container"}, "comp": {"batch"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, batchURL.String(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+boundary)
+	req.Header.Set("Content-Length", strconv.Itoa(body.Len()))
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("x-ms-version", batchServiceVersion)
+	authorization, err := authorize(http.MethodPost, &batchURL, req.Header)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := azp.do(req)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return newStorageError(
+			fmt.Sprintf("blob batch failed: %s", resp.Status),
+			resp.StatusCode, resp.Header.Get(xMsErrorCodeHeader))
+	}
+	return readBatchResponse(resp, results)
+}
+
+// readBatchResponse records the status of each subrequest response
+func readBatchResponse(resp *http.Response, results []BatchResult) error {
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("bad blob batch response: %w", err)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bad blob batch response: %w", err)
+		}
+		// the CRLF before the next boundary belongs to the delimiter, so the
+		// blank line ending the headers of a subresponse without a body is
+		// restored
+		sub, err := http.ReadResponse(bufio.NewReader(io.MultiReader(part, strings.NewReader("\r\n"))), nil)
+		if err != nil {
+			return fmt.Errorf("bad blob batch response: %w", err)
+		}
+		_, _ = io.Copy(io.Discard, sub.Body)
+		sub.Body.Close()
+		// the content id is only missing if the whole batch failed, in
+		// which case the response applies to every subrequest
+		id, err := strconv.Atoi(part.Header.Get("Content-ID"))
+		if err != nil {
+			for i := range results {
+				setBatchResult(&results[i], sub)
+			}
+			continue
+		}
+		if id < 0 || id >= len(results) {
+			continue
+		}
+		setBatchResult(&results[id], sub)
+	}
+	for i := range results {
+		if results[i].StatusCode == 0 {
+			results[i].Err = NewStatusError(
+				fmt.Sprintf("%s: no response in blob batch", results[i].Identity), http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+// setBatchResult records the subrequest response in result
+func setBatchResult(result *BatchResult, sub *http.Response) {
+	result.StatusCode = sub.StatusCode
+	result.Err = nil
+	if sub.StatusCode >= http.StatusMultipleChoices {
+		result.Err = newStorageError(
+			fmt.Sprintf("%s: %s", result.Identity, sub.Status),
+			sub.StatusCode, sub.Header.Get(xMsErrorCodeHeader))
+	}
+}
+
+// batchAuthorizer returns the function which computes the Authorization header
+// of the batch request and of each subrequest, for the storer's credential
+func (azp *Storer) batchAuthorizer(
+	ctx context.Context,
+) (func(method string, u *url.URL, header http.Header) (string, error), error) {
+
+	switch {
+	case azp.credential != nil:
+		return azp.sharedKeyAuthorization, nil
+	case azp.tokenCredential != nil:
+		token, err := azp.tokenCredential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}})
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		return func(string, *url.URL, http.Header) (string, error) {
+			return "Bearer " + token.Token, nil
+		}, nil
+	case azp.sas != "":
+		return nil, fmt.Errorf("%w: the storer is authorized by a shared access signature", ErrBatchNoCredential)
+	default:
+		return nil, ErrBatchNoCredential
+	}
+}
+
+// sharedKeyAuthorization returns the Authorization header for a request signed
+// with the storer's shared key. The sdk does not expose request signing, so
+// this follows the "Authorize with Shared Key" rest api documentation.
+func (azp *Storer) sharedKeyAuthorization(method string, u *url.URL, header http.Header) (string, error) {
+
+	contentLength := header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	var xmsKeys []string
+	for k := range header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			xmsKeys = append(xmsKeys, k)
+		}
+	}
+	sort.Strings(xmsKeys)
+
+	var s strings.Builder
+	for _, v := range []string{
+		method,
+		header.Get("Content-Encoding"),
+		header.Get("Content-Language"),
+		contentLength,
+		header.Get("Content-MD5"),
+		header.Get("Content-Type"),
+		"", // Date, x-ms-date is always set instead
+		header.Get("If-Modified-Since"),
+		header.Get("If-Match"),
+		header.Get("If-None-Match"),
+		header.Get("If-Unmodified-Since"),
+		header.Get("Range"),
+	} {
+		s.WriteString(v)
+		s.WriteString("\n")
+	}
+	for _, k := range xmsKeys {
+		fmt.Fprintf(&s, "%s:%s\n", k, strings.TrimSpace(header.Get(k)))
+	}
+
+	account := azp.credential.AccountName()
+	s.WriteString("/" + account)
+	s.WriteString(u.EscapedPath())
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		fmt.Fprintf(&s, "\n%s:%s", strings.ToLower(name), strings.Join(values, ","))
+	}
+
+	signature, err := azp.credential.ComputeHMACSHA256(s.String())
+	if err != nil {
+		return "", err
+	}
+	return "SharedKey " + account + ":" + signature, nil
+}
+
+// DeleteBatch deletes the identified blobs, see Storer.DeleteBatch
+func (s *localStore) DeleteBatch(
+	ctx context.Context,
+	identities []string,
+	opts ...Option,
+) ([]BatchResult, error) {
+
+	results := make([]BatchResult, 0, len(identities))
+	for _, identity := range identities {
+		err := s.Delete(ctx, identity, opts...)
+		results = append(results, localBatchResult(identity, http.StatusAccepted, err))
+	}
+	return results, nil
+}
+
+// SetTierBatch sets the access tier of the identified blobs, see
+// Storer.SetTierBatch
+func (s *localStore) SetTierBatch(
+	ctx context.Context,
+	identities []string,
+	tier AccessTier,
+) ([]BatchResult, error) {
+
+	results := make([]BatchResult, 0, len(identities))
+	for _, identity := range identities {
+		err := s.SetTier(ctx, identity, tier)
+		results = append(results, localBatchResult(identity, http.StatusOK, err))
+	}
+	return results, nil
+}
+
+func localBatchResult(identity string, statusCode int, err error) BatchResult {
+	if err == nil {
+		return BatchResult{Identity: identity, StatusCode: statusCode}
+	}
+	e := ErrorFromError(err)
+	return BatchResult{Identity: identity, StatusCode: e.StatusCode(), Err: e}
+}
@@ -0,0 +1,302 @@
+package azblob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreBatch(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, identity := range []string{"a", "b", "leased"} {
+				_, err := store.Put(ctx, identity, NewBytesReaderCloser([]byte("VALUE")))
+				require.NoError(t, err)
+			}
+			_, err := store.AcquireLease(ctx, "leased", 15)
+			require.NoError(t, err)
+
+			results, err := store.SetTierBatch(ctx, []string{"a", "missing"}, AccessTierCool)
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+			assert.Nil(t, results[0].Err)
+			require.NotNil(t, results[1].Err)
+			assert.Equal(t, http.StatusNotFound, results[1].StatusCode)
+
+			results, err = store.DeleteBatch(ctx, []string{"a", "missing", "leased", "b"})
+			require.NoError(t, err)
+			require.Len(t, results, 4)
+			identities := make([]string, 0, len(results))
+			for _, result := range results {
+				identities = append(identities, result.Identity)
+			}
+			assert.Equal(t, []string{"a", "missing", "leased", "b"}, identities)
+			assert.Nil(t, results[0].Err)
+			assert.Nil(t, results[1].Err)
+			require.NotNil(t, results[2].Err)
+			assert.Equal(t, http.StatusPreconditionFailed, results[2].StatusCode)
+			assert.Nil(t, results[3].Err)
+
+			_, err = store.Reader(ctx, "a")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			_, err = store.Reader(ctx, "leased")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSharedKeyAuthorization(t *testing.T) {
+	// the well known key of the azurite storage emulator
+	credential, err := azStorageBlob.NewSharedKeyCredential(
+		"devstoreaccount1",
+		"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+	)
+	require.NoError(t, err)
+	azp := &Storer{credential: credential}
+	date := "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header http.Header
+		// the signature is of
+		stringToSign  string
+		authorization string
+	}{
+		{
+			name:   "batch",
+			method: http.MethodPost,
+			url:    "http://127.0.0.1:10000/testcontainer?restype=container&comp=batch",
+			header: http.Header{
+				"Content-Type":   {"multipart/mixed; boundary=batch_1"},
+				"Content-Length": {"123"},
+				"X-Ms-Date":      {date},
+				"X-Ms-Version":   {batchServiceVersion},
+			},
+			stringToSign: "POST\n\n\n123\n\nmultipart/mixed; boundary=batch_1\n\n\n\n\n\n\n" +
+				"x-ms-date:" + date + "\nx-ms-version:2021-12-02\n" +
+				"/devstoreaccount1/testcontainer\ncomp:batch\nrestype:container",
+			authorization: "SharedKey devstoreaccount1:9CUvq21L0ORIyQ7yJ5KK/eJij1VLrRiqTl92DnW18ss=",
+		},
+		{
+			name:   "subrequest",
+			method: http.MethodDelete,
+			url:    "/testcontainer/a%20b",
+			header: http.Header{
+				"Content-Length":        {"0"},
+				"X-Ms-Date":             {date},
+				"X-Ms-Delete-Snapshots": {"include"},
+			},
+			stringToSign: "DELETE\n\n\n\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:" + date + "\nx-ms-delete-snapshots:include\n" +
+				"/devstoreaccount1/testcontainer/a%20b",
+			authorization: "SharedKey devstoreaccount1:6N3jg/Ei3096VKuG2cLTPiPsPUpKSH/+S/6lV3yDipE=",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature, err := credential.ComputeHMACSHA256(test.stringToSign)
+			require.NoError(t, err)
+			require.Equal(t, test.authorization, "SharedKey devstoreaccount1:"+signature)
+
+			u, err := url.Parse(test.url)
+			require.NoError(t, err)
+			authorization, err := azp.sharedKeyAuthorization(test.method, u, test.header)
+			require.NoError(t, err)
+			assert.Equal(t, test.authorization, authorization)
+		})
+	}
+}
+
+// batchResponse returns a blob batch response with a part for each of the
+// subrequest responses
+func batchResponse(parts ...string) *http.Response {
+	var body strings.Builder
+	for _, part := range parts {
+		body.WriteString("--batchresponse_1\r\n" + part)
+	}
+	body.WriteString("--batchresponse_1--\r\n")
+	return &http.Response{
+		StatusCode: http.StatusAccepted,
+		Header:     http.Header{"Content-Type": {"multipart/mixed; boundary=batchresponse_1"}},
+		Body:       io.NopCloser(strings.NewReader(body.String())),
+	}
+}
+
+// batchAccepted returns the part of a successful delete subresponse. The
+// parts are as in the rest api documentation, the CRLF ending the headers of a
+// subresponse without a body is part of the delimiter.
+func batchAccepted(id string) string {
+	return "Content-Type: application/http\r\nContent-ID: " + id + "\r\n\r\n" +
+		"HTTP/1.1 202 Accepted\r\nx-ms-delete-type-permanent: true\r\nx-ms-version: 2021-12-02\r\n\r\n"
+}
+
+// batchNotFound returns the part of a subresponse for a missing blob
+func batchNotFound(id string) string {
+	body := "<?xml version=\"1.0\" encoding=\"utf-8\"?><Error><Code>BlobNotFound</Code></Error>"
+	return "Content-Type: application/http\r\nContent-ID: " + id + "\r\n\r\n" +
+		"HTTP/1.1 404 The specified blob does not exist.\r\nx-ms-error-code: BlobNotFound\r\n" +
+		"Content-Type: application/xml\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body + "\r\n"
+}
+
+func TestReadBatchResponse(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	// the whole batch failed, the response has no content id
+	failed := "Content-Type: application/http\r\n\r\n" +
+		"HTTP/1.1 400 One of the request inputs is not valid.\r\nx-ms-error-code: InvalidInput\r\n\r\n"
+
+	tests := []struct {
+		name        string
+		resp        *http.Response
+		statusCodes []int
+		errorCodes  []string
+	}{
+		{
+			name:        "in order",
+			resp:        batchResponse(batchAccepted("0"), batchNotFound("1")),
+			statusCodes: []int{http.StatusAccepted, http.StatusNotFound},
+			errorCodes:  []string{"", "BlobNotFound"},
+		},
+		{
+			name:        "by content id",
+			resp:        batchResponse(batchNotFound("1"), batchAccepted("0")),
+			statusCodes: []int{http.StatusAccepted, http.StatusNotFound},
+			errorCodes:  []string{"", "BlobNotFound"},
+		},
+		{
+			name:        "no content id",
+			resp:        batchResponse(failed),
+			statusCodes: []int{http.StatusBadRequest, http.StatusBadRequest},
+			errorCodes:  []string{"InvalidInput", "InvalidInput"},
+		},
+		{
+			name:        "short batch",
+			resp:        batchResponse(batchAccepted("0")),
+			statusCodes: []int{http.StatusAccepted, 0},
+			errorCodes:  []string{"", ""},
+		},
+		{
+			name:        "unknown content id",
+			resp:        batchResponse(batchAccepted("0"), batchAccepted("7"), batchAccepted("1")),
+			statusCodes: []int{http.StatusAccepted, http.StatusAccepted},
+			errorCodes:  []string{"", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := []BatchResult{{Identity: "a"}, {Identity: "b"}}
+			require.NoError(t, readBatchResponse(test.resp, results))
+			for i, result := range results {
+				assert.Equal(t, test.statusCodes[i], result.StatusCode, result.Identity)
+				switch {
+				case test.statusCodes[i] == 0:
+					// there was no response for the blob
+					require.NotNil(t, result.Err, result.Identity)
+					assert.Equal(t, http.StatusInternalServerError, result.Err.StatusCode())
+				case test.statusCodes[i] >= http.StatusMultipleChoices:
+					require.NotNil(t, result.Err, result.Identity)
+					assert.Equal(t, test.statusCodes[i], result.Err.StatusCode())
+					assert.Equal(t, test.errorCodes[i], result.Err.StorageErrorCode())
+				default:
+					assert.Nil(t, result.Err, result.Identity)
+				}
+			}
+		})
+	}
+
+	resp := batchResponse()
+	resp.Header.Set("Content-Type", "")
+	assert.Error(t, readBatchResponse(resp, []BatchResult{{Identity: "a"}}))
+}
+
+func TestDeleteBatch(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	resp := batchResponse(batchAccepted("0"), batchNotFound("1"), batchNotFound("2"))
+	resp.Status = "202 Accepted"
+	transport := &fakeTransport{responses: []*http.Response{
+		resp,
+		storageErrorResponse(http.StatusForbidden, azStorageBlob.StorageErrorCodeAuthenticationFailed),
+	}}
+	azp := fakeStorer(t, transport)
+	ctx := context.Background()
+
+	results, err := azp.DeleteBatch(ctx, []string{"a", "b c", "d"}, WithDeleteSnapshots(DeleteSnapshotsInclude))
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "a", results[0].Identity)
+	assert.Equal(t, http.StatusAccepted, results[0].StatusCode)
+	// a missing blob is not an error
+	for _, result := range results {
+		assert.Nil(t, result.Err, result.Identity)
+	}
+	assert.Equal(t, http.StatusNotFound, results[2].StatusCode)
+
+	req := resp.Request
+	require.NotNil(t, req)
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/devstoreaccount1/testcontainer", req.URL.Path)
+	assert.Equal(t, url.Values{"restype": {"container"}, "comp": {"batch"}}, req.URL.Query())
+	assert.Equal(t, batchServiceVersion, req.Header.Get("x-ms-version"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey devstoreaccount1:"))
+
+	// each subrequest is a signed http request for one blob
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	require.Len(t, transport.bodies, 1)
+	mr := multipart.NewReader(strings.NewReader(transport.bodies[0]), params["boundary"])
+	uris := []string{}
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "application/http", part.Header.Get("Content-Type"))
+		assert.Equal(t, "binary", part.Header.Get("Content-Transfer-Encoding"))
+		assert.Equal(t, strconv.Itoa(i), part.Header.Get("Content-ID"))
+		// as for the responses, the blank line ending the headers is part of
+		// the delimiter
+		sub, err := http.ReadRequest(bufio.NewReader(io.MultiReader(part, strings.NewReader("\r\n"))))
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodDelete, sub.Method)
+		assert.Equal(t, "include", sub.Header.Get("x-ms-delete-snapshots"))
+		assert.Equal(t, "0", sub.Header.Get("Content-Length"))
+		assert.NotEmpty(t, sub.Header.Get("x-ms-date"))
+		assert.True(t, strings.HasPrefix(sub.Header.Get("Authorization"), "SharedKey devstoreaccount1:"))
+		uris = append(uris, sub.RequestURI)
+	}
+	assert.Equal(t, []string{
+		"/devstoreaccount1/testcontainer/a",
+		"/devstoreaccount1/testcontainer/b%20c",
+		"/devstoreaccount1/testcontainer/d",
+	}, uris)
+
+	// a batch which is not accepted fails as a whole
+	_, err = azp.DeleteBatch(ctx, []string{"a"})
+	assert.Equal(t, http.StatusForbidden, ErrorFromError(err).StatusCode())
+	assert.Len(t, transport.bodies, 2)
+}
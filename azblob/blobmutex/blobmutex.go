@@ -0,0 +1,239 @@
+// Package blobmutex provides a distributed mutex, and leader election, built
+// on azure blob leases.
+//
+// A Mutex is held by holding the lease on its lock blob, which is kept by
+// renewing it in the background, see azblob.LeaseHandle. Each time the mutex
+// is locked, the fencing token stored in the metadata of the lock blob is
+// incremented. The Token of a Lock is therefore greater than that of every
+// earlier Lock of the same mutex, so that a resource which records the
+// greatest token it has seen can reject writes from a holder which has since
+// lost the mutex.
+package blobmutex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/azblob"
+)
+
+const (
+	// FencingTokenKey is the lock blob metadata key of the fencing token
+	FencingTokenKey = "fencing_token"
+
+	defaultLeaseDuration = 15
+	defaultMinBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff    = 5 * time.Second
+)
+
+var (
+	// ErrLocked is returned by TryLock if the mutex is held by another
+	ErrLocked = errors.New("blobmutex: locked")
+)
+
+// Store is the subset of the azblob BlobStore operations on which the mutex
+// is built. It is implemented by azblob.Storer, azblob.MemoryStore and
+// azblob.FileStore.
+type Store interface {
+	azblob.LeaseManager
+	Reader(ctx context.Context, identity string, opts ...azblob.Option) (*azblob.ReaderResponse, error)
+	Put(ctx context.Context, identity string, source io.ReadSeekCloser, opts ...azblob.Option) (*azblob.WriteResponse, error)
+}
+
+var (
+	_ Store = (*azblob.Storer)(nil)
+	_ Store = (*azblob.MemoryStore)(nil)
+	_ Store = (*azblob.FileStore)(nil)
+)
+
+// Token is the fencing token of a Lock
+type Token struct {
+	// Sequence increases each time the mutex is locked
+	Sequence uint64
+	// ETag is the ETag of the lock blob once the Sequence was written
+	ETag string
+}
+
+// String returns the sequence, which is all that is needed to order tokens
+func (t Token) String() string {
+	return strconv.FormatUint(t.Sequence, 10)
+}
+
+type options struct {
+	leaseDuration int32
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+}
+
+type Option func(*options)
+
+// WithLeaseDuration sets the duration, in seconds, of the lease on the lock
+// blob. It must be between 15 and 60, the default is 15. A mutex which is
+// lost, eg because the holder crashed, is free once the lease expires.
+func WithLeaseDuration(seconds int32) Option {
+	return func(o *options) {
+		o.leaseDuration = seconds
+	}
+}
+
+// WithBackoff sets the minimum and maximum delay between the attempts of Lock
+// to take a held mutex. The delay doubles, with jitter, after each attempt.
+// The default is 100ms to 5s.
+func WithBackoff(minBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// Mutex is a distributed mutex on the blob identified by name, which is
+// created if it does not exist
+type Mutex struct {
+	log     azblob.Logger
+	store   Store
+	name    string
+	options options
+}
+
+// New returns a mutex on the named lock blob
+func New(log azblob.Logger, store Store, name string, opts ...Option) *Mutex {
+	m := &Mutex{
+		log:   log,
+		store: store,
+		name:  name,
+		options: options{
+			leaseDuration: defaultLeaseDuration,
+			minBackoff:    defaultMinBackoff,
+			maxBackoff:    defaultMaxBackoff,
+		},
+	}
+	for _, opt := range opts {
+		opt(&m.options)
+	}
+	return m
+}
+
+// Lock is a held mutex
+type Lock struct {
+	handle *azblob.LeaseHandle
+	token  Token
+}
+
+// Token returns the fencing token of the lock
+func (l *Lock) Token() Token {
+	return l.token
+}
+
+// Context returns a context which is cancelled when the lock is lost, or
+// unlocked. Work protected by the mutex should use it.
+func (l *Lock) Context() context.Context {
+	return l.handle.Context()
+}
+
+// Err returns nil while the lock is held, otherwise the reason it is not, see
+// azblob.LeaseHandle.Err
+func (l *Lock) Err() error {
+	return l.handle.Err()
+}
+
+// LeaseID returns the id of the lease on the lock blob
+func (l *Lock) LeaseID() string {
+	return l.handle.ID()
+}
+
+// Unlock releases the mutex
+func (l *Lock) Unlock(ctx context.Context) error {
+	return l.handle.Release(ctx)
+}
+
+// TryLock locks the mutex if it is free, and otherwise returns ErrLocked.
+// The context of the Lock is derived from ctx.
+func (m *Mutex) TryLock(ctx context.Context) (*Lock, error) {
+
+	handle, err := azblob.AcquireLeaseHandle(ctx, m.store, m.name, m.options.leaseDuration)
+	if err != nil {
+		if errors.Is(err, azblob.ErrLeaseAlreadyPresent) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	token, err := m.nextToken(ctx, handle.ID())
+	if err != nil {
+		if rerr := handle.Release(ctx); rerr != nil {
+			m.log.Infof("failed to release lock %s: %v", m.name, rerr)
+		}
+		return nil, err
+	}
+	m.log.Debugf("locked %s: %s", m.name, token)
+	return &Lock{handle: handle, token: token}, nil
+}
+
+// Lock locks the mutex, waiting for it to be free if necessary. It returns
+// an error if ctx is done first, or if the mutex can't be locked for any
+// reason other than it being held.
+func (m *Mutex) Lock(ctx context.Context) (*Lock, error) {
+
+	backoff := m.options.minBackoff
+	for {
+		l, err := m.TryLock(ctx)
+		if !errors.Is(err, ErrLocked) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(jitter(backoff)):
+		}
+		backoff = min(2*backoff, m.options.maxBackoff)
+	}
+}
+
+// nextToken increments the fencing token in the metadata of the lock blob,
+// which must be leased with leaseID
+func (m *Mutex) nextToken(ctx context.Context, leaseID string) (Token, error) {
+
+	rr, err := m.store.Reader(ctx, m.name, azblob.WithGetMetadata(azblob.OnlyMetadata), azblob.WithLeaseID(leaseID))
+	if err != nil {
+		return Token{}, err
+	}
+	var sequence uint64
+	if s, ok := rr.Metadata[textproto.CanonicalMIMEHeaderKey(FencingTokenKey)]; ok {
+		sequence, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return Token{}, fmt.Errorf("bad fencing token on %s: %w", m.name, err)
+		}
+	}
+	sequence++
+
+	opts := []azblob.Option{
+		azblob.WithLeaseID(leaseID),
+		azblob.WithMetadata(map[string]string{FencingTokenKey: strconv.FormatUint(sequence, 10)}),
+	}
+	if rr.ETag != nil {
+		opts = append(opts, azblob.WithEtagMatch(*rr.ETag))
+	}
+	wr, err := m.store.Put(ctx, m.name, azblob.NewBytesReaderCloser([]byte{}), opts...)
+	if err != nil {
+		return Token{}, err
+	}
+	token := Token{Sequence: sequence}
+	if wr.ETag != nil {
+		token.ETag = *wr.ETag
+	}
+	return token, nil
+}
+
+// jitter returns a random duration between d/2 and d
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half)
+}
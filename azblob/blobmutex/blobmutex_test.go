@@ -0,0 +1,194 @@
+package blobmutex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/azblob"
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestMutex(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	store := azblob.NewMemoryStore(logger.Sugar, "testcontainer")
+	ctx := context.Background()
+	m1 := New(logger.Sugar, store, "locks/sealer", WithBackoff(time.Millisecond, 10*time.Millisecond))
+	m2 := New(logger.Sugar, store, "locks/sealer", WithBackoff(time.Millisecond, 10*time.Millisecond))
+
+	l1, err := m1.TryLock(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), l1.Token().Sequence)
+	assert.NotEmpty(t, l1.Token().ETag)
+
+	_, err = m2.TryLock(ctx)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = m2.Lock(timeoutCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Lock waits for the mutex to be unlocked
+	locked := make(chan *Lock)
+	go func() {
+		l2, err := m2.Lock(ctx)
+		assert.NoError(t, err)
+		locked <- l2
+	}()
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, l1.Unlock(ctx))
+	assert.ErrorIs(t, l1.Err(), azblob.ErrLeaseReleased)
+
+	l2 := <-locked
+	require.NotNil(t, l2)
+	assert.Equal(t, uint64(2), l2.Token().Sequence)
+	assert.NotEqual(t, l1.Token().ETag, l2.Token().ETag)
+	require.NoError(t, l2.Context().Err())
+
+	// the lock blob can only be written by the holder
+	_, err = store.Put(ctx, "locks/sealer", azblob.NewBytesReaderCloser([]byte{}))
+	require.Error(t, err)
+	require.NoError(t, l2.Unlock(ctx))
+}
+
+func TestMutexFirstLock(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	fileStore, err := azblob.NewFileStore(logger.Sugar, t.TempDir(), "testcontainer")
+	require.NoError(t, err)
+	stores := map[string]Store{
+		"memory": azblob.NewMemoryStore(logger.Sugar, "testcontainer"),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// the lock blob does not exist until the first lock creates it
+			const contenders = 8
+			var wg sync.WaitGroup
+			locks := make([]*Lock, contenders)
+			errs := make([]error, contenders)
+			for i := range contenders {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					locks[i], errs[i] = New(logger.Sugar, store, "locks/new").TryLock(ctx)
+				}()
+			}
+			wg.Wait()
+
+			var held *Lock
+			for i := range contenders {
+				if errs[i] != nil {
+					assert.ErrorIs(t, errs[i], ErrLocked)
+					continue
+				}
+				require.Nil(t, held, "locked more than once")
+				held = locks[i]
+			}
+			require.NotNil(t, held)
+			assert.Equal(t, uint64(1), held.Token().Sequence)
+			require.NoError(t, held.Unlock(ctx))
+		})
+	}
+}
+
+// conflictStore fails to acquire a lease with a conflict which is not because
+// the blob is leased
+type conflictStore struct {
+	*azblob.MemoryStore
+}
+
+func (conflictStore) AcquireLease(ctx context.Context, objectname string, leaseTimeout int32) (string, error) {
+	return "", azblob.NewStatusError("blob archived", http.StatusConflict)
+}
+
+func TestMutexConflict(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	store := conflictStore{azblob.NewMemoryStore(logger.Sugar, "testcontainer")}
+	m := New(logger.Sugar, store, "locks/archived", WithBackoff(time.Millisecond, 10*time.Millisecond))
+
+	// only a held lease is reported as locked, Lock does not wait on others
+	_, err := m.TryLock(context.Background())
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrLocked)
+	_, err = m.Lock(context.Background())
+	assert.Equal(t, http.StatusConflict, azblob.ErrorFromError(err).StatusCode())
+}
+
+func TestElector(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	store := azblob.NewMemoryStore(logger.Sugar, "testcontainer")
+
+	var mu sync.Mutex
+	var leaders []string
+	var tokens []uint64
+	elected := make(chan string, 2)
+	demoted := make(chan error, 2)
+	newElector := func(name string) *Elector {
+		return NewElector(logger.Sugar, store, "locks/leader", Callbacks{
+			OnElected: func(ctx context.Context, token Token) {
+				mu.Lock()
+				leaders = append(leaders, name)
+				tokens = append(tokens, token.Sequence)
+				mu.Unlock()
+				elected <- name
+				<-ctx.Done()
+			},
+			OnDemoted: func(err error) {
+				demoted <- err
+			},
+		}, WithBackoff(time.Millisecond, 10*time.Millisecond))
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	doneA := make(chan error)
+	doneB := make(chan error)
+	go func() { doneA <- newElector("a").Run(ctxA) }()
+	first := <-elected
+	assert.Equal(t, "a", first)
+	go func() { doneB <- newElector("b").Run(ctxB) }()
+
+	// b is only elected once a resigns
+	select {
+	case name := <-elected:
+		t.Fatalf("%s elected while a leads", name)
+	case <-time.After(30 * time.Millisecond):
+	}
+	cancelA()
+	assert.ErrorIs(t, <-doneA, context.Canceled)
+	err := <-demoted
+	assert.True(t, errors.Is(err, context.Canceled) || errors.Is(err, azblob.ErrLeaseReleased), "unexpected demotion: %v", err)
+
+	select {
+	case name := <-elected:
+		assert.Equal(t, "b", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("b not elected")
+	}
+	cancelB()
+	assert.ErrorIs(t, <-doneB, context.Canceled)
+	<-demoted
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"a", "b"}, leaders)
+	assert.Equal(t, []uint64{1, 2}, tokens)
+}
@@ -0,0 +1,94 @@
+package blobmutex
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/azblob"
+)
+
+// Callbacks are called by an Elector as it gains and loses the leadership
+type Callbacks struct {
+	// OnElected is called, in its own goroutine, when the elector becomes
+	// the leader. ctx is cancelled when the leadership is lost, and
+	// OnElected must return promptly once it is. The elector does not
+	// campaign again until it has returned.
+	OnElected func(ctx context.Context, token Token)
+	// OnDemoted is called when the leadership is lost, or resigned, with
+	// the reason, see Lock.Err. It is optional.
+	OnDemoted func(err error)
+}
+
+// Elector elects a single leader amongst the electors which share the mutex
+type Elector struct {
+	log       azblob.Logger
+	mutex     *Mutex
+	callbacks Callbacks
+}
+
+// NewElector returns an elector which campaigns for the named lock blob. The
+// options are those of the mutex, the backoff is also the delay before
+// campaigning again after an error.
+func NewElector(
+	log azblob.Logger, store Store, name string, callbacks Callbacks, opts ...Option,
+) *Elector {
+	return &Elector{
+		log:       log,
+		mutex:     New(log, store, name, opts...),
+		callbacks: callbacks,
+	}
+}
+
+// Run campaigns for the leadership until ctx is done. Once elected, the
+// elector leads until its lease can't be renewed, then it campaigns again.
+// The leadership is resigned, and the lock released, when ctx is done. Run
+// returns ctx.Err().
+func (e *Elector) Run(ctx context.Context) error {
+
+	for {
+		lock, err := e.mutex.Lock(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			e.log.Infof("election %s failed: %v", e.mutex.name, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(jitter(e.mutex.options.maxBackoff)):
+			}
+			continue
+		}
+		e.lead(ctx, lock)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// lead runs OnElected until the lock is lost or ctx is done
+func (e *Elector) lead(ctx context.Context, lock *Lock) {
+
+	e.log.Infof("elected %s: %s", e.mutex.name, lock.Token())
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		e.callbacks.OnElected(lock.Context(), lock.Token())
+	}()
+	<-lock.Context().Done()
+	<-elected
+
+	reason := lock.Err()
+	if !errors.Is(reason, azblob.ErrLeaseLost) {
+		// resigned, as ctx is done. The lease is released with its own
+		// timeout, regardless of ctx.
+		if err := lock.Unlock(ctx); err != nil {
+			e.log.Infof("failed to release leadership %s: %v", e.mutex.name, err)
+		}
+	}
+	e.log.Infof("demoted %s: %v", e.mutex.name, reason)
+	if e.callbacks.OnDemoted != nil {
+		e.callbacks.OnDemoted(reason)
+	}
+}
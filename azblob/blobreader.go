@@ -0,0 +1,138 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var (
+	ErrNegativeOffset = errors.New("negative offset")
+)
+
+// BlobReader provides io.ReaderAt and io.ReadSeeker access to a single blob.
+//
+// Each read is a ranged read, conditional on the ETag the blob had when the
+// BlobReader was created. So only the bytes that are needed are fetched, and
+// if the blob changes the reads fail with ConditionNotMet rather than
+// returning a mix of old and new content.
+//
+// The context given to NewBlobReader is used for all reads, as the io
+// interfaces do not accept one.
+type BlobReader struct {
+	ctx      context.Context
+	reader   Reader
+	identity string
+	opts     []Option
+
+	etag   string
+	size   int64
+	offset int64
+}
+
+var (
+	_ io.ReaderAt   = (*BlobReader)(nil)
+	_ io.ReadSeeker = (*BlobReader)(nil)
+)
+
+// NewBlobReader returns a BlobReader for the identified blob. The opts are
+// applied to every read, eg WithLeaseID or WithTags. Any ETag condition in the
+// opts is replaced by the condition on the current ETag.
+func NewBlobReader(ctx context.Context, reader Reader, identity string, opts ...Option) (*BlobReader, error) {
+
+	resp, err := reader.Reader(ctx, identity, append(opts, WithGetMetadata(OnlyMetadata))...)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ETag == nil {
+		return nil, fmt.Errorf("no etag available for blob %s", identity)
+	}
+
+	return &BlobReader{
+		ctx:      ctx,
+		reader:   reader,
+		identity: identity,
+		// ReadAt may be called concurrently, so each append must allocate
+		opts: slices.Clip(opts),
+		etag: *resp.ETag,
+		size: resp.BlobSize,
+	}, nil
+}
+
+// Size returns the size of the blob
+func (br *BlobReader) Size() int64 {
+	return br.size
+}
+
+// ETag returns the ETag all reads are conditional on
+func (br *BlobReader) ETag() string {
+	return br.etag
+}
+
+// ReadAt reads len(p) bytes from the blob starting at off
+func (br *BlobReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrNegativeOffset
+	}
+	if off >= br.size {
+		return 0, io.EOF
+	}
+	count := int64(len(p))
+	if count == 0 {
+		return 0, nil
+	}
+	if off+count > br.size {
+		count = br.size - off
+	}
+
+	resp, err := br.reader.Reader(
+		br.ctx, br.identity,
+		append(br.opts, WithRange(off, count), WithEtagMatch(br.etag))...)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Reader.Close()
+	if !resp.Ok() {
+		return 0, fmt.Errorf("failed to read blob %s: %s", br.identity, resp.Status)
+	}
+
+	n, err := io.ReadFull(resp.Reader, p[:count])
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads from the current offset
+func (br *BlobReader) Read(p []byte) (int, error) {
+	n, err := br.ReadAt(p, br.offset)
+	br.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		// io.Reader permits, but does not require, EOF with the final bytes
+		return n, nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (br *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += br.offset
+	case io.SeekEnd:
+		offset += br.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
+	br.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,55 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Writer is the interface in order to carry out write operations on the azure blob storage
+type Writer interface {
+	Write(
+		ctx context.Context,
+		identity string,
+		source io.Reader,
+		opts ...Option,
+	) (*WriteResponse, error)
+	Put(
+		ctx context.Context,
+		identity string,
+		source io.ReadSeekCloser,
+		opts ...Option,
+	) (*WriteResponse, error)
+	WriteStream(
+		ctx context.Context,
+		identity string,
+		source *http.Request,
+		opts ...Option,
+	) (*WriteResponse, error)
+	Delete(ctx context.Context, identity string, opts ...Option) error
+}
+
+// Leaser is the interface for taking and releasing exclusive write leases on blobs
+type Leaser interface {
+	AcquireLease(ctx context.Context, objectname string, leaseTimeout int32) (string, error)
+	ReleaseLease(ctx context.Context, objectname string, leaseID string) error
+	ReleaseLeaseDeferable(ctx context.Context, objectname string, leaseID string)
+}
+
+// BlobStore is the complete set of blob operations supported by this package.
+//
+// It is implemented by Storer, for azure blob storage, and by MemoryStore and
+// FileStore which provide the same ETag, If-Modified-Since, lease, metadata
+// and tag semantics without a dependency on azure (or azurite).
+type BlobStore interface {
+	Reader
+	Writer
+	Leaser
+	Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error)
+}
+
+var (
+	_ BlobStore = (*Storer)(nil)
+	_ BlobStore = (*MemoryStore)(nil)
+	_ BlobStore = (*FileStore)(nil)
+)
@@ -0,0 +1,169 @@
+package azblob
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxStagedBlocks is the number of blocks staged concurrently by a
+	// streaming upload. Each requires a chunkSize buffer.
+	maxStagedBlocks = 3
+)
+
+// blockWriter is the set of primitive operations needed to upload a block
+// blob in stages. The blob is only created, or replaced, when the staged blocks
+// are committed.
+type blockWriter interface {
+	// stageBlock uploads an uncommitted block
+	stageBlock(ctx context.Context, identity string, blockID string, data []byte, leaseID string) error
+	// commitBlockList replaces the content of the blob with the listed blocks.
+	// The conditions, metadata and tags in options are applied by the commit.
+	commitBlockList(ctx context.Context, identity string, blockIDs []string, options *StorerOptions) (*WriteResponse, error)
+}
+
+// blockID returns the id of the n'th block of an upload. The ids are
+// deterministic and, as required by azure, all the same length.
+func blockID(uploadID string, n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", uploadID, n)))
+}
+
+// newUploadID returns a new, unique, prefix for the block ids of an upload.
+// Blocks staged by concurrent uploads to the same blob must not collide.
+func newUploadID() string {
+	return uuid.NewString()
+}
+
+// stageReader stages the content of reader in chunkSize blocks, returning the
+// ids of the staged blocks in order.
+func stageReader(
+	ctx context.Context,
+	bw blockWriter,
+	identity string,
+	uploadID string,
+	reader io.Reader,
+	leaseID string,
+) ([]string, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var stageErr error
+	tokens := make(chan struct{}, maxStagedBlocks)
+
+	var blockIDs []string
+	for n := 0; ; n++ {
+		tokens <- struct{}{}
+
+		mu.Lock()
+		err := stageErr
+		mu.Unlock()
+		if err != nil {
+			break
+		}
+
+		data := make([]byte, chunkSize)
+		size, err := io.ReadFull(reader, data)
+		if errors.Is(err, io.EOF) {
+			// an empty blob is committed with an empty block list
+			<-tokens
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			mu.Lock()
+			stageErr = err
+			mu.Unlock()
+			break
+		}
+
+		id := blockID(uploadID, n)
+		blockIDs = append(blockIDs, id)
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+			if err := bw.stageBlock(ctx, identity, id, data, leaseID); err != nil {
+				mu.Lock()
+				if stageErr == nil {
+					stageErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(data[:size])
+
+		if size < chunkSize {
+			break
+		}
+	}
+	wg.Wait()
+
+	if stageErr != nil {
+		return nil, stageErr
+	}
+	return blockIDs, nil
+}
+
+func (azp *Storer) stageBlock(
+	ctx context.Context,
+	identity string,
+	blockID string,
+	data []byte,
+	leaseID string,
+) error {
+
+	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	options := &azStorageBlob.BlockBlobStageBlockOptions{}
+	if leaseID != "" {
+		options.LeaseAccessConditions = &azStorageBlob.LeaseAccessConditions{LeaseID: &leaseID}
+	}
+	_, err = blockBlobClient.StageBlock(ctx, blockID, NewBytesReaderCloser(data), options)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+func (azp *Storer) commitBlockList(
+	ctx context.Context,
+	identity string,
+	blockIDs []string,
+	options *StorerOptions,
+) (*WriteResponse, error) {
+	azp.log.Debugf("commitBlockList BlockBlob %s: %d blocks", identity, len(blockIDs))
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := blockBlobClient.CommitBlockList(
+		ctx,
+		blockIDs,
+		&azStorageBlob.BlockBlobCommitBlockListOptions{
+			BlobAccessConditions: &blobAccessConditions,
+			Metadata:             options.metadata,
+			BlobTagsMap:          options.tags,
+			Tier:                 accessTierOption(options),
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return uploadStreamWriteResponse(r), nil
+}
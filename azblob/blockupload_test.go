@@ -0,0 +1,61 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreConditionalWrite(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	// more than one block
+	data := bytes.Repeat([]byte("0123456789"), chunkSize/5)
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Write(ctx, "blob", bytes.NewReader(data), WithEtagNoneMatch("*"),
+				WithMetadata(map[string]string{"owner": "tenant1"}),
+				WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "blob", WithTags(map[string]string{"owner": "tenant1"}), WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, "tenant1", rr.Metadata["Owner"])
+			assert.Equal(t, string(data), readAll(t, rr))
+
+			// create only
+			_, err = store.Write(ctx, "blob", bytes.NewReader(data), WithEtagNoneMatch("*"))
+			require.Error(t, err)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			// compare and swap
+			wr2, err := store.Write(ctx, "blob", strings.NewReader("SECOND"), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+			_, err = store.Write(ctx, "blob", strings.NewReader("THIRD"), WithEtagMatch(*wr.ETag))
+			require.Error(t, err)
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+
+			rr, err = store.Reader(ctx, "blob", WithEtagMatch(*wr2.ETag))
+			require.NoError(t, err)
+			assert.Equal(t, "SECOND", readAll(t, rr))
+
+			// empty blobs
+			_, err = store.Write(ctx, "empty", strings.NewReader(""))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "empty")
+			require.NoError(t, err)
+			assert.Equal(t, "", readAll(t, rr))
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// PublicAccess is the level of anonymous read access to a container. The
+// default, PublicAccessNone, requires all requests to be authorized.
+type PublicAccess = azStorageBlob.PublicAccessType
+
+const (
+	PublicAccessNone PublicAccess = ""
+	// PublicAccessBlob allows anonymous reads of blobs, but not listing
+	PublicAccessBlob = azStorageBlob.PublicAccessTypeBlob
+	// PublicAccessContainer allows anonymous reads and listing of blobs
+	PublicAccessContainer = azStorageBlob.PublicAccessTypeContainer
+)
+
+// ContainerProperties are the properties of the storer's container
+type ContainerProperties struct {
+	ETag          *string
+	LastModified  *time.Time
+	Metadata      map[string]string
+	PublicAccess  PublicAccess
+	LeaseState    string // eg "available" or "leased"
+	LeaseStatus   string // "locked" or "unlocked"
+	LeaseDuration string // "infinite" or "fixed", only set while leased
+}
+
+// WithoutContainerCheck stops Write and WriteStream checking that the container
+// exists before the content is uploaded. The upload fails regardless if it does
+// not, the check only makes the failure quicker.
+func WithoutContainerCheck() StorerOption {
+	return func(a *Storer) {
+		a.skipContainerCheck = true
+	}
+}
+
+// checkContainer checks that the container exists. The first successful check
+// is cached, so that it costs a request only once per storer. The cache is
+// cleared by DeleteContainer and by writes which fail as the container does not
+// exist.
+func (azp *Storer) checkContainer(ctx context.Context) error {
+	if azp.skipContainerCheck || azp.containerExists.Load() {
+		return nil
+	}
+	azp.log.Debugf("Checking container URL %s", azp.containerURL)
+	_, err := azp.containerClient.GetProperties(ctx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	azp.containerExists.Store(true)
+	return nil
+}
+
+// containerChecked clears the cached container check if err reports that the
+// container does not exist, and returns err.
+func (azp *Storer) containerChecked(err error) error {
+	if errors.Is(err, ErrContainerNotFound) {
+		azp.containerExists.Store(false)
+	}
+	return err
+}
+
+// CreateContainer creates the storer's container. It is an error if the
+// container already exists. WithMetadata sets the container metadata and
+// WithPublicAccess allows anonymous reads.
+func (azp *Storer) CreateContainer(ctx context.Context, opts ...Option) error {
+	azp.log.Debugf("CreateContainer %s", azp.Container)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	createOptions := &azStorageBlob.ContainerCreateOptions{
+		Metadata: options.metadata,
+	}
+	if options.publicAccess != PublicAccessNone {
+		createOptions.Access = &options.publicAccess
+	}
+	_, err := azp.containerClient.Create(ctx, createOptions)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	azp.containerExists.Store(true)
+	return nil
+}
+
+// CreateContainerIfNotExists creates the storer's container, as for
+// CreateContainer, unless it already exists. It returns true if the container
+// was created. The options are ignored for an existing container.
+func (azp *Storer) CreateContainerIfNotExists(ctx context.Context, opts ...Option) (bool, error) {
+
+	err := azp.CreateContainer(ctx, opts...)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrAlreadyExists) {
+		azp.containerExists.Store(true)
+		return false, nil
+	}
+	return false, err
+}
+
+// DeleteContainer deletes the storer's container and all of the blobs in it.
+// The container name can't be re-used for a time after the delete, as the
+// service deletes it asynchronously. WithLeaseID is required if the container
+// is leased. The since options make the delete conditional.
+func (azp *Storer) DeleteContainer(ctx context.Context, opts ...Option) error {
+	azp.log.Debugf("DeleteContainer %s", azp.Container)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := containerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	azp.containerExists.Store(false)
+	_, err = azp.containerClient.Delete(ctx, &azStorageBlob.ContainerDeleteOptions{
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// GetContainerProperties returns the properties, including the metadata, of
+// the storer's container. If WithLeaseID is given, the container must have
+// that lease.
+func (azp *Storer) GetContainerProperties(ctx context.Context, opts ...Option) (*ContainerProperties, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	getOptions := &azStorageBlob.ContainerGetPropertiesOptions{}
+	if options.leaseID != "" {
+		getOptions.LeaseAccessConditions = &azStorageBlob.LeaseAccessConditions{LeaseID: &options.leaseID}
+	}
+	r, err := azp.containerClient.GetProperties(ctx, getOptions)
+	if err != nil {
+		return nil, azp.containerChecked(ErrorFromError(err))
+	}
+	azp.containerExists.Store(true)
+
+	props := &ContainerProperties{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		Metadata:     r.Metadata,
+	}
+	if r.BlobPublicAccess != nil {
+		props.PublicAccess = *r.BlobPublicAccess
+	}
+	if r.LeaseState != nil {
+		props.LeaseState = string(*r.LeaseState)
+	}
+	if r.LeaseStatus != nil {
+		props.LeaseStatus = string(*r.LeaseStatus)
+	}
+	if r.LeaseDuration != nil {
+		props.LeaseDuration = string(*r.LeaseDuration)
+	}
+	return props, nil
+}
+
+// SetContainerMetadata replaces the metadata of the storer's container.
+// WithLeaseID is required if the container is leased. WithModifiedSince makes
+// the change conditional, the other conditions are not supported for
+// containers.
+func (azp *Storer) SetContainerMetadata(ctx context.Context, metadata map[string]string, opts ...Option) error {
+	azp.log.Debugf("SetContainerMetadata %s", azp.Container)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := containerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	_, err = azp.containerClient.SetMetadata(ctx, &azStorageBlob.ContainerSetMetadataOptions{
+		Metadata:                 metadata,
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	})
+	if err != nil {
+		return azp.containerChecked(ErrorFromError(err))
+	}
+	return nil
+}
+
+// SetContainerAccess sets the level of anonymous read access to the storer's
+// container. Note that this also removes any stored access policies.
+// WithLeaseID is required if the container is leased. The since options make
+// the change conditional.
+func (azp *Storer) SetContainerAccess(ctx context.Context, access PublicAccess, opts ...Option) error {
+	azp.log.Debugf("SetContainerAccess %s: %s", azp.Container, access)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := containerOptionConditions(options)
+	if err != nil {
+		return err
+	}
+	policyOptions := &azStorageBlob.ContainerSetAccessPolicyOptions{
+		AccessConditions: &azStorageBlob.ContainerAccessConditions{
+			LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+			ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+		},
+	}
+	if access != PublicAccessNone {
+		policyOptions.Access = &access
+	}
+	_, err = azp.containerClient.SetAccessPolicy(ctx, policyOptions)
+	if err != nil {
+		return azp.containerChecked(ErrorFromError(err))
+	}
+	return nil
+}
+
+// containerOptionConditions returns the conditions for a container operation.
+// Containers only support lease and since conditions.
+func containerOptionConditions(options *StorerOptions) (azStorageBlob.BlobAccessConditions, error) {
+	if options.etagCondition != EtagNotUsed {
+		return azStorageBlob.BlobAccessConditions{}, NewStatusError(
+			"etag and tag conditions are not supported for containers", http.StatusBadRequest)
+	}
+	return storerOptionConditions(options)
+}
+
+// AcquireContainerLease gets a lease on the storer's container. A leased
+// container can only be deleted with the lease, it does not otherwise restrict
+// access to the container or its blobs. leaseTimeout is in seconds, between 15
+// and 60, or -1 for a lease which never expires.
+func (azp *Storer) AcquireContainerLease(ctx context.Context, leaseTimeout int32) (string, error) {
+	azp.log.Debugf("AcquireContainerLease %s", azp.Container)
+
+	leaseClient, err := azp.containerClient.NewContainerLeaseClient(nil)
+	if err != nil {
+		return "", ErrorFromError(err)
+	}
+	lease, err := leaseClient.AcquireLease(ctx, &azStorageBlob.ContainerAcquireLeaseOptions{
+		Duration: &leaseTimeout,
+	})
+	if err != nil {
+		return "", azp.containerChecked(ErrorFromError(err))
+	}
+	return *lease.LeaseID, nil
+}
+
+// RenewContainerLease renews a lease on the storer's container
+func (azp *Storer) RenewContainerLease(ctx context.Context, leaseID string) error {
+	azp.log.Debugf("RenewContainerLease %s", azp.Container)
+
+	leaseClient, err := azp.containerClient.NewContainerLeaseClient(&leaseID)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	_, err = leaseClient.RenewLease(ctx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// ReleaseContainerLease releases a lease on the storer's container. As for
+// ReleaseLease, the lease is released even if ctx is done.
+func (azp *Storer) ReleaseContainerLease(ctx context.Context, leaseID string) error {
+	azp.log.Debugf("ReleaseContainerLease %s", azp.Container)
+
+	leaseClient, err := azp.containerClient.NewContainerLeaseClient(&leaseID)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	newCtx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeoutSecs*time.Second)
+	defer cancel()
+	_, err = leaseClient.ReleaseLease(newCtx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// BreakContainerLease ends the lease on the storer's container without the
+// lease id. The lease ends after breakPeriod seconds, or at the end of a fixed
+// lease if that is sooner. The time remaining, in seconds, is returned.
+func (azp *Storer) BreakContainerLease(ctx context.Context, breakPeriod int32) (int32, error) {
+	azp.log.Debugf("BreakContainerLease %s", azp.Container)
+
+	leaseClient, err := azp.containerClient.NewContainerLeaseClient(nil)
+	if err != nil {
+		return 0, ErrorFromError(err)
+	}
+	r, err := leaseClient.BreakLease(ctx, &azStorageBlob.ContainerBreakLeaseOptions{
+		BreakPeriod: &breakPeriod,
+	})
+	if err != nil {
+		return 0, ErrorFromError(err)
+	}
+	if r.LeaseTime == nil {
+		return 0, nil
+	}
+	return *r.LeaseTime, nil
+}
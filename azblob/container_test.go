@@ -0,0 +1,207 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// fakeTransport returns the responses in turn, and records the request
+// bodies, which are empty for requests without one
+type fakeTransport struct {
+	responses []*http.Response
+	bodies    []string
+}
+
+func (t *fakeTransport) Do(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+	}
+	t.bodies = append(t.bodies, string(body))
+	resp := t.responses[0]
+	if len(t.responses) > 1 {
+		t.responses = t.responses[1:]
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+func fakeResponse(statusCode int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+}
+
+// fakeStorer returns a storer for testcontainer whose requests are sent to
+// transport
+func fakeStorer(t *testing.T, transport policy.Transporter) *Storer {
+	// the well known key of the azurite storage emulator
+	credential, err := azStorageBlob.NewSharedKeyCredential(
+		"devstoreaccount1",
+		"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+	)
+	require.NoError(t, err)
+	azp := &Storer{
+		AccountName:   "devstoreaccount1",
+		Container:     "testcontainer",
+		credential:    credential,
+		rootURL:       "http://127.0.0.1:10000/devstoreaccount1/",
+		containerURL:  "http://127.0.0.1:10000/devstoreaccount1/testcontainer",
+		clientOptions: ClientOptions{Transport: transport},
+		log:           logger.Sugar,
+	}
+	azp.serviceClient, err = azStorageBlob.NewServiceClientWithSharedKey(azp.rootURL, credential, azp.azureClientOptions())
+	require.NoError(t, err)
+	azp.containerClient, err = azp.serviceClient.NewContainerClient(azp.Container)
+	require.NoError(t, err)
+	return azp
+}
+
+// storageErrorResponse returns a response with the azure storage error code
+func storageErrorResponse(statusCode int, code azStorageBlob.StorageErrorCode) *http.Response {
+	return fakeResponse(statusCode, http.Header{xMsErrorCodeHeader: {string(code)}})
+}
+
+func TestContainerChecked(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	azp := &Storer{log: logger.Sugar}
+	azp.containerExists.Store(true)
+
+	// only an error reporting the container does not exist clears the cache
+	notFound := NewStatusError("blob not found", http.StatusNotFound)
+	assert.Equal(t, notFound, azp.containerChecked(notFound))
+	assert.True(t, azp.containerExists.Load())
+	assert.NoError(t, azp.containerChecked(nil))
+	assert.True(t, azp.containerExists.Load())
+
+	err := newStorageError("container not found", http.StatusNotFound,
+		string(azStorageBlob.StorageErrorCodeContainerNotFound))
+	assert.Equal(t, err, azp.containerChecked(err))
+	assert.False(t, azp.containerExists.Load())
+}
+
+func TestCheckContainer(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	transport := &fakeTransport{responses: []*http.Response{
+		fakeResponse(http.StatusOK, nil),
+		storageErrorResponse(http.StatusNotFound, azStorageBlob.StorageErrorCodeContainerNotFound),
+		fakeResponse(http.StatusOK, nil),
+		storageErrorResponse(http.StatusNotFound, azStorageBlob.StorageErrorCodeContainerNotFound),
+		storageErrorResponse(http.StatusNotFound, azStorageBlob.StorageErrorCodeContainerNotFound),
+	}}
+	azp := fakeStorer(t, transport)
+	ctx := context.Background()
+
+	// the first successful check is cached
+	require.NoError(t, azp.checkContainer(ctx))
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Len(t, transport.bodies, 1)
+
+	// and cleared by a write which fails as the container does not exist
+	_, err := azp.Write(ctx, "blob", bytes.NewReader([]byte("content")))
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	assert.False(t, azp.containerExists.Load())
+	assert.Len(t, transport.bodies, 2)
+
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.True(t, azp.containerExists.Load())
+	assert.Len(t, transport.bodies, 3)
+
+	// a failed check is not cached
+	azp.containerExists.Store(false)
+	err = azp.checkContainer(ctx)
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	assert.False(t, azp.containerExists.Load())
+	err = azp.checkContainer(ctx)
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+	assert.Len(t, transport.bodies, 5)
+
+	// and the check can be skipped
+	azp.skipContainerCheck = true
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Len(t, transport.bodies, 5)
+}
+
+func TestCreateContainerIfNotExists(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	transport := &fakeTransport{responses: []*http.Response{
+		storageErrorResponse(http.StatusConflict, azStorageBlob.StorageErrorCodeContainerAlreadyExists),
+		fakeResponse(http.StatusAccepted, nil),
+		fakeResponse(http.StatusCreated, nil),
+		storageErrorResponse(http.StatusForbidden, azStorageBlob.StorageErrorCodeInsufficientAccountPermissions),
+	}}
+	azp := fakeStorer(t, transport)
+	ctx := context.Background()
+
+	// an existing container is not an error, and is cached
+	created, err := azp.CreateContainerIfNotExists(ctx)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.True(t, azp.containerExists.Load())
+	require.NoError(t, azp.checkContainer(ctx))
+	assert.Len(t, transport.bodies, 1)
+
+	// deleting the container clears the cache
+	require.NoError(t, azp.DeleteContainer(ctx))
+	assert.False(t, azp.containerExists.Load())
+
+	created, err = azp.CreateContainerIfNotExists(ctx)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.True(t, azp.containerExists.Load())
+	assert.Len(t, transport.bodies, 3)
+
+	azp.containerExists.Store(false)
+	created, err = azp.CreateContainerIfNotExists(ctx)
+	assert.False(t, created)
+	assert.Equal(t, http.StatusForbidden, ErrorFromError(err).StatusCode())
+	assert.False(t, azp.containerExists.Load())
+}
+
+func TestContainerOptionConditions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	options := &StorerOptions{}
+	WithLeaseID("lease")(options)
+	WithUnmodifiedSince(&since)(options)
+	conditions, err := containerOptionConditions(options)
+	require.NoError(t, err)
+	assert.Equal(t, "lease", *conditions.LeaseAccessConditions.LeaseID)
+	assert.Equal(t, &since, conditions.ModifiedAccessConditions.IfUnmodifiedSince)
+
+	// containers have no etag or tag conditions
+	WithEtagMatch("\"etag\"")(options)
+	_, err = containerOptionConditions(options)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+}
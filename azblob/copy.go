@@ -0,0 +1,456 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultCopyPollInterval is the interval at which WaitForCopy reads the
+	// blob properties if no interval is given
+	defaultCopyPollInterval = time.Second
+)
+
+var (
+	// ErrCopyFailed is returned by WaitForCopy, and by Move, if a copy failed
+	// or was aborted
+	ErrCopyFailed = errors.New("blob copy did not succeed")
+	// ErrMoveOtherAccount is returned by Move if the source is not in the
+	// storage account of the storer, as it could not then be deleted
+	ErrMoveOtherAccount = errors.New("move source must be in the same storage account")
+)
+
+// CopyStatus is the state of the copy which created a blob
+type CopyStatus = azStorageBlob.CopyStatusType
+
+const (
+	CopyStatusPending = azStorageBlob.CopyStatusTypePending
+	CopyStatusSuccess = azStorageBlob.CopyStatusTypeSuccess
+	CopyStatusAborted = azStorageBlob.CopyStatusTypeAborted
+	CopyStatusFailed  = azStorageBlob.CopyStatusTypeFailed
+)
+
+// isCopySourceURL returns true if source is a url rather than a blob name
+func isCopySourceURL(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// copyFailedError is returned when a copy has completed without success
+func copyFailedError(identity string, status CopyStatus, description string) *Error {
+	return &Error{
+		err:        fmt.Errorf("%w: %s %s %s", ErrCopyFailed, identity, status, description),
+		statusCode: http.StatusConflict,
+	}
+}
+
+// Copy copies source to the identified blob, server side. The content is not
+// read by the caller. source is either the name of a blob in the container or
+// a url. A url may be for a blob in any container or storage account, which
+// must be readable using the url alone unless it is in the same account as
+// the storer. A sas url (see user delegation sas) can be used for this.
+//
+// The blob has the metadata of the source, unless WithMetadata is given. It
+// has the tags of the source if the source is in the same storage account,
+// unless WithTags is given. WithAccessTier sets the tier of the blob.
+//
+// The ETag, since and lease options make the copy conditional on the state of
+// the blob being replaced. WithSourceEtagMatch makes it conditional on the
+// state of the source.
+//
+// Copies within a storage account usually complete before Copy returns. The
+// CopyStatus of the response is CopyStatusPending if it did not, in which case
+// WaitForCopy waits for the copy to complete and AbortCopy abandons it.
+func (azp *Storer) Copy(
+	ctx context.Context,
+	source string,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("Copy %s: %s", identity, source)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	sourceURL, sourceClient, err := azp.copySource(source)
+	if err != nil {
+		return nil, err
+	}
+	tags := options.tags
+	if tags == nil && sourceClient != nil {
+		resp, tagsErr := sourceClient.GetTags(ctx, nil)
+		if tagsErr != nil {
+			return nil, ErrorFromError(tagsErr)
+		}
+		tags = make(map[string]string, len(resp.BlobTagSet))
+		for _, tag := range resp.BlobTagSet {
+			tags[*tag.Key] = *tag.Value
+		}
+	}
+
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	copyOptions := &azStorageBlob.BlobStartCopyOptions{
+		Metadata:                 options.metadata,
+		TagsMap:                  tags,
+		Tier:                     accessTierOption(options),
+		LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+		ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+	}
+	if options.rehydratePriority != "" {
+		copyOptions.RehydratePriority = &options.rehydratePriority
+	}
+	if options.sourceETag != "" {
+		copyOptions.SourceModifiedAccessConditions = &azStorageBlob.SourceModifiedAccessConditions{
+			SourceIfMatch: &options.sourceETag,
+		}
+	}
+	r, err := blobClient.StartCopyFromURL(ctx, sourceURL, copyOptions)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		VersionID:    r.VersionID,
+		CopyID:       r.CopyID,
+		CopyStatus:   r.CopyStatus,
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w, nil
+}
+
+// copySource returns the url for the copy source. If the source is in the
+// storage account of the storer, it also returns a client for the source,
+// otherwise the client is nil.
+func (azp *Storer) copySource(source string) (string, *azStorageBlob.BlobClient, error) {
+
+	if !isCopySourceURL(source) {
+		blobClient, err := azp.containerClient.NewBlobClient(source)
+		if err != nil {
+			return "", nil, ErrorFromError(err)
+		}
+		return blobClient.URL(), blobClient, nil
+	}
+	if !strings.HasPrefix(source, azp.rootURL) {
+		return source, nil, nil
+	}
+	// the client's url has the storer's SAS, if any, which authorizes the
+	// copy from the source
+	blobClient, err := azp.newBlobClient(source)
+	if err != nil {
+		return "", nil, ErrorFromError(err)
+	}
+	return blobClient.URL(), blobClient, nil
+}
+
+// AbortCopy abandons a pending copy, leaving the identified blob with no
+// content. copyID is the CopyID of the Copy response. WithLeaseID is required
+// if the blob is leased.
+func (azp *Storer) AbortCopy(
+	ctx context.Context,
+	identity string,
+	copyID string,
+	opts ...Option,
+) error {
+	azp.log.Debugf("AbortCopy %s: %s", identity, copyID)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	abortOptions := &azStorageBlob.BlobAbortCopyOptions{}
+	if options.leaseID != "" {
+		abortOptions.LeaseAccessConditions = &azStorageBlob.LeaseAccessConditions{LeaseID: &options.leaseID}
+	}
+	_, err = blobClient.AbortCopyFromURL(ctx, copyID, abortOptions)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// Move copies source to the identified blob, as for Copy, waits for the copy
+// to complete and then deletes the source. The source must be in the storage
+// account of the storer, but may be in another container.
+//
+// The copy is conditional on the source being unchanged since Move started,
+// as is the delete. If the source is changed after the copy, it is not
+// deleted and the error reports ConditionNotMet. The blob is then a copy of
+// the source as it was when Move started. A source which has snapshots can't
+// be deleted, so it is not moved.
+func (azp *Storer) Move(
+	ctx context.Context,
+	source string,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("Move %s: %s", identity, source)
+
+	_, sourceClient, err := azp.copySource(source)
+	if err != nil {
+		return nil, err
+	}
+	if sourceClient == nil {
+		return nil, ErrMoveOtherAccount
+	}
+	props, err := sourceClient.GetProperties(ctx, nil)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if props.ETag == nil {
+		return nil, fmt.Errorf("no etag for move source %s", source)
+	}
+	etag := *props.ETag
+
+	wr, err := azp.Copy(ctx, source, identity, append(opts[:len(opts):len(opts)], WithSourceEtagMatch(etag))...)
+	if err != nil {
+		return nil, err
+	}
+	if wr.CopyStatus != nil && *wr.CopyStatus == CopyStatusPending {
+		rr, err := WaitForCopy(ctx, azp, identity, 0)
+		if err != nil {
+			return nil, err
+		}
+		wr.CopyStatus = &rr.CopyStatus
+	}
+
+	err = deleteBlob(ctx, sourceClient, &azStorageBlob.BlobDeleteOptions{
+		BlobAccessConditions: &azStorageBlob.BlobAccessConditions{
+			ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{IfMatch: &etag},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// WaitForCopy reads the properties of the identified blob every interval
+// until the copy which created it is no longer pending. The last properties
+// read are returned. If the copy failed, or was aborted, the error satisfies
+// errors.Is(err, ErrCopyFailed). The wait is bounded only by ctx. An interval
+// of zero uses a default of one second. The options are passed to Reader.
+func WaitForCopy(
+	ctx context.Context,
+	r Reader,
+	identity string,
+	interval time.Duration,
+	opts ...Option,
+) (*ReaderResponse, error) {
+
+	if interval <= 0 {
+		interval = defaultCopyPollInterval
+	}
+	opts = append(opts[:len(opts):len(opts)], WithGetMetadata(OnlyMetadata))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rr, err := r.Reader(ctx, identity, opts...)
+		if err != nil {
+			return nil, err
+		}
+		switch rr.CopyStatus {
+		case CopyStatusPending:
+		case CopyStatusFailed, CopyStatusAborted:
+			return rr, copyFailedError(identity, rr.CopyStatus, rr.CopyStatusDescription)
+		default:
+			return rr, nil
+		}
+		select {
+		case <-ctx.Done():
+			return rr, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// copySource returns the name of the copy source. Local stores can only copy
+// blobs within the store, a url is accepted if its path is for a blob in the
+// container of the store.
+func (s *localStore) copySource(source string) (string, error) {
+
+	if !isCopySourceURL(source) {
+		return source, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", NewStatusError(fmt.Sprintf("bad copy source %s: %v", source, err), http.StatusBadRequest)
+	}
+	if _, identity, ok := strings.Cut(u.Path, "/"+s.container+"/"); ok && identity != "" {
+		return identity, nil
+	}
+	return "", newStorageError(
+		fmt.Sprintf("could not verify the copy source: %s", source),
+		http.StatusNotFound, errCodeCannotVerifyCopySource)
+}
+
+// Copy copies a blob within the store, honouring the same options as
+// Storer.Copy. Local copies always complete before Copy returns.
+func (s *localStore) Copy(
+	ctx context.Context,
+	source string,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Copy local blob %s: %s", identity, source)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sourceIdentity, err := s.copySource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := s.backend.load(sourceIdentity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	src = rehydrated(src, s.now())
+	if src == nil {
+		return nil, blobNotFoundError(sourceIdentity)
+	}
+	if options.sourceETag != "" && options.sourceETag != src.ETag {
+		return nil, newStorageError(
+			"the condition specified using HTTP conditional header(s) is not met for the source",
+			http.StatusPreconditionFailed, errCodeSourceConditionNotMet)
+	}
+	if src.BlobType == "" && localAccessTier(src) == AccessTierArchive {
+		return nil, newStorageError(
+			fmt.Sprintf("this operation is not permitted on an archived blob: %s", sourceIdentity),
+			http.StatusConflict, errCodeBlobArchived)
+	}
+
+	metadata := src.Metadata
+	if options.metadata != nil {
+		metadata = options.metadata
+	}
+	tags := src.Tags
+	if options.tags != nil {
+		tags = options.tags
+	}
+	wr, err := s.putLocked(&localBlob{
+		Name:                identity,
+		Data:                bytes.Clone(src.Data),
+		Metadata:            metadata,
+		Tags:                tags,
+		ContentMD5:          src.ContentMD5,
+		BlobType:            src.BlobType,
+		CommittedBlockCount: src.CommittedBlockCount,
+		AccessTier:          string(options.accessTier),
+		Pages:               slices.Clone(src.Pages),
+		CopyID:              uuid.NewString(),
+		CopyStatus:          string(CopyStatusSuccess),
+		CopySource:          source,
+		CopyProgress:        fmt.Sprintf("%d/%d", len(src.Data), len(src.Data)),
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	copied, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	// the response must not refer to the stored blob
+	copyID := copied.CopyID
+	copyStatus := CopyStatusSuccess
+	wr.CopyID = &copyID
+	wr.CopyStatus = &copyStatus
+	if copied.VersionID != "" {
+		versionID := copied.VersionID
+		wr.VersionID = &versionID
+	}
+	return wr, nil
+}
+
+// AbortCopy fails, as local copies are never pending, see Storer.AbortCopy
+func (s *localStore) AbortCopy(
+	ctx context.Context,
+	identity string,
+	copyID string,
+	opts ...Option,
+) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if b == nil {
+		return blobNotFoundError(identity)
+	}
+	if b.CopyID != copyID {
+		return newStorageError(
+			"the copy id specified does not match the copy id for the pending copy operation",
+			http.StatusConflict, errCodeCopyIDMismatch)
+	}
+	return newStorageError(
+		"there is currently no pending copy operation",
+		http.StatusConflict, errCodeNoPendingCopyOperation)
+}
+
+// Move copies a blob within the store and then deletes the source, see
+// Storer.Move
+func (s *localStore) Move(
+	ctx context.Context,
+	source string,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Move local blob %s: %s", identity, source)
+
+	sourceIdentity, err := s.copySource(source)
+	if err != nil {
+		return nil, err
+	}
+	rr, err := s.Reader(ctx, sourceIdentity, WithGetMetadata(OnlyMetadata))
+	if err != nil {
+		return nil, err
+	}
+	etag := *rr.ETag
+
+	wr, err := s.Copy(ctx, source, identity, append(opts[:len(opts):len(opts)], WithSourceEtagMatch(etag))...)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.Delete(ctx, sourceIdentity, WithEtagMatch(etag)); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
@@ -0,0 +1,79 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreCopy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, err := store.Put(ctx, "src", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(map[string]string{"key": "value"}), WithTags(map[string]string{"owner": "me"}))
+			require.NoError(t, err)
+
+			wr, err := store.Copy(ctx, "src", "dst")
+			require.NoError(t, err)
+			require.NotNil(t, wr.CopyStatus)
+			assert.Equal(t, CopyStatusSuccess, *wr.CopyStatus)
+			rr, err := WaitForCopy(ctx, store, "dst", time.Millisecond, WithGetTags())
+			require.NoError(t, err)
+			assert.Equal(t, *wr.CopyID, rr.CopyID)
+
+			// the response does not refer to the stored blob
+			copyID := *wr.CopyID
+			*wr.CopyID = "changed"
+			rr, err = WaitForCopy(ctx, store, "dst", time.Millisecond, WithGetTags())
+			require.NoError(t, err)
+			assert.Equal(t, copyID, rr.CopyID)
+			*wr.CopyID = copyID
+			assert.Equal(t, "5/5", rr.CopyProgress)
+			assert.Equal(t, "value", rr.Metadata["Key"])
+			assert.Equal(t, map[string]string{"owner": "me"}, rr.Tags)
+
+			// the metadata and tags can be replaced, and a url in the container is accepted
+			_, err = store.Copy(ctx, "https://account.blob.core.windows.net/testcontainer/src", "dst",
+				WithMetadata(map[string]string{"other": "value"}), WithTags(map[string]string{"owner": "you"}))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "dst", WithGetTags())
+			require.NoError(t, err)
+			assert.Equal(t, "VALUE", readAll(t, rr))
+			assert.Equal(t, map[string]string{"Other": "value"}, rr.Metadata)
+			assert.Equal(t, map[string]string{"owner": "you"}, rr.Tags)
+
+			_, err = store.Copy(ctx, "https://account.blob.core.windows.net/othercontainer/src", "dst")
+			assert.Equal(t, errCodeCannotVerifyCopySource, ErrorFromError(err).StorageErrorCode())
+			_, err = store.Copy(ctx, "src", "dst", WithSourceEtagMatch("0x0"))
+			assert.Equal(t, errCodeSourceConditionNotMet, ErrorFromError(err).StorageErrorCode())
+			err = store.AbortCopy(ctx, "dst", *wr.CopyID)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.Move(ctx, "src", "moved")
+			require.NoError(t, err)
+			_, err = store.Reader(ctx, "src")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			rr, err = store.Reader(ctx, "moved")
+			require.NoError(t, err)
+			assert.Equal(t, "VALUE", readAll(t, rr))
+
+			// a leased source is copied but not deleted
+			leaseID, err := store.AcquireLease(ctx, "moved", 15)
+			require.NoError(t, err)
+			_, err = store.Move(ctx, "moved", "again")
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+			_, err = store.Reader(ctx, "moved", WithLeaseID(leaseID))
+			require.NoError(t, err)
+		})
+	}
+}
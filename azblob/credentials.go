@@ -6,9 +6,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/storage/mgmt/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 
@@ -21,6 +25,10 @@ const (
 	tryTimeoutSecs = 30
 )
 
+var (
+	ErrInvalidConnectionString = errors.New("invalid connection string")
+)
+
 // credentials gets credentials from env or file
 func credentials(
 	accountName string,
@@ -74,3 +82,275 @@ func credentials(
 
 	return secret, cred, err
 }
+
+// NewWithCredential returns a read/write storer for the container at the
+// service url which is authorized by an azure ad credential, eg
+// azidentity.NewWorkloadIdentityCredential. Unlike New, it needs no
+// management plane (ARM) permissions, the identity only needs a data plane
+// role such as "Storage Blob Data Contributor". User delegation SAS are signed
+// with the credential, see Storer.GenerateBlobSAS.
+func NewWithCredential(
+	log Logger,
+	url string,
+	container string,
+	credential azcore.TokenCredential,
+	options ...StorerOption,
+) (*Storer, error) {
+	log.Debugf("NewWithCredential Storer: %s%s", url, container)
+
+	if url == "" {
+		return nil, errors.New("url is a required parameter and cannot be empty")
+	}
+	if credential == nil {
+		return nil, errors.New("credential is a required parameter and cannot be nil")
+	}
+	if container == "" {
+		log.Infof("Storer: %v", ErrUnspecifiedContainer)
+		return nil, ErrUnspecifiedContainer
+	}
+	url = strings.TrimSuffix(url, "/") + "/"
+	azp := &Storer{
+		AccountName:     accountNameFromURL(url),
+		Container:       container,
+		tokenCredential: credential,
+		rootURL:         url,
+		log:             log,
+	}
+	if err := azp.connect(options); err != nil {
+		return nil, err
+	}
+	return azp, nil
+}
+
+// NewFromConnectionString returns a storer for the container from an azure
+// storage connection string, as shown by the azure portal. The connection
+// string has either an AccountKey, or a SharedAccessSignature which limits the
+// storer to what the SAS permits. The SAS also authorizes the sources of Copy
+// and Move in the storer's account, but not DeleteBatch and SetTierBatch.
+// UseDevelopmentStorage=true connects to azurite, as for NewDev.
+func NewFromConnectionString(
+	log Logger,
+	connectionString string,
+	container string,
+	options ...StorerOption,
+) (*Storer, error) {
+
+	cs, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("NewFromConnectionString Storer: %s%s", cs.url, container)
+
+	if container == "" {
+		log.Infof("Storer: %v", ErrUnspecifiedContainer)
+		return nil, ErrUnspecifiedContainer
+	}
+	azp := &Storer{
+		AccountName: cs.accountName,
+		Container:   container,
+		sas:         cs.sas,
+		rootURL:     cs.url,
+		log:         log,
+	}
+	if cs.sas == "" {
+		azp.credential, err = azStorageBlob.NewSharedKeyCredential(cs.accountName, cs.accountKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = azp.connect(options); err != nil {
+		return nil, err
+	}
+	return azp, nil
+}
+
+// NewFromSecretsFile returns a storer for the container with the account, url
+// and shared key read from a secrets file, see secrets.New. This is the
+// storer New makes, without the management plane (ARM) request for the key.
+func NewFromSecretsFile(
+	log Logger,
+	secretsFile string,
+	container string,
+	options ...StorerOption,
+) (*Storer, error) {
+
+	secret, err := secrets.New(secretsFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("NewFromSecretsFile Storer: %s%s", secret.URL, container)
+
+	if secret.Account == "" || secret.URL == "" || secret.Key == "" {
+		return nil, errors.New("secrets file must have the account, url and key")
+	}
+	if container == "" {
+		log.Infof("Storer: %v", ErrUnspecifiedContainer)
+		return nil, ErrUnspecifiedContainer
+	}
+	credential, err := azStorageBlob.NewSharedKeyCredential(secret.Account, secret.Key)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(secret.URL, "/") + "/"
+	azp := &Storer{
+		AccountName: secret.Account,
+		Container:   container,
+		credential:  credential,
+		rootURL:     url,
+		log:         log,
+	}
+	if err = azp.connect(options); err != nil {
+		return nil, err
+	}
+	return azp, nil
+}
+
+// connect applies the options and creates the service and container clients
+// for the storer's credential, or SAS. It is the construction shared by the
+// constructors, which set the account, container, rootURL and credential.
+func (azp *Storer) connect(options []StorerOption) error {
+
+	for _, option := range options {
+		option(azp)
+	}
+
+	azp.containerURL = fmt.Sprintf(
+		"%s%s",
+		azp.rootURL,
+		azp.Container,
+	)
+	var err error
+	switch {
+	case azp.credential != nil:
+		azp.serviceClient, err = azStorageBlob.NewServiceClientWithSharedKey(azp.rootURL, azp.credential, azp.azureClientOptions())
+	case azp.tokenCredential != nil:
+		azp.serviceClient, err = azStorageBlob.NewServiceClient(azp.rootURL, azp.tokenCredential, azp.azureClientOptions())
+	default:
+		azp.serviceClient, err = azStorageBlob.NewServiceClientWithNoCredential(azp.withSAS(azp.rootURL), azp.azureClientOptions())
+	}
+	if err != nil {
+		azp.log.Infof("unable to create serviceclient %s: %v", azp.containerURL, err)
+		return err
+	}
+	azp.containerClient, err = azp.serviceClient.NewContainerClient(azp.Container)
+	if err != nil {
+		azp.log.Infof("unable to create containerclient %s: %v", azp.Container, err)
+		return err
+	}
+	return nil
+}
+
+// newBlobClient returns a client, with the storer's credential or SAS, for a
+// blob url in the storer's account
+func (azp *Storer) newBlobClient(blobURL string) (*azStorageBlob.BlobClient, error) {
+	switch {
+	case azp.credential != nil:
+		return azStorageBlob.NewBlobClientWithSharedKey(blobURL, azp.credential, azp.azureClientOptions())
+	case azp.tokenCredential != nil:
+		return azStorageBlob.NewBlobClient(blobURL, azp.tokenCredential, azp.azureClientOptions())
+	default:
+		return azStorageBlob.NewBlobClientWithNoCredential(azp.withSAS(blobURL), azp.azureClientOptions())
+	}
+}
+
+// withSAS returns rawURL with the storer's SAS added to its query, unless the
+// url is already signed
+func (azp *Storer) withSAS(rawURL string) string {
+	if azp.sas == "" {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Query().Has("sig") {
+		return rawURL
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += azp.sas
+	return u.String()
+}
+
+// connectionString is a parsed azure storage connection string
+type connectionString struct {
+	accountName string
+	accountKey  string
+	sas         string
+	// url is the blob service url, with a trailing slash
+	url string
+}
+
+// parseConnectionString parses an azure storage connection string, which is
+// a list of key=value settings separated by semicolons. Only the settings for
+// the blob service are used.
+func parseConnectionString(s string) (*connectionString, error) {
+
+	settings := map[string]string{}
+	for _, setting := range strings.Split(s, ";") {
+		if strings.TrimSpace(setting) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(setting, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: setting has no value", ErrInvalidConnectionString)
+		}
+		settings[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	if strings.EqualFold(settings["UseDevelopmentStorage"], "true") {
+		cfg := NewDevConfigFromEnv()
+		return &connectionString{
+			accountName: cfg.AccountName,
+			accountKey:  cfg.Key,
+			url:         strings.TrimSuffix(cfg.URL, "/") + "/",
+		}, nil
+	}
+
+	cs := &connectionString{
+		accountName: settings["AccountName"],
+		accountKey:  settings["AccountKey"],
+		sas:         strings.TrimPrefix(settings["SharedAccessSignature"], "?"),
+	}
+	if endpoint := settings["BlobEndpoint"]; endpoint != "" {
+		cs.url = strings.TrimSuffix(endpoint, "/") + "/"
+		if cs.accountName == "" {
+			cs.accountName = accountNameFromURL(cs.url)
+		}
+	} else {
+		if cs.accountName == "" {
+			return nil, fmt.Errorf("%w: missing AccountName or BlobEndpoint", ErrInvalidConnectionString)
+		}
+		protocol := settings["DefaultEndpointsProtocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := settings["EndpointSuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		cs.url = fmt.Sprintf("%s://%s.blob.%s/", protocol, cs.accountName, suffix)
+	}
+	if cs.accountKey == "" && cs.sas == "" {
+		return nil, fmt.Errorf("%w: missing AccountKey or SharedAccessSignature", ErrInvalidConnectionString)
+	}
+	if cs.accountKey != "" && cs.accountName == "" {
+		return nil, fmt.Errorf("%w: AccountKey requires AccountName", ErrInvalidConnectionString)
+	}
+	return cs, nil
+}
+
+// accountNameFromURL returns the storage account name from a blob service
+// url, which is the first label of the host name. For an azurite url, where
+// the host is an ip address or localhost, it is the first path segment.
+func accountNameFromURL(serviceURL string) string {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return ""
+	}
+	host := u.Hostname()
+	if host == "localhost" || net.ParseIP(host) != nil {
+		account, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		return account
+	}
+	account, _, _ := strings.Cut(host, ".")
+	return account
+}
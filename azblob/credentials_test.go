@@ -0,0 +1,161 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestParseConnectionString(t *testing.T) {
+	tests := []struct {
+		name             string
+		connectionString string
+		expected         *connectionString
+		err              error
+	}{
+		{
+			name:             "account key",
+			connectionString: "DefaultEndpointsProtocol=https;AccountName=acc;AccountKey=a2V5;EndpointSuffix=core.windows.net",
+			expected: &connectionString{
+				accountName: "acc",
+				accountKey:  "a2V5",
+				url:         "https://acc.blob.core.windows.net/",
+			},
+		},
+		{
+			name:             "defaults and trailing separator",
+			connectionString: "AccountName=acc;AccountKey=a2V5;",
+			expected: &connectionString{
+				accountName: "acc",
+				accountKey:  "a2V5",
+				url:         "https://acc.blob.core.windows.net/",
+			},
+		},
+		{
+			name:             "other cloud",
+			connectionString: "DefaultEndpointsProtocol=http;AccountName=acc;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn",
+			expected: &connectionString{
+				accountName: "acc",
+				accountKey:  "a2V5",
+				url:         "http://acc.blob.core.chinacloudapi.cn/",
+			},
+		},
+		{
+			name:             "sas with blob endpoint",
+			connectionString: "BlobEndpoint=https://acc.blob.core.windows.net;SharedAccessSignature=sv=2020-02-10&sig=c2ln",
+			expected: &connectionString{
+				accountName: "acc",
+				sas:         "sv=2020-02-10&sig=c2ln",
+				url:         "https://acc.blob.core.windows.net/",
+			},
+		},
+		{
+			name:             "azurite blob endpoint",
+			connectionString: "AccountName=devstoreaccount1;AccountKey=a2V5;BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1",
+			expected: &connectionString{
+				accountName: "devstoreaccount1",
+				accountKey:  "a2V5",
+				url:         "http://127.0.0.1:10000/devstoreaccount1/",
+			},
+		},
+		{
+			name:             "no credential",
+			connectionString: "AccountName=acc",
+			err:              ErrInvalidConnectionString,
+		},
+		{
+			name:             "no account",
+			connectionString: "AccountKey=a2V5",
+			err:              ErrInvalidConnectionString,
+		},
+		{
+			name:             "no value",
+			connectionString: "AccountName",
+			err:              ErrInvalidConnectionString,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs, err := parseConnectionString(test.connectionString)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "unexpected error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, cs)
+		})
+	}
+}
+
+func TestAccountNameFromURL(t *testing.T) {
+	assert.Equal(t, "acc", accountNameFromURL("https://acc.blob.core.windows.net/"))
+	assert.Equal(t, "devstoreaccount1", accountNameFromURL("http://127.0.0.1:10000/devstoreaccount1/"))
+	assert.Equal(t, "devstoreaccount1", accountNameFromURL("http://localhost:10000/devstoreaccount1"))
+}
+
+func TestNewFromConnectionStringSAS(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	azp, err := NewFromConnectionString(logger.Sugar,
+		"BlobEndpoint=https://acc.blob.core.windows.net;SharedAccessSignature=sv=2020-02-10&sig=c2ln",
+		"testcontainer")
+	require.NoError(t, err)
+	assert.Equal(t, "https://acc.blob.core.windows.net/testcontainer", azp.containerURL)
+	assert.Equal(t, "https://acc.blob.core.windows.net/testcontainer?sv=2020-02-10&sig=c2ln", azp.containerClient.URL())
+
+	// the copy sources in the account are authorized by the SAS
+	sourceURL, sourceClient, err := azp.copySource("blob")
+	require.NoError(t, err)
+	assert.Equal(t, "https://acc.blob.core.windows.net/testcontainer/blob?sv=2020-02-10&sig=c2ln", sourceURL)
+	assert.Equal(t, sourceURL, sourceClient.URL())
+
+	sourceURL, sourceClient, err = azp.copySource("https://acc.blob.core.windows.net/other/blob?snapshot=2024-01-01T00:00:00.0000000Z")
+	require.NoError(t, err)
+	assert.Equal(t,
+		"https://acc.blob.core.windows.net/other/blob?snapshot=2024-01-01T00:00:00.0000000Z&sv=2020-02-10&sig=c2ln",
+		sourceURL)
+	assert.Equal(t, sourceURL, sourceClient.URL())
+
+	// unless they are signed already
+	signed := "https://acc.blob.core.windows.net/other/blob?sv=2021-12-02&sig=b3RoZXI%3D"
+	sourceURL, _, err = azp.copySource(signed)
+	require.NoError(t, err)
+	assert.Equal(t, signed, sourceURL)
+
+	// and other accounts are not sent the SAS
+	sourceURL, sourceClient, err = azp.copySource("https://other.blob.core.windows.net/other/blob")
+	require.NoError(t, err)
+	assert.Equal(t, "https://other.blob.core.windows.net/other/blob", sourceURL)
+	assert.Nil(t, sourceClient)
+
+	_, err = azp.DeleteBatch(context.Background(), []string{"blob"})
+	assert.ErrorIs(t, err, ErrBatchNoCredential)
+}
+
+func TestNewDev(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	azp, err := NewDev(DevConfig{
+		AccountName: azuriteWellKnownAccount,
+		Key:         azuriteWellKnownKey,
+		URL:         "http://127.0.0.1:10000/devstoreaccount1",
+	}, "testcontainer", WithoutContainerCheck())
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:10000/devstoreaccount1/testcontainer", azp.containerURL)
+	assert.Equal(t, azp.containerURL, azp.containerClient.URL())
+	assert.NotNil(t, azp.credential)
+	assert.True(t, azp.skipContainerCheck)
+
+	// the blob clients have the shared key
+	sourceURL, sourceClient, err := azp.copySource(azp.containerURL + "/blob")
+	require.NoError(t, err)
+	assert.Equal(t, azp.containerURL+"/blob", sourceURL)
+	assert.NotNil(t, sourceClient)
+}
@@ -3,27 +3,76 @@ package azblob
 import (
 	"context"
 	"errors"
+	"net/http"
+	"time"
 
 	msazblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/datatrails/go-datatrails-common/logger"
 )
 
-// Delete the identified blob
+// Delete the identified blob. It is not an error if the blob does not exist,
+// unless the delete is conditional.
+//
+// WithLeaseID is required to delete a leased blob. The ETag and since options
+// make the delete conditional, so that a blob which has been changed by
+// another writer is not deleted. A conditional delete of a blob which does not
+// exist fails, as the condition can't be met, and the error satisfies
+// errors.Is(err, ErrBlobNotFound).
+//
+// A blob which has snapshots can only be deleted using WithDeleteSnapshots.
+// WithSnapshot or WithVersionID delete a single snapshot or prior version.
+//
+// If soft delete is enabled for the container, the deleted blob can be
+// restored using Undelete until the retention period expires.
 func (azp *Storer) Delete(
 	ctx context.Context,
 	identity string,
+	opts ...Option,
 ) error {
 	logger.Sugar.Debugf("Delete blob %s", identity)
 
-	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
 	if err != nil {
-		logger.Sugar.Infof("Cannot get block blob client blob: %v", err)
-		return ErrorFromError(err)
+		return err
+	}
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		logger.Sugar.Infof("Cannot get blob client blob: %v", err)
+		return err
 	}
 
-	_, err = blockBlobClient.Delete(ctx, nil)
+	o := &msazblob.BlobDeleteOptions{
+		BlobAccessConditions: &blobAccessConditions,
+	}
+	var deleteSnapshots msazblob.DeleteSnapshotsOptionType
+	switch options.deleteSnapshots {
+	case DeleteSnapshotsInclude:
+		deleteSnapshots = msazblob.DeleteSnapshotsOptionTypeInclude
+		o.DeleteSnapshots = &deleteSnapshots
+	case DeleteSnapshotsOnly:
+		deleteSnapshots = msazblob.DeleteSnapshotsOptionTypeOnly
+		o.DeleteSnapshots = &deleteSnapshots
+	default:
+	}
+
+	return deleteBlob(ctx, blobClient, o)
+}
+
+// deleteBlob deletes the blob using blobClient. It is not an error if the blob
+// does not exist, unless o has access conditions.
+func deleteBlob(ctx context.Context, blobClient *msazblob.BlobClient, o *msazblob.BlobDeleteOptions) error {
+
+	_, err := blobClient.Delete(ctx, o)
+	conditional := o.BlobAccessConditions != nil &&
+		(o.BlobAccessConditions.LeaseAccessConditions != nil ||
+			o.BlobAccessConditions.ModifiedAccessConditions != nil)
 	var terr *msazblob.StorageError
-	if errors.As(err, &terr) {
+	if !conditional && errors.As(err, &terr) {
 		resp := terr.Response()
 		if resp.Body != nil {
 			defer resp.Body.Close()
@@ -32,6 +81,242 @@ func (azp *Storer) Delete(
 			return nil
 		}
 	}
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// Undelete restores a soft deleted blob, and any soft deleted snapshots of
+// it. Soft delete must be enabled for the container. WithListDeleted lists the
+// soft deleted blobs.
+//
+// If versioning is enabled, deleted blobs are kept as prior versions instead.
+// Use PromoteVersion to restore one.
+func (azp *Storer) Undelete(
+	ctx context.Context,
+	identity string,
+) error {
+	logger.Sugar.Debugf("Undelete blob %s", identity)
+
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	_, err = blobClient.Undelete(ctx, nil)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// SetSoftDelete enables the emulation of soft delete, with the given retention
+// period, or disables it if retention is zero. For azure, soft delete is a
+// property of the storage account.
+//
+// When enabled, deleted blobs, snapshots and versions are kept until the
+// retention period expires. Unlike azure, overwriting a blob does not keep a
+// soft deleted snapshot of it. If versioning is also enabled, deleted blobs
+// are kept as prior versions rather than soft deleted.
+func (s *localStore) SetSoftDelete(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.softDeleteRetention = retention
+}
+
+// Delete a blob, see Storer.Delete
+func (s *localStore) Delete(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) error {
+	s.log.Debugf("Delete local blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if options.snapshot != "" || options.versionID != "" {
+		h, err := s.loadSelected(identity, options)
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			return missingOnDelete(identity, options)
+		}
+		return s.discardHistory(h, now)
+	}
 
-	return err
+	b, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if b == nil {
+		return missingOnDelete(identity, options)
+	}
+	if err = checkLease(b, options.leaseID, now, true); err != nil {
+		return err
+	}
+	if _, err = checkConditions(b, options, false); err != nil {
+		return err
+	}
+	history, err := s.loadHistory(identity)
+	if err != nil {
+		return err
+	}
+	var snapshots []*localBlob
+	for _, h := range history {
+		if h.Snapshot != "" && h.Deleted == "" {
+			snapshots = append(snapshots, h)
+		}
+	}
+	if len(snapshots) > 0 && options.deleteSnapshots == DeleteSnapshotsNotUsed {
+		return newStorageError(
+			"this operation is not permitted because the blob has snapshots",
+			http.StatusConflict, errCodeSnapshotsPresent)
+	}
+	for _, h := range snapshots {
+		if err = s.discardHistory(h, now); err != nil {
+			return err
+		}
+	}
+	if options.deleteSnapshots == DeleteSnapshotsOnly {
+		return nil
+	}
+
+	// with versioning, the deleted blob is kept as a prior version
+	if err = s.keepVersion(b); err != nil {
+		return err
+	}
+	if s.softDeleteRetention > 0 && !s.versioning {
+		deleted := *b
+		deleted.VersionID = ""
+		deleted.localLease = localLease{}
+		deleted.Deleted = s.nextHistoryID(now)
+		if err = s.backend.storeHistory(&deleted); err != nil {
+			return ErrorFromError(err)
+		}
+	}
+	if err = s.backend.remove(identity); err != nil {
+		return ErrorFromError(err)
+	}
+	if err = s.backend.discardBlocks(identity); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// missingOnDelete returns the outcome of deleting a blob which does not exist,
+// see Storer.Delete
+func missingOnDelete(identity string, options *StorerOptions) error {
+	if hasAccessConditions(options) {
+		return blobNotFoundError(identity)
+	}
+	return nil
+}
+
+// Undelete restores a soft deleted blob, see Storer.Undelete. If there is
+// a current blob, only the soft deleted snapshots and versions are restored.
+func (s *localStore) Undelete(
+	ctx context.Context,
+	identity string,
+) error {
+	s.log.Debugf("Undelete local blob %s", identity)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	history, err := s.loadHistory(identity)
+	if err != nil {
+		return err
+	}
+	var deleted *localBlob
+	for _, h := range history {
+		if h.Deleted == "" {
+			continue
+		}
+		if h.Snapshot == "" && h.VersionID == "" {
+			// restore the most recently deleted blob
+			if deleted == nil || h.Deleted > deleted.Deleted {
+				deleted = h
+			}
+			continue
+		}
+		restored := *h
+		restored.Deleted = ""
+		if err = s.backend.storeHistory(&restored); err != nil {
+			return ErrorFromError(err)
+		}
+	}
+	if existing != nil {
+		return nil
+	}
+	if deleted == nil {
+		return blobNotFoundError(identity)
+	}
+	restored := *deleted
+	restored.Deleted = ""
+	if err = s.backend.store(&restored); err != nil {
+		return ErrorFromError(err)
+	}
+	if err = s.backend.removeHistory(deleted); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// loadHistory returns the history of the blob, after removing any soft
+// deleted history whose retention period has expired
+func (s *localStore) loadHistory(identity string) ([]*localBlob, error) {
+
+	history, err := s.backend.loadHistory(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if s.softDeleteRetention == 0 {
+		return history, nil
+	}
+	now := s.now()
+	retained := history[:0]
+	for _, h := range history {
+		if h.Deleted != "" {
+			deletedTime, err := time.Parse(historyIDFormat, h.Deleted)
+			if err == nil && now.Sub(deletedTime) > s.softDeleteRetention {
+				if err = s.backend.removeHistory(h); err != nil {
+					return nil, ErrorFromError(err)
+				}
+				continue
+			}
+		}
+		retained = append(retained, h)
+	}
+	return retained, nil
+}
+
+// discardHistory deletes a snapshot or prior version, which is soft deleted if
+// soft delete is enabled
+func (s *localStore) discardHistory(h *localBlob, now time.Time) error {
+
+	if s.softDeleteRetention == 0 {
+		if err := s.backend.removeHistory(h); err != nil {
+			return ErrorFromError(err)
+		}
+		return nil
+	}
+	deleted := *h
+	deleted.Deleted = s.nextHistoryID(now)
+	if err := s.backend.storeHistory(&deleted); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
 }
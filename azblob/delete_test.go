@@ -0,0 +1,142 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreConditionalDelete(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "blob", NewBytesReaderCloser([]byte("CHANGED")))
+			require.NoError(t, err)
+
+			err = store.Delete(ctx, "blob", WithEtagMatch(*wr.ETag))
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			leaseID, err := store.AcquireLease(ctx, "blob", -1)
+			require.NoError(t, err)
+			err = store.Delete(ctx, "blob")
+			assert.Equal(t, http.StatusPreconditionFailed, ErrorFromError(err).StatusCode())
+
+			_, err = store.Snapshot(ctx, "blob")
+			require.NoError(t, err)
+			err = store.Delete(ctx, "blob", WithLeaseID(leaseID))
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			require.NoError(t, store.Delete(ctx, "blob", WithLeaseID(leaseID), WithDeleteSnapshots(DeleteSnapshotsOnly)))
+			count := 0
+			for _, err := range store.ListAll(ctx, WithListSnapshots()) {
+				require.NoError(t, err)
+				count++
+			}
+			assert.Equal(t, 1, count)
+
+			require.NoError(t, store.Delete(ctx, "blob", WithLeaseID(leaseID)))
+			_, err = store.Reader(ctx, "blob")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			// deleting a blob which does not exist is not an error
+			require.NoError(t, store.Delete(ctx, "blob"))
+		})
+	}
+}
+
+func TestLocalStoreConditionalDeleteMissing(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			require.NoError(t, store.Delete(ctx, "blob"))
+
+			// a compare and delete of a blob which no longer exists fails
+			since := time.Now()
+			for _, opt := range []Option{
+				WithEtagMatch(*wr.ETag),
+				WithUnmodifiedSince(&since),
+				WithLeaseID("00000000-0000-0000-0000-000000000000"),
+			} {
+				err = store.Delete(ctx, "blob", opt)
+				assert.ErrorIs(t, err, ErrBlobNotFound)
+				assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			}
+			require.NoError(t, store.Delete(ctx, "blob"))
+		})
+	}
+}
+
+func TestLocalStoreSoftDelete(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			store.now = func() time.Time { return now }
+			store.SetSoftDelete(time.Hour)
+
+			_, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			_, err = store.Snapshot(ctx, "blob")
+			require.NoError(t, err)
+			require.NoError(t, store.Delete(ctx, "blob", WithDeleteSnapshots(DeleteSnapshotsInclude)))
+
+			_, err = store.Reader(ctx, "blob")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			for _, err := range store.ListAll(ctx, WithListSnapshots()) {
+				require.NoError(t, err)
+				assert.Fail(t, "deleted blobs should only be listed on request")
+			}
+
+			var deleted []bool
+			for item, err := range store.ListAll(ctx, WithListDeleted(), WithListSnapshots()) {
+				require.NoError(t, err)
+				require.NotNil(t, item.Deleted)
+				require.NotNil(t, item.Properties.DeletedTime)
+				deleted = append(deleted, *item.Deleted)
+			}
+			assert.Equal(t, []bool{true, true}, deleted)
+
+			require.NoError(t, store.Undelete(ctx, "blob"))
+			rr, err := store.Reader(ctx, "blob")
+			require.NoError(t, err)
+			assert.Equal(t, "VALUE", readAll(t, rr))
+			count := 0
+			for _, err := range store.ListAll(ctx, WithListDeleted(), WithListSnapshots()) {
+				require.NoError(t, err)
+				count++
+			}
+			assert.Equal(t, 2, count)
+
+			// once the retention period expires the blob can't be restored
+			require.NoError(t, store.Delete(ctx, "blob", WithDeleteSnapshots(DeleteSnapshotsInclude)))
+			now = now.Add(2 * time.Hour)
+			err = store.Undelete(ctx, "blob")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			for _, err := range store.ListAll(ctx, WithListDeleted(), WithListSnapshots()) {
+				require.NoError(t, err)
+				assert.Fail(t, "expired blobs should not be listed")
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package azblob
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// HashAlgorithm identifies the algorithm used for the HashKey metadata
+type HashAlgorithm string
+
+const (
+	HashSHA256     HashAlgorithm = "sha256"
+	HashSHA512     HashAlgorithm = "sha512"
+	HashBLAKE2b256 HashAlgorithm = "blake2b-256"
+
+	// DefaultHashAlgorithm is used by Write, Put and WriteStream unless WithHash
+	// is given, and is assumed for blobs that have a HashKey but no HashAlgKey
+	// metadata.
+	DefaultHashAlgorithm = HashSHA256
+)
+
+// New returns a new hash.Hash for the algorithm
+func (a HashAlgorithm) New() (hash.Hash, error) {
+	switch a {
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashBLAKE2b256:
+		return blake2b.New256(nil)
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", string(a))
+	}
+}
+
+// contentDigest is the hash and size of the content written to a blob, which
+// is recorded in the blob metadata.
+type contentDigest struct {
+	algorithm HashAlgorithm
+	value     string
+	size      int64
+	timestamp string
+}
+
+// newHashingReader returns a hashingReader for the algorithm
+func newHashingReader(algorithm HashAlgorithm, source io.Reader) (*hashingReader, error) {
+	hasher, err := algorithm.New()
+	if err != nil {
+		return nil, err
+	}
+	return &hashingReader{hasher: hasher, part: source}, nil
+}
+
+// digest returns the digest of everything read so far
+func (up *hashingReader) digest(algorithm HashAlgorithm) contentDigest {
+	return contentDigest{
+		algorithm: algorithm,
+		value:     hex.EncodeToString(up.hasher.Sum(nil)),
+		size:      up.size,
+		timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// seekerDigest reads source to the end to compute its digest, then rewinds it
+// so that it can be uploaded. As for Put, the source must be at position zero.
+func seekerDigest(algorithm HashAlgorithm, source io.ReadSeeker) (contentDigest, error) {
+	if pos, err := source.Seek(0, io.SeekCurrent); pos != 0 || err != nil {
+		return contentDigest{}, ErrMustSupportSeek0
+	}
+	up, err := newHashingReader(algorithm, source)
+	if err != nil {
+		return contentDigest{}, err
+	}
+	if _, err = io.Copy(io.Discard, up); err != nil {
+		return contentDigest{}, err
+	}
+	if _, err = source.Seek(0, io.SeekStart); err != nil {
+		return contentDigest{}, err
+	}
+	return up.digest(algorithm), nil
+}
+
+// metadata returns the metadata recording the digest. Any entries in extra are
+// added, and take precedence.
+func (d contentDigest) metadata(extra map[string]string) map[string]string {
+	meta := map[string]string{
+		HashKey:    d.value,
+		HashAlgKey: string(d.algorithm),
+		SizeKey:    strconv.FormatInt(d.size, 10),
+		TimeKey:    d.timestamp,
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+	return meta
+}
+
+// setResponse copies the digest to the write response
+func (d contentDigest) setResponse(wr *WriteResponse) {
+	wr.HashValue = d.value
+	wr.HashAlgorithm = d.algorithm
+	wr.Size = d.size
+	wr.TimestampAccepted = d.timestamp
+}
@@ -0,0 +1,93 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreWithHash(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	data := []byte("Spam, spam, spam, spam, lovely spam")
+	sha256Sum := sha256.Sum256(data)
+	sha512Sum := sha512.Sum512(data)
+	blake2bSum := blake2b.Sum256(data)
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Put(ctx, "put", NewBytesReaderCloser(data), WithHash(HashBLAKE2b256),
+				WithMetadata(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+			assert.Equal(t, hex.EncodeToString(blake2bSum[:]), wr.HashValue)
+			assert.Equal(t, int64(len(data)), wr.Size)
+
+			rr, err := store.Reader(ctx, "put", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, wr.HashValue, rr.HashValue)
+			assert.Equal(t, HashBLAKE2b256, rr.HashAlgorithm)
+			assert.Equal(t, int64(len(data)), rr.Size)
+			assert.Equal(t, "tenant1", rr.Metadata["Owner"])
+
+			wr, err = store.Write(ctx, "write", bytes.NewReader(data), WithHash(HashSHA512))
+			require.NoError(t, err)
+			assert.Equal(t, hex.EncodeToString(sha512Sum[:]), wr.HashValue)
+
+			rr, err = store.Reader(ctx, "write", WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, HashSHA512, rr.HashAlgorithm)
+			assert.Equal(t, string(data), readAll(t, rr))
+
+			// the hash written by Write is used to verify parallel downloads
+			f, err := os.Create(filepath.Join(t.TempDir(), "write"))
+			require.NoError(t, err)
+			defer f.Close()
+			_, err = store.DownloadParallel(ctx, "write", f, WithDownloadChunkSize(8))
+			require.NoError(t, err)
+
+			_, err = store.Put(ctx, "bad", NewBytesReaderCloser(data), WithHash("md4"))
+			require.Error(t, err)
+
+			// as for WriteStream, the hash is computed by default
+			for _, write := range []func(identity string, opts ...Option) (*WriteResponse, error){
+				func(identity string, opts ...Option) (*WriteResponse, error) {
+					return store.Put(ctx, identity, NewBytesReaderCloser(data), opts...)
+				},
+				func(identity string, opts ...Option) (*WriteResponse, error) {
+					return store.Write(ctx, identity, bytes.NewReader(data), opts...)
+				},
+			} {
+				wr, err = write("default")
+				require.NoError(t, err)
+				assert.Equal(t, hex.EncodeToString(sha256Sum[:]), wr.HashValue)
+				rr, err = store.Reader(ctx, "default", WithGetMetadata(OnlyMetadata))
+				require.NoError(t, err)
+				assert.Equal(t, DefaultHashAlgorithm, rr.HashAlgorithm)
+				assert.Equal(t, wr.HashValue, rr.HashValue)
+
+				// unless it is opted out of
+				wr, err = write("unhashed", WithoutHash())
+				require.NoError(t, err)
+				assert.Empty(t, wr.HashValue)
+				rr, err = store.Reader(ctx, "unhashed", WithGetMetadata(OnlyMetadata))
+				require.NoError(t, err)
+				assert.Empty(t, rr.HashAlgorithm)
+				assert.NotContains(t, rr.Metadata, "Hash")
+			}
+		})
+	}
+}
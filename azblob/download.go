@@ -10,12 +10,25 @@ import (
 	"net/http"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
+)
+
+const (
+	defaultDownloadRetries = 3
+)
+
+var (
+	// ErrTagsMismatch is returned by Reader if the blob does not have the tags
+	// required by WithTags
+	ErrTagsMismatch = errors.New("blob does not have the required tags")
 )
 
 const (
 	// metadata keys
 	ContentKey = "content_type"
 	HashKey    = "hash"
+	HashAlgKey = "hash_alg"
 	MimeKey    = "mime_type"
 	SizeKey    = "size"
 	TimeKey    = "time_accepted"
@@ -25,13 +38,14 @@ const (
 func (azp *Storer) getTags(
 	ctx context.Context,
 	identity string,
+	options *StorerOptions,
 ) (map[string]string, error) {
 
 	var err error
 
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	blobClient, err := azp.blobClient(identity, options)
 	if err != nil {
-		return nil, ErrorFromError(err)
+		return nil, err
 	}
 
 	resp, err := blobClient.GetTags(ctx, nil)
@@ -45,26 +59,88 @@ func (azp *Storer) getTags(
 	return tags, nil
 }
 
-// getMetadata gets metadata from blob storage
+// getMetadata gets the metadata, and the standard properties, from blob storage.
+// conditions may be nil.
 func (azp *Storer) getMetadata(
 	ctx context.Context,
 	identity string,
-) (map[string]string, error) {
+	options *StorerOptions,
+	conditions *azStorageBlob.BlobAccessConditions,
+) (*azStorageBlob.BlobGetPropertiesResponse, error) {
 
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	blobClient, err := azp.blobClient(identity, options)
 	if err != nil {
-		return nil, ErrorFromError(err)
+		return nil, err
 	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	resp, err := blobClient.GetProperties(ctx, nil)
+	resp, err := blobClient.GetProperties(
+		ctx,
+		&azStorageBlob.BlobGetPropertiesOptions{
+			BlobAccessConditions: conditions,
+		},
+	)
 	if err != nil {
 		return nil, ErrorFromError(err)
 	}
-	return resp.Metadata, nil
+	return &resp, nil
+}
+
+// tagsMismatchError is returned when a blob does not have the tags required by
+// WithTags. It is reported as not found, so that a caller can't discover the
+// existence of blobs it does not own.
+func tagsMismatchError(identity string) *Error {
+	return &Error{
+		err:        fmt.Errorf("%w: %s", ErrTagsMismatch, identity),
+		statusCode: http.StatusNotFound,
+	}
+}
+
+// checkRequiredTags compares the tags required by WithTags with the tags read
+// from the blob.
+func checkRequiredTags(identity string, required map[string]string, tags map[string]string) error {
+	for k, requiredValue := range required {
+		blobValue, ok := tags[k]
+		if !ok || blobValue != requiredValue {
+			return tagsMismatchError(identity)
+		}
+	}
+	return nil
+}
+
+// tagsCondition returns the x-ms-if-tags expression that requires the tags
+// given by WithTags, combined with any WithWhereTags expression.
+func tagsCondition(options *StorerOptions) (string, error) {
+	var expr tagfilter.Expr = tagfilter.FromMap(options.tags)
+	if options.etagCondition == TagsWhere {
+		where, err := tagfilter.ParseWhere(options.etag)
+		if err != nil {
+			return "", err
+		}
+		expr = tagfilter.And(where, expr)
+	}
+	if err := tagfilter.CheckWhere(expr); err != nil {
+		return "", err
+	}
+	return expr.String(), nil
 }
 
 // Reader creates a reader.
+//
+// If WithTags is specified, the blob is only read if it has all of the
+// required tags. The tags are checked by the service as a condition of the
+// read (x-ms-if-tags), so the check and the read are a single atomic
+// operation. If the tags do not match, the error satisfies
+// errors.Is(err, ErrTagsMismatch) and reports http.StatusNotFound. Note that
+// if WithTags is combined with ETag or If-Modified-Since conditions, a failed
+// condition is reported as ConditionNotMet, as the service does not indicate
+// which condition failed.
+//
+// For storers created WithEmulatedTagConditions, the tags are read and checked
+// before the blob is read. That is not atomic.
+//
+// WithSnapshot or WithVersionID read a snapshot, or a prior version, of the
+// blob rather than the blob itself.
 func (azp *Storer) Reader(
 	ctx context.Context,
 	identity string,
@@ -84,10 +160,35 @@ func (azp *Storer) Reader(
 		return nil, err
 	}
 
-	if len(options.tags) > 0 || options.getTags {
+	tagConditions := len(options.tags) > 0 && !azp.emulateTagConditions
+
+	// metadataConditions applies only the tags condition to the metadata only
+	// read. For backwards compat, the other conditions are not applied.
+	var metadataConditions *azStorageBlob.BlobAccessConditions
+	if tagConditions {
+		ifTags, tagsErr := tagsCondition(options)
+		if tagsErr != nil {
+			return nil, tagsErr
+		}
+		if blobAccessConditions.ModifiedAccessConditions == nil {
+			blobAccessConditions.ModifiedAccessConditions = &azStorageBlob.ModifiedAccessConditions{}
+		}
+		blobAccessConditions.ModifiedAccessConditions.IfTags = &ifTags
+		metadataConditions = &azStorageBlob.BlobAccessConditions{
+			ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{IfTags: &ifTags},
+		}
+	}
+
+	// tagsOnlyCondition is true if a failed condition can only be due to the
+	// tags required by WithTags.
+	tagsOnlyCondition := tagConditions &&
+		options.etagCondition == EtagNotUsed && options.sinceCondition == IfConditionNotUsed
+
+	if options.getTags || (len(options.tags) > 0 && !tagConditions) {
 		tags, tagsErr := azp.getTags(
 			ctx,
 			identity,
+			options,
 		)
 		if tagsErr != nil {
 			return nil, tagsErr
@@ -95,32 +196,62 @@ func (azp *Storer) Reader(
 		resp.Tags = tags
 	}
 
-	// XXX: TODO this should be done with access conditions. this is racy as it
-	// stands. azure guarantees the tags for a blob read after write is
-	// consistent. we can't take advantage of that while this remains racy.
-	for k, requiredValue := range options.tags {
-		blobValue, ok := resp.Tags[k]
-		if !ok {
-			return nil, NewStatusError(fmt.Sprintf("tag %s is not specified on blob", k), http.StatusNotFound)
-		}
-		if blobValue != requiredValue {
-			return nil, NewStatusError(fmt.Sprintf("blob has different Tag %s than required %s", blobValue, requiredValue), http.StatusNotFound)
+	if !tagConditions {
+		// The fallback for backends which do not support tag conditions. This
+		// is racy, the tags may change between the check and the read.
+		if err = checkRequiredTags(identity, options.tags, resp.Tags); err != nil {
+			return nil, err
 		}
 	}
 
 	// If we are *only* getting metadata, issue a distinct request. Otherwise we
 	// get it from the download response.
 	if options.getMetadata == OnlyMetadata {
-		metaData, metadataErr := azp.getMetadata(
+		props, metadataErr := azp.getMetadata(
 			ctx,
 			identity,
+			options,
+			metadataConditions,
 		)
 		if metadataErr != nil {
+			if tagConditions && ErrorFromError(metadataErr).IsConditionNotMet() {
+				return nil, tagsMismatchError(identity)
+			}
 			return nil, metadataErr
 		}
-		if parseErr := readerResponseMetadata(resp, metaData); parseErr != nil {
-			return nil, err
+		resp.ETag = props.ETag
+		resp.LastModified = props.LastModified
+		if props.ContentLength != nil {
+			resp.ContentLength = *props.ContentLength
+			resp.BlobSize = *props.ContentLength
 		}
+		resp.ContentMD5 = props.ContentMD5
+		resp.setLease(props.LeaseState, props.LeaseStatus, props.LeaseDuration)
+		if props.AccessTier != nil {
+			resp.AccessTier = AccessTier(*props.AccessTier)
+		}
+		if props.ArchiveStatus != nil {
+			resp.ArchiveStatus = ArchiveStatus(*props.ArchiveStatus)
+		}
+		if props.RehydratePriority != nil {
+			resp.RehydratePriority = RehydratePriority(*props.RehydratePriority)
+		}
+		if props.CopyID != nil {
+			resp.CopyID = *props.CopyID
+		}
+		if props.CopyStatus != nil {
+			resp.CopyStatus = *props.CopyStatus
+		}
+		if props.CopyProgress != nil {
+			resp.CopyProgress = *props.CopyProgress
+		}
+		if props.CopyStatusDescription != nil {
+			resp.CopyStatusDescription = *props.CopyStatusDescription
+		}
+		// As for BothMetadataAndBlob, the parse error is benign. The metadata
+		// is available in the response regardless.
+		resp.Metadata = props.Metadata
+		_ = readerResponseMetadata(resp, props.Metadata)
 	}
 
 	if options.getMetadata == OnlyMetadata {
@@ -133,22 +264,30 @@ func (azp *Storer) Reader(
 		return nil, errors.New("no container client available for reader")
 	}
 
-	resp.BlobClient, err = azp.containerClient.NewBlobClient(identity)
+	resp.BlobClient, err = azp.blobClient(identity, options)
 	if err != nil {
-		return nil, ErrorFromError(err)
+		return nil, err
 	}
-	countToEnd := int64(azStorageBlob.CountToEnd)
+	count := int64(azStorageBlob.CountToEnd)
+	if options.rangeCount > 0 {
+		count = options.rangeCount
+	}
+	offset := options.rangeOffset
 	get, err := resp.BlobClient.Download(
 		ctx,
 		&azStorageBlob.BlobDownloadOptions{
 			BlobAccessConditions: &blobAccessConditions,
-			Count:                &countToEnd,
+			Offset:               &offset,
+			Count:                &count,
 		},
 	)
 
 	if err != nil && err == io.EOF { // nolint
 		return nil, ErrorFromError(err)
 	}
+	if err != nil && tagsOnlyCondition && ErrorFromError(err).IsConditionNotMet() {
+		return nil, tagsMismatchError(identity)
+	}
 
 	normaliseReaderResponseErr(err, resp)
 	if err == nil {
@@ -165,7 +304,13 @@ func (azp *Storer) Reader(
 	}
 
 	if get.RawResponse != nil {
-		resp.Reader = get.Body(nil)
+		retries := defaultDownloadRetries
+		if options.downloadRetriesSet {
+			retries = options.downloadRetries
+		}
+		// The retry reader re-issues the download from the last received
+		// offset, conditional on the ETag of this response.
+		resp.Reader = get.Body(&azStorageBlob.RetryReaderOptions{MaxRetryRequests: retries})
 	}
 	return resp, err
 }
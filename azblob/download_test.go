@@ -0,0 +1,73 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreRange(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("0123456789")))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "blob", WithRange(2, 3))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusPartialContent, rr.StatusCode)
+			assert.Equal(t, "bytes 2-4/10", rr.ContentRange)
+			assert.Equal(t, int64(10), rr.BlobSize)
+			assert.Equal(t, "234", readAll(t, rr))
+
+			_, err = store.Reader(ctx, "blob", WithRange(10, 0))
+			require.Error(t, err)
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, ErrorFromError(err).StatusCode())
+
+			br, err := NewBlobReader(ctx, store, "blob")
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), br.Size())
+
+			_, err = br.Seek(6, io.SeekStart)
+			require.NoError(t, err)
+			data, err := io.ReadAll(br)
+			require.NoError(t, err)
+			assert.Equal(t, "6789", string(data))
+
+			// reads are pinned to the etag the reader was opened with
+			_, err = store.Put(ctx, "blob", NewBytesReaderCloser([]byte("changed")))
+			require.NoError(t, err)
+			_, err = br.ReadAt(make([]byte, 2), 0)
+			require.Error(t, err)
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+		})
+	}
+}
+
+func TestTagsCondition(t *testing.T) {
+
+	options := &StorerOptions{}
+	WithTags(map[string]string{"owner": "alice", "class": "log"})(options)
+	expr, err := tagsCondition(options)
+	require.NoError(t, err)
+	assert.Equal(t, "\"class\" = 'log' AND \"owner\" = 'alice'", expr)
+
+	WithWhereTags("\"tier\" > 'cool'")(options)
+	expr, err = tagsCondition(options)
+	require.NoError(t, err)
+	assert.Equal(t, "\"tier\" > 'cool' AND (\"class\" = 'log' AND \"owner\" = 'alice')", expr)
+
+	WithWhereTags("tier >")(options)
+	_, err = tagsCondition(options)
+	require.Error(t, err)
+}
@@ -0,0 +1,202 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultDownloadChunkSize   = 4 * 1024 * 1024
+	defaultDownloadConcurrency = 5
+)
+
+var (
+	// ErrHashMismatch is returned by DownloadParallel if the downloaded content
+	// does not match the hash stored with the blob
+	ErrHashMismatch = errors.New("downloaded content does not match the stored hash")
+)
+
+// DownloadParallel downloads a blob into w using concurrent ranged reads.
+//
+// The blob is fetched in chunks, see WithDownloadChunkSize and
+// WithDownloadConcurrency. Every chunk is read conditional on the ETag the blob
+// had when the download started, so if the blob changes the download fails
+// with ConditionNotMet rather than writing a mix of old and new content.
+//
+// The content is verified against the HashKey metadata written by WriteStream,
+// or by Write and Put WithHash. If there is none, it is verified against the
+// Content-MD5 of the blob. If the content does not match, the error satisfies
+// errors.Is(err, ErrHashMismatch). Note that w has been written to regardless.
+// If the blob has neither, the content is not verified.
+//
+// The returned response has the properties and metadata of the blob, its
+// Reader is nil.
+func (azp *Storer) DownloadParallel(
+	ctx context.Context,
+	identity string,
+	w io.WriterAt,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	return downloadParallel(ctx, azp.log, azp, identity, w, opts...)
+}
+
+// DownloadParallel downloads a blob, see Storer.DownloadParallel
+func (s *localStore) DownloadParallel(
+	ctx context.Context,
+	identity string,
+	w io.WriterAt,
+	opts ...Option,
+) (*ReaderResponse, error) {
+	return downloadParallel(ctx, s.log, s, identity, w, opts...)
+}
+
+// downloadChunk is the result of a single ranged read
+type downloadChunk struct {
+	index int64
+	data  []byte
+	err   error
+}
+
+// downloadVerifier returns the hash to verify the content with and the
+// expected sum, or nil if the blob has no hash we can verify against.
+func downloadVerifier(props *ReaderResponse) (hash.Hash, []byte, error) {
+	if value := props.Metadata[textproto.CanonicalMIMEHeaderKey(HashKey)]; value != "" {
+		hasher, err := metadataHashAlgorithm(props.Metadata).New()
+		if err != nil {
+			return nil, nil, err
+		}
+		sum, err := hex.DecodeString(strings.ToLower(value))
+		if err != nil || len(sum) != hasher.Size() {
+			return nil, nil, fmt.Errorf("invalid %s metadata value %q", HashKey, value)
+		}
+		return hasher, sum, nil
+	}
+	if len(props.ContentMD5) > 0 {
+		return md5.New(), props.ContentMD5, nil
+	}
+	return nil, nil, nil
+}
+
+func downloadParallel(
+	ctx context.Context,
+	log Logger,
+	r Reader,
+	identity string,
+	w io.WriterAt,
+	opts ...Option,
+) (*ReaderResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	chunkSize := options.downloadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultDownloadChunkSize
+	}
+	concurrency := options.downloadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
+
+	// the chunk reads append to opts concurrently, so make sure each append
+	// allocates rather than sharing a backing array.
+	opts = slices.Clip(opts)
+
+	props, err := r.Reader(ctx, identity, append(opts, WithGetMetadata(OnlyMetadata))...)
+	if err != nil {
+		return nil, err
+	}
+	if props.ETag == nil {
+		return nil, fmt.Errorf("no etag available for blob %s", identity)
+	}
+	etag := *props.ETag
+	size := props.BlobSize
+
+	hasher, expected, err := downloadVerifier(props)
+	if err != nil {
+		return nil, err
+	}
+	if hasher == nil {
+		log.Infof("DownloadParallel %s: no hash available, content is not verified", identity)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	numChunks := (size + chunkSize - 1) / chunkSize
+
+	// A token is held for each chunk from the start of its read until it has
+	// been hashed. Chunks must be hashed in order, so this also bounds the
+	// number of completed chunks held in memory waiting for an earlier one.
+	tokens := make(chan struct{}, concurrency)
+	// Never more than concurrency chunks are outstanding, so sends to results
+	// never block, even if we return early.
+	results := make(chan downloadChunk, concurrency)
+
+	fetch := func(index int64) {
+		offset := index * chunkSize
+		count := min(chunkSize, size-offset)
+		chunk := downloadChunk{index: index}
+		defer func() { results <- chunk }()
+
+		rr, err := r.Reader(ctx, identity, append(opts, WithRange(offset, count), WithEtagMatch(etag))...)
+		if err != nil {
+			chunk.err = err
+			return
+		}
+		defer rr.Reader.Close()
+		if !rr.Ok() {
+			chunk.err = fmt.Errorf("failed to read blob %s at %d: %s", identity, offset, rr.Status)
+			return
+		}
+		chunk.data = make([]byte, count)
+		_, chunk.err = io.ReadFull(rr.Reader, chunk.data)
+	}
+
+	go func() {
+		for index := range numChunks {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go fetch(index)
+		}
+	}()
+
+	pending := map[int64][]byte{}
+	var next int64
+	for next < numChunks {
+		chunk := <-results
+		if chunk.err != nil {
+			return nil, chunk.err
+		}
+		if _, err = w.WriteAt(chunk.data, chunk.index*chunkSize); err != nil {
+			return nil, err
+		}
+		pending[chunk.index] = chunk.data
+		for data, ok := pending[next]; ok; data, ok = pending[next] {
+			if hasher != nil {
+				hasher.Write(data)
+			}
+			delete(pending, next)
+			next++
+			<-tokens
+		}
+	}
+
+	if hasher != nil && !bytes.Equal(hasher.Sum(nil), expected) {
+		return nil, fmt.Errorf("%w: %s", ErrHashMismatch, identity)
+	}
+	return props, nil
+}
@@ -0,0 +1,61 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreDownloadParallel(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// verified against the Content-MD5 set by Put
+			_, err := store.Put(ctx, "md5", NewBytesReaderCloser(data))
+			require.NoError(t, err)
+			f, err := os.Create(filepath.Join(t.TempDir(), "md5"))
+			require.NoError(t, err)
+			defer f.Close()
+			_, err = store.DownloadParallel(ctx, "md5", f, WithDownloadChunkSize(999), WithDownloadConcurrency(3))
+			require.NoError(t, err)
+			got, err := os.ReadFile(f.Name())
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			// verified against the HashKey metadata
+			_, err = store.Write(ctx, "hash", bytes.NewReader(data),
+				WithMetadata(map[string]string{HashKey: hex.EncodeToString(sum[:])}))
+			require.NoError(t, err)
+			f2, err := os.Create(filepath.Join(t.TempDir(), "hash"))
+			require.NoError(t, err)
+			defer f2.Close()
+			_, err = store.DownloadParallel(ctx, "hash", f2, WithDownloadChunkSize(1000))
+			require.NoError(t, err)
+
+			_, err = store.Write(ctx, "bad", bytes.NewReader(data[1:]),
+				WithMetadata(map[string]string{HashKey: hex.EncodeToString(sum[:])}))
+			require.NoError(t, err)
+			_, err = store.DownloadParallel(ctx, "bad", f2, WithDownloadChunkSize(1000))
+			assert.ErrorIs(t, err, ErrHashMismatch)
+		})
+	}
+}
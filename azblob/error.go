@@ -3,11 +3,83 @@
 package azblob
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"slices"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/datatrails/go-datatrails-common/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The sentinel errors classify the errors returned by the storers, whether
+// they come from azure or from a local store. Use errors.Is, eg
+//
+//	if errors.Is(err, azblob.ErrBlobNotFound) {
+//
+// rather than comparing the StorageErrorCode.
+var (
+	// ErrBlobNotFound is a blob, snapshot or version which does not exist
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrContainerNotFound is a container which does not exist
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrLeaseLost is a lease operation, or a write, which requires a lease
+	// that is not held. eg because it expired, was broken or was changed. It
+	// is also the cause of the cancellation of a LeaseHandle context when the
+	// lease could not be renewed.
+	ErrLeaseLost = errors.New("lease lost")
+	// ErrLeaseAlreadyPresent is a lease which can't be acquired because the
+	// blob is leased by another, or its lease is being broken
+	ErrLeaseAlreadyPresent = errors.New("lease already present")
+	// ErrConditionNotMet is a conditional request whose condition, eg an
+	// ETag, tags, or append position condition, was not met
+	ErrConditionNotMet = errors.New("condition not met")
+	// ErrAlreadyExists is a blob or container which already exists
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrThrottled is a request rejected because the storage account is busy
+	ErrThrottled = errors.New("throttled")
+	// ErrTooLarge is a request, blob, block list or metadata which exceeds the
+	// azure limits
+	ErrTooLarge = errors.New("too large")
+)
+
+var (
+	leaseLostCodes = []azStorageBlob.StorageErrorCode{
+		azStorageBlob.StorageErrorCodeLeaseLost,
+		azStorageBlob.StorageErrorCodeLeaseIDMismatchWithBlobOperation,
+		azStorageBlob.StorageErrorCodeLeaseIDMismatchWithContainerOperation,
+		azStorageBlob.StorageErrorCodeLeaseIDMismatchWithLeaseOperation,
+		azStorageBlob.StorageErrorCodeLeaseNotPresentWithBlobOperation,
+		azStorageBlob.StorageErrorCodeLeaseNotPresentWithContainerOperation,
+		azStorageBlob.StorageErrorCodeLeaseNotPresentWithLeaseOperation,
+		azStorageBlob.StorageErrorCodeLeaseIsBrokenAndCannotBeRenewed,
+		azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeChanged,
+	}
+	leaseAlreadyPresentCodes = []azStorageBlob.StorageErrorCode{
+		azStorageBlob.StorageErrorCodeLeaseAlreadyPresent,
+		azStorageBlob.StorageErrorCodeLeaseIsBreakingAndCannotBeAcquired,
+	}
+	conditionNotMetCodes = []azStorageBlob.StorageErrorCode{
+		azStorageBlob.StorageErrorCodeConditionNotMet,
+		azStorageBlob.StorageErrorCodeAppendPositionConditionNotMet,
+		azStorageBlob.StorageErrorCodeMaxBlobSizeConditionNotMet,
+		azStorageBlob.StorageErrorCodeSequenceNumberConditionNotMet,
+		azStorageBlob.StorageErrorCodeSourceConditionNotMet,
+		azStorageBlob.StorageErrorCodeTargetConditionNotMet,
+	}
+	alreadyExistsCodes = []azStorageBlob.StorageErrorCode{
+		azStorageBlob.StorageErrorCodeBlobAlreadyExists,
+		azStorageBlob.StorageErrorCodeContainerAlreadyExists,
+		azStorageBlob.StorageErrorCodeResourceAlreadyExists,
+	}
+	tooLargeCodes = []azStorageBlob.StorageErrorCode{
+		azStorageBlob.StorageErrorCodeRequestBodyTooLarge,
+		azStorageBlob.StorageErrorCodeBlockCountExceedsLimit,
+		azStorageBlob.StorageErrorCodeBlockListTooLong,
+		azStorageBlob.StorageErrorCodeMetadataTooLarge,
+	}
 )
 
 // HTTPError error type with info about http.StatusCode
@@ -18,8 +90,9 @@ type HTTPError interface {
 }
 
 type Error struct {
-	err        error
-	statusCode int
+	err              error
+	statusCode       int
+	storageErrorCode string
 }
 
 func NewStatusError(text string, statusCode int) *Error {
@@ -29,7 +102,23 @@ func NewStatusError(text string, statusCode int) *Error {
 	}
 }
 
+// newStorageError returns an error carrying both a status code and an azure
+// storage error code. It is used where this package, rather than the azure
+// service, determines the outcome of a request. eg the local BlobStore
+// implementations.
+func newStorageError(text string, statusCode int, storageErrorCode string) *Error {
+	return &Error{
+		err:              errors.New(text),
+		statusCode:       statusCode,
+		storageErrorCode: storageErrorCode,
+	}
+}
+
+// ErrorFromError returns err as an *Error, wrapping it if it is not one already
 func ErrorFromError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
 	return &Error{err: err}
 }
 
@@ -43,21 +132,27 @@ func (e *Error) Unwrap() error {
 // StatusCode returns status code for failing request or 500 if code is not available on the error
 func (e *Error) StatusCode() int {
 
+	statusCode := e.status()
+	if statusCode != 0 {
+		logger.Sugar.Debugf("AZBlob statusCode %d", statusCode)
+		return statusCode
+	}
+	logger.Sugar.Debugf("AZBlob InternalServerError: %v", e)
+	return http.StatusInternalServerError
+}
+
+// status returns the http status code of the azure response, or the one set
+// by this package, or 0 if there is neither
+func (e *Error) status() int {
 	var terr *azStorageBlob.StorageError
 	if errors.As(e.err, &terr) {
 		resp := terr.Response()
 		if resp.Body != nil {
 			defer resp.Body.Close()
 		}
-		logger.Sugar.Debugf("AZBlob downstream statusCode %d", resp.StatusCode)
 		return resp.StatusCode
 	}
-	if e.statusCode != 0 {
-		logger.Sugar.Debugf("AZBlob internal statusCode %d", e.statusCode)
-		return e.statusCode
-	}
-	logger.Sugar.Debugf("AZBlob InternalServerError: %v", e)
-	return http.StatusInternalServerError
+	return e.statusCode
 }
 
 // StorageErrorCode returns the underlying azure storage ErrorCode string eg "BlobNotFound"
@@ -68,7 +163,7 @@ func (e *Error) StorageErrorCode() string {
 			return string(terr.ErrorCode)
 		}
 	}
-	return ""
+	return e.storageErrorCode
 }
 
 // IsConditionNotMet returns true if the err is the storage code indicating that
@@ -76,3 +171,95 @@ func (e *Error) StorageErrorCode() string {
 func (e *Error) IsConditionNotMet() bool {
 	return e.StorageErrorCode() == string(azStorageBlob.StorageErrorCodeConditionNotMet)
 }
+
+// Is reports whether the error is classified by target, one of the sentinel
+// errors, so that errors.Is works through the wrapper. Errors are classified
+// by their storage error code or, for responses which have none, eg to HEAD
+// requests, by their status code.
+func (e *Error) Is(target error) bool {
+	code := azStorageBlob.StorageErrorCode(e.StorageErrorCode())
+	switch target {
+	case ErrBlobNotFound:
+		return code == azStorageBlob.StorageErrorCodeBlobNotFound ||
+			(code == "" && e.status() == http.StatusNotFound)
+	case ErrContainerNotFound:
+		return code == azStorageBlob.StorageErrorCodeContainerNotFound
+	case ErrLeaseLost:
+		return slices.Contains(leaseLostCodes, code)
+	case ErrLeaseAlreadyPresent:
+		return slices.Contains(leaseAlreadyPresentCodes, code)
+	case ErrConditionNotMet:
+		return slices.Contains(conditionNotMetCodes, code) ||
+			(code == "" && e.status() == http.StatusPreconditionFailed)
+	case ErrAlreadyExists:
+		return slices.Contains(alreadyExistsCodes, code)
+	case ErrThrottled:
+		statusCode := e.status()
+		return code == azStorageBlob.StorageErrorCodeServerBusy ||
+			statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+	case ErrTooLarge:
+		return slices.Contains(tooLargeCodes, code) ||
+			e.status() == http.StatusRequestEntityTooLarge
+	default:
+		return false
+	}
+}
+
+// GRPCStatus returns the grpc status for the error, so that a service can
+// return it from a handler, or use status.Code, without mapping it. The
+// sentinel errors take precedence, otherwise the http status code is mapped.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(e.grpcCode(), e.Error())
+}
+
+// grpcCode maps the error onto a grpc code. A condition which is not met is
+// Aborted, as the caller should retry the read, modify and write. A lost lease
+// is FailedPrecondition, as the lease must be acquired again first.
+func (e *Error) grpcCode() codes.Code {
+	switch {
+	case errors.Is(e.err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(e.err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(e, ErrBlobNotFound), errors.Is(e, ErrContainerNotFound):
+		return codes.NotFound
+	case errors.Is(e, ErrAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(e, ErrConditionNotMet), errors.Is(e, ErrLeaseAlreadyPresent):
+		return codes.Aborted
+	case errors.Is(e, ErrLeaseLost):
+		return codes.FailedPrecondition
+	case errors.Is(e, ErrThrottled):
+		return codes.ResourceExhausted
+	case errors.Is(e, ErrTooLarge):
+		return codes.InvalidArgument
+	}
+
+	statusCode := e.status()
+	switch statusCode {
+	case 0:
+		return codes.Unknown
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
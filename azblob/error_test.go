@@ -0,0 +1,104 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorIs(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	tests := []struct {
+		name     string
+		err      error
+		sentinel error
+		code     codes.Code
+	}{
+		{"blob not found", newStorageError("not found", http.StatusNotFound, errCodeBlobNotFound), ErrBlobNotFound, codes.NotFound},
+		{"head not found", NewStatusError("not found", http.StatusNotFound), ErrBlobNotFound, codes.NotFound},
+		{"container not found", newStorageError("not found", http.StatusNotFound, "ContainerNotFound"), ErrContainerNotFound, codes.NotFound},
+		{"lease mismatch", newStorageError("mismatch", http.StatusPreconditionFailed, errCodeLeaseIDMismatchWithBlobOperation), ErrLeaseLost, codes.FailedPrecondition},
+		{"lease not present", newStorageError("no lease", http.StatusConflict, errCodeLeaseNotPresentWithLeaseOperation), ErrLeaseLost, codes.FailedPrecondition},
+		{"lease present", newStorageError("leased", http.StatusConflict, errCodeLeaseAlreadyPresent), ErrLeaseAlreadyPresent, codes.Aborted},
+		{"lease breaking", newStorageError("breaking", http.StatusConflict, "LeaseIsBreakingAndCannotBeAcquired"), ErrLeaseAlreadyPresent, codes.Aborted},
+		{"etag", newStorageError("etag", http.StatusPreconditionFailed, errCodeConditionNotMet), ErrConditionNotMet, codes.Aborted},
+		{"head etag", NewStatusError("etag", http.StatusPreconditionFailed), ErrConditionNotMet, codes.Aborted},
+		{"append position", newStorageError("position", http.StatusPreconditionFailed, errCodeAppendPositionConditionNotMet), ErrConditionNotMet, codes.Aborted},
+		{"blob exists", newStorageError("exists", http.StatusConflict, errCodeBlobAlreadyExists), ErrAlreadyExists, codes.AlreadyExists},
+		{"server busy", newStorageError("busy", http.StatusServiceUnavailable, "ServerBusy"), ErrThrottled, codes.ResourceExhausted},
+		{"too many requests", NewStatusError("busy", http.StatusTooManyRequests), ErrThrottled, codes.ResourceExhausted},
+		{"block count", newStorageError("blocks", http.StatusConflict, errCodeBlockCountExceedsLimit), ErrTooLarge, codes.InvalidArgument},
+		{"entity too large", NewStatusError("large", http.StatusRequestEntityTooLarge), ErrTooLarge, codes.InvalidArgument},
+	}
+	sentinels := []error{
+		ErrBlobNotFound, ErrContainerNotFound, ErrLeaseLost, ErrLeaseAlreadyPresent,
+		ErrConditionNotMet, ErrAlreadyExists, ErrThrottled, ErrTooLarge,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, sentinel := range sentinels {
+				assert.Equal(t, sentinel == tt.sentinel, errors.Is(tt.err, sentinel), "%v", sentinel)
+			}
+			// through further wrapping
+			wrapped := fmt.Errorf("context: %w", tt.err)
+			assert.ErrorIs(t, wrapped, tt.sentinel)
+			assert.Equal(t, tt.code, status.Code(wrapped))
+		})
+	}
+}
+
+func TestErrorGRPCStatus(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(NewStatusError("denied", http.StatusForbidden)))
+	assert.Equal(t, codes.Aborted, status.Code(newStorageError("leased", http.StatusConflict, errCodeLeaseAlreadyPresent)))
+	assert.Equal(t, codes.OutOfRange, status.Code(NewStatusError("range", http.StatusRequestedRangeNotSatisfiable)))
+	assert.Equal(t, codes.Internal, status.Code(NewStatusError("internal", http.StatusInternalServerError)))
+	assert.Equal(t, codes.Unknown, status.Code(ErrorFromError(errors.New("unknown"))))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(ErrorFromError(context.DeadlineExceeded)))
+
+	s := status.Convert(NewStatusError("denied", http.StatusForbidden))
+	assert.Equal(t, "denied", s.Message())
+}
+
+func TestLocalStoreErrors(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Reader(ctx, "missing")
+			assert.ErrorIs(t, err, ErrBlobNotFound)
+
+			wr, err := store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			_, err = store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")), WithEtagNoneMatch("*"))
+			assert.ErrorIs(t, err, ErrAlreadyExists)
+			_, err = store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")), WithEtagMatch("\"stale\""))
+			assert.ErrorIs(t, err, ErrConditionNotMet)
+			_, err = store.Put(ctx, "blob", NewBytesReaderCloser([]byte("VALUE")), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+
+			leaseID, err := store.AcquireLease(ctx, "blob", 15)
+			require.NoError(t, err)
+			_, err = store.AcquireLease(ctx, "blob", 15)
+			assert.NotErrorIs(t, err, ErrLeaseLost)
+			require.NoError(t, store.ReleaseLease(ctx, "blob", leaseID))
+			err = store.RenewLease(ctx, "blob", leaseID)
+			assert.ErrorIs(t, err, ErrLeaseLost)
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package azblob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	fileStoreDataDir  = "data"
+	fileStorePropsDir = "props"
+	fileStoreBlockDir = "blocks"
+	fileStoreHistDir  = "history"
+	fileStoreTmpGlob  = ".tmp-*"
+	fileStoreDirPerm  = 0o750
+)
+
+// FileStore is a BlobStore which keeps blobs on the local filesystem.
+//
+// It provides the same ETag, If-Modified-Since, lease, metadata and tag
+// semantics as Storer. Blob content and blob properties are kept in separate
+// files under <root>/<container>. Uncommitted blocks, and snapshots and prior
+// versions, are kept in directories per blob. Access is serialised within a
+// process, the directory must not be shared by concurrent processes.
+type FileStore struct {
+	*localStore
+}
+
+// NewFileStore returns a BlobStore rooted at root/container, creating the
+// directories if necessary.
+func NewFileStore(log Logger, root string, container string) (*FileStore, error) {
+
+	if container == "" {
+		log.Infof("FileStore: %v", ErrUnspecifiedContainer)
+		return nil, ErrUnspecifiedContainer
+	}
+	dir := filepath.Join(root, container)
+	for _, sub := range []string{fileStoreDataDir, fileStorePropsDir, fileStoreBlockDir, fileStoreHistDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), fileStoreDirPerm); err != nil {
+			log.Infof("unable to create filestore directory %s: %v", dir, err)
+			return nil, err
+		}
+	}
+
+	return &FileStore{
+		localStore: newLocalStore(log, container, &fileBackend{dir: dir}),
+	}, nil
+}
+
+// fileBackend is a localBackend which keeps each blob as a pair of files. One
+// for the content and one for the json encoded properties.
+type fileBackend struct {
+	dir string
+}
+
+// fileName encodes a blob name as a single, safe, file name. Blob names may
+// contain path separators and may be prefixes of other blob names, so they
+// can't be mapped directly onto a directory tree.
+func fileName(identity string) string {
+	name := url.PathEscape(identity)
+	// avoid "." and ".." and also any clash with our temporary files
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+func (f *fileBackend) dataPath(identity string) string {
+	return filepath.Join(f.dir, fileStoreDataDir, fileName(identity))
+}
+
+func (f *fileBackend) propsPath(identity string) string {
+	return filepath.Join(f.dir, fileStorePropsDir, fileName(identity))
+}
+
+func (f *fileBackend) blocksPath(identity string) string {
+	return filepath.Join(f.dir, fileStoreBlockDir, fileName(identity))
+}
+
+func (f *fileBackend) historyPath(identity string) string {
+	return filepath.Join(f.dir, fileStoreHistDir, fileName(identity))
+}
+
+func (f *fileBackend) load(identity string) (*localBlob, error) {
+	props, err := os.ReadFile(f.propsPath(identity))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := &localBlob{}
+	if err = json.Unmarshal(props, b); err != nil {
+		return nil, fmt.Errorf("bad properties for blob %s: %w", identity, err)
+	}
+	b.Data, err = os.ReadFile(f.dataPath(identity))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// store writes the content before the properties. The properties file
+// determines whether the blob exists, so a blob is never visible without its
+// content.
+func (f *fileBackend) store(blob *localBlob) error {
+	props, err := json.Marshal(blob)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(f.dataPath(blob.Name), blob.Data); err != nil {
+		return err
+	}
+	return writeFileAtomic(f.propsPath(blob.Name), props)
+}
+
+func (f *fileBackend) remove(identity string) error {
+	err := os.Remove(f.propsPath(identity))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = os.Remove(f.dataPath(identity))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *fileBackend) names() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, fileStorePropsDir))
+	if err != nil {
+		return nil, err
+	}
+	// blobs which have been deleted may still have prior versions
+	histEntries, err := os.ReadDir(filepath.Join(f.dir, fileStoreHistDir))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range append(entries, histEntries...) {
+		if strings.HasPrefix(entry.Name(), ".") || seen[entry.Name()] {
+			continue
+		}
+		seen[entry.Name()] = true
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("bad blob file name %s: %w", entry.Name(), err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (f *fileBackend) stageBlock(identity string, blockID string, data []byte) error {
+	dir := f.blocksPath(identity)
+	if err := os.MkdirAll(dir, fileStoreDirPerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, fileName(blockID)), data)
+}
+
+func (f *fileBackend) stagedBlocks(identity string) (map[string][]byte, error) {
+	dir := f.blocksPath(identity)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blocks := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		blockID, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("bad block file name %s: %w", entry.Name(), err)
+		}
+		blocks[blockID], err = os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+func (f *fileBackend) discardBlocks(identity string) error {
+	return os.RemoveAll(f.blocksPath(identity))
+}
+
+// fileHistoryEntry is the file content for a snapshot or prior version
+type fileHistoryEntry struct {
+	Blob *localBlob `json:"blob"`
+	Data []byte     `json:"data"`
+}
+
+func (f *fileBackend) storeHistory(blob *localBlob) error {
+	entry, err := json.Marshal(fileHistoryEntry{Blob: blob, Data: blob.Data})
+	if err != nil {
+		return err
+	}
+	dir := f.historyPath(blob.Name)
+	if err = os.MkdirAll(dir, fileStoreDirPerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, fileName(blob.historyKey())), entry)
+}
+
+func (f *fileBackend) loadHistory(identity string) ([]*localBlob, error) {
+	dir := f.historyPath(identity)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*localBlob, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		e := fileHistoryEntry{}
+		if err = json.Unmarshal(data, &e); err != nil || e.Blob == nil {
+			return nil, fmt.Errorf("bad history %s for blob %s: %v", entry.Name(), identity, err)
+		}
+		e.Blob.Data = e.Data
+		history = append(history, e.Blob)
+	}
+	return history, nil
+}
+
+func (f *fileBackend) removeHistory(blob *localBlob) error {
+	dir := f.historyPath(blob.Name)
+	err := os.Remove(filepath.Join(dir, fileName(blob.historyKey())))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// the directory is removed with the last entry, so that deleted blobs
+	// with no remaining history are no longer listed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(entries) == 0 {
+		return os.Remove(dir)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path such that readers see either the
+// previous content or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), fileStoreTmpGlob)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: benign once renamed
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -5,8 +5,8 @@ package azblob
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"time"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -16,21 +16,26 @@ import (
 
 const (
 	leaseReleaseTimeoutSecs = 5
+
+	// LeaseBreakDefault is the break period for BreakLease which ends a fixed
+	// lease when it expires and an infinite lease immediately
+	LeaseBreakDefault = -1
 )
 
 type LeaseRenewer func(ctx context.Context) error
 
-// AcquireLease gets a lease on a blob
+// AcquireLease gets a lease on a blob, which is created, empty, if it does not
+// exist. If another creates the blob first the lease is acquired on theirs, so
+// that only one of those racing to lease a new blob gets the lease.
 func (azp *Storer) AcquireLease(
 	ctx context.Context, objectname string, leaseTimeout int32,
 ) (string, error) {
 
 	logger.Sugar.Debugf("AcquireLease: %v", objectname)
 	lease, _, err := azp.acquireLease(ctx, objectname, leaseTimeout)
-	herr := ErrorFromError(err)
-	if herr.StatusCode() == http.StatusNotFound {
-		_, err = azp.Write(ctx, objectname, bytes.NewReader([]byte{}))
-		if err != nil {
+	if errors.Is(err, ErrBlobNotFound) {
+		_, err = azp.Write(ctx, objectname, bytes.NewReader([]byte{}), WithEtagNoneMatch("*"))
+		if err != nil && !createdConcurrently(err) {
 			logger.Sugar.Infof("failed to create blob %s: %v", objectname, err)
 			return "", err
 		}
@@ -39,11 +44,22 @@ func (azp *Storer) AcquireLease(
 
 	if err != nil {
 		logger.Sugar.Infof("failed to acquire lease %s: %v", objectname, err)
-		return "", herr
+		return "", err
 	}
 	return *lease.LeaseID, nil
 }
 
+// createdConcurrently reports whether the creation of a blob to lease failed
+// because another created it first. It is leased if they have already leased
+// it, which azure may report rather than that it exists.
+func createdConcurrently(err error) bool {
+	return errors.Is(err, ErrAlreadyExists) || errors.Is(err, ErrConditionNotMet) ||
+		ErrorFromError(err).StorageErrorCode() == string(azStorageBlob.StorageErrorCodeLeaseIDMissing)
+}
+
+// AcquireLeaseRenewable gets a lease on a blob, and returns a function which
+// renews it. The caller must renew the lease before it expires. See
+// AcquireLeaseHandle, which renews the lease in the background.
 func (azp *Storer) AcquireLeaseRenewable(
 	ctx context.Context, objectname string, leaseTimeout int32,
 ) (string, LeaseRenewer, error) {
@@ -61,15 +77,16 @@ func (azp *Storer) AcquireLeaseRenewable(
 	renewer := func(ctx context.Context) error {
 		renewed, rerr := leaseBlobClient.RenewLease(ctx, nil)
 		if rerr != nil {
-			logger.Sugar.Infof("failed to renew lease %s: %v", objectname, err)
+			logger.Sugar.Infof("failed to renew lease %s: %v", objectname, rerr)
 			return ErrorFromError(rerr)
 		}
 
+		// renewing never changes the lease id, only ChangeLease does. A
+		// renewer is for the id with which it was created, so a lease which
+		// has been changed can't be renewed by it.
 		renewedID := *renewed.LeaseID
 		if renewedID != leaseID {
-			// TODO: I think this ought to be a panic, else the api needs to
-			// change to recycle the id's
-			logger.Sugar.Infof("renew lease mismatch %s: %v", objectname, err)
+			logger.Sugar.Infof("renew lease mismatch %s: `%s' != `%s'", objectname, renewedID, leaseID)
 			return ErrorFromError(fmt.Errorf(
 				"renewed lease id mismatch: `%s' != `%s'",
 				renewedID, leaseID))
@@ -90,12 +107,12 @@ func (azp *Storer) acquireLease(
 	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(objectname)
 	if err != nil {
 		logger.Sugar.Infof("cannot create block blob client %s: %v", objectname, err)
-		return nil, nil, err
+		return nil, nil, ErrorFromError(err)
 	}
 	leaseBlobClient, err := blockBlobClient.NewBlobLeaseClient(nil)
 	if err != nil {
 		logger.Sugar.Infof("cannot create lease Blob %s: %v", objectname, err)
-		return nil, nil, err
+		return nil, nil, ErrorFromError(err)
 	}
 	lease, err := leaseBlobClient.AcquireLease(
 		ctx,
@@ -103,8 +120,10 @@ func (azp *Storer) acquireLease(
 			Duration: &leaseTimeout,
 		},
 	)
-
-	return &lease, leaseBlobClient, err
+	if err != nil {
+		return nil, nil, ErrorFromError(err)
+	}
+	return &lease, leaseBlobClient, nil
 }
 
 // ReleaseLeaseDeferable this is intended to use with defer - doesn't return error so we don't need to check it
@@ -142,3 +161,87 @@ func (azp *Storer) ReleaseLease(ctx context.Context, objectname string, leaseID
 	}
 	return nil
 }
+
+// RenewLease renews a lease on a blob, for the duration with which it was
+// acquired
+func (azp *Storer) RenewLease(ctx context.Context, objectname string, leaseID string) error {
+	logger.Sugar.Debugf("RenewLease: %v", objectname)
+
+	leaseBlobClient, err := azp.leaseClient(objectname, &leaseID)
+	if err != nil {
+		return err
+	}
+	_, err = leaseBlobClient.RenewLease(ctx, nil)
+	if err != nil {
+		logger.Sugar.Infof("failed to renew lease %s: %v", objectname, err)
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// ChangeLease changes the id of the active lease on a blob to proposedID,
+// which must be a uuid, or a new uuid if it is empty. The new lease id is
+// returned. The lease keeps its duration and expiry.
+func (azp *Storer) ChangeLease(
+	ctx context.Context, objectname string, leaseID string, proposedID string,
+) (string, error) {
+	logger.Sugar.Debugf("ChangeLease: %v", objectname)
+
+	leaseBlobClient, err := azp.leaseClient(objectname, &leaseID)
+	if err != nil {
+		return "", err
+	}
+	changeOptions := &azStorageBlob.BlobChangeLeaseOptions{}
+	if proposedID != "" {
+		changeOptions.ProposedLeaseID = &proposedID
+	}
+	changed, err := leaseBlobClient.ChangeLease(ctx, changeOptions)
+	if err != nil {
+		logger.Sugar.Infof("failed to change lease %s: %v", objectname, err)
+		return "", ErrorFromError(err)
+	}
+	return *changed.LeaseID, nil
+}
+
+// BreakLease breaks the lease on a blob, without the lease id. The lease ends
+// after breakPeriod seconds, at most 60, or at the end of a fixed lease if
+// that is sooner. A negative breakPeriod ends a fixed lease when it expires
+// and an infinite lease immediately. The time remaining, in seconds, is
+// returned. Until then, the lease can be released but not renewed or changed.
+func (azp *Storer) BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error) {
+	logger.Sugar.Debugf("BreakLease: %v", objectname)
+
+	leaseBlobClient, err := azp.leaseClient(objectname, nil)
+	if err != nil {
+		return 0, err
+	}
+	breakOptions := &azStorageBlob.BlobBreakLeaseOptions{}
+	if breakPeriod >= 0 {
+		breakOptions.BreakPeriod = &breakPeriod
+	}
+	broken, err := leaseBlobClient.BreakLease(ctx, breakOptions)
+	if err != nil {
+		logger.Sugar.Infof("failed to break lease %s: %v", objectname, err)
+		return 0, ErrorFromError(err)
+	}
+	if broken.LeaseTime == nil {
+		return 0, nil
+	}
+	return *broken.LeaseTime, nil
+}
+
+// leaseClient returns a lease client for the blob, with the lease id if it is
+// not nil
+func (azp *Storer) leaseClient(objectname string, leaseID *string) (*azStorageBlob.BlobLeaseClient, error) {
+	blobClient, err := azp.containerClient.NewBlobClient(objectname)
+	if err != nil {
+		logger.Sugar.Infof("cannot create blob client %s: %v", objectname, err)
+		return nil, ErrorFromError(err)
+	}
+	leaseBlobClient, err := blobClient.NewBlobLeaseClient(leaseID)
+	if err != nil {
+		logger.Sugar.Infof("cannot create lease Blob %s: %v", objectname, err)
+		return nil, ErrorFromError(err)
+	}
+	return leaseBlobClient, nil
+}
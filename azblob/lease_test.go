@@ -0,0 +1,262 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreLeases(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// acquiring a lease creates the blob if it does not exist
+			leaseID, err := store.AcquireLease(ctx, "lock", 15)
+			require.NoError(t, err)
+
+			_, err = store.AcquireLease(ctx, "lock", 15)
+			require.Error(t, err)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")))
+			require.Error(t, err)
+			assert.Equal(t, errCodeLeaseIDMissing, ErrorFromError(err).StorageErrorCode())
+
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			require.NoError(t, err)
+
+			require.Error(t, store.Delete(ctx, "lock"))
+			require.NoError(t, store.ReleaseLease(ctx, "lock", leaseID))
+			require.NoError(t, store.Delete(ctx, "lock"))
+		})
+	}
+}
+
+func TestLocalStoreLeaseExpiry(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	store := NewMemoryStore(logger.Sugar, "testcontainer")
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := store.AcquireLease(ctx, "lock", 15)
+	require.NoError(t, err)
+
+	now = now.Add(16 * time.Second)
+	_, err = store.AcquireLease(ctx, "lock", -1)
+	require.NoError(t, err)
+}
+
+func TestLocalStoreLeaseBreakAndChange(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			store.now = func() time.Time { return now }
+			ctx := context.Background()
+
+			leaseID, err := store.AcquireLease(ctx, "lock", 15)
+			require.NoError(t, err)
+
+			now = now.Add(10 * time.Second)
+			require.NoError(t, store.RenewLease(ctx, "lock", leaseID))
+			now = now.Add(10 * time.Second)
+			_, err = store.AcquireLease(ctx, "lock", 15)
+			assert.Equal(t, errCodeLeaseAlreadyPresent, ErrorFromError(err).StorageErrorCode())
+
+			newID, err := store.ChangeLease(ctx, "lock", leaseID, "")
+			require.NoError(t, err)
+			assert.NotEqual(t, leaseID, newID)
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			assert.Equal(t, errCodeLeaseIDMismatchWithBlobOperation, ErrorFromError(err).StorageErrorCode())
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(newID))
+			require.NoError(t, err)
+
+			// the break period is limited by the time left on the lease
+			remaining, err := store.BreakLease(ctx, "lock", 60)
+			require.NoError(t, err)
+			assert.Equal(t, int32(5), remaining)
+
+			err = store.RenewLease(ctx, "lock", newID)
+			assert.Equal(t, errCodeLeaseIsBrokenAndCannotBeRenewed, ErrorFromError(err).StorageErrorCode())
+			_, err = store.ChangeLease(ctx, "lock", newID, "")
+			assert.Equal(t, errCodeLeaseIsBreakingAndCannotBeChanged, ErrorFromError(err).StorageErrorCode())
+			_, err = store.AcquireLease(ctx, "lock", 15)
+			require.Error(t, err)
+
+			now = now.Add(5 * time.Second)
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+
+			// an infinite lease breaks immediately by default
+			leaseID, err = store.AcquireLease(ctx, "lock", -1)
+			require.NoError(t, err)
+			remaining, err = store.BreakLease(ctx, "lock", -1)
+			require.NoError(t, err)
+			assert.Equal(t, int32(0), remaining)
+			err = store.ReleaseLease(ctx, "lock", leaseID)
+			require.NoError(t, err)
+
+			_, err = store.BreakLease(ctx, "lock", -1)
+			assert.Equal(t, errCodeLeaseNotPresentWithLeaseOperation, ErrorFromError(err).StorageErrorCode())
+		})
+	}
+}
+
+func TestLocalStoreLeaseProperties(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			store.now = func() time.Time { return now }
+			ctx := context.Background()
+
+			assertLease := func(state, status, duration string) {
+				t.Helper()
+				rr, err := store.Reader(ctx, "lock", WithGetMetadata(OnlyMetadata))
+				require.NoError(t, err)
+				assert.Equal(t, state, rr.LeaseState)
+				assert.Equal(t, status, rr.LeaseStatus)
+				assert.Equal(t, duration, rr.LeaseDuration)
+				assert.Equal(t, status == "locked", rr.Leased())
+			}
+
+			_, err := store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+			assertLease("available", "unlocked", "")
+
+			_, err = store.AcquireLease(ctx, "lock", 15)
+			require.NoError(t, err)
+			assertLease("leased", "locked", "fixed")
+
+			_, err = store.BreakLease(ctx, "lock", 10)
+			require.NoError(t, err)
+			assertLease("breaking", "locked", "")
+			now = now.Add(10 * time.Second)
+			assertLease("broken", "unlocked", "")
+
+			_, err = store.AcquireLease(ctx, "lock", 15)
+			require.NoError(t, err)
+			now = now.Add(16 * time.Second)
+			assertLease("expired", "unlocked", "")
+
+			leaseID, err := store.AcquireLease(ctx, "lock", -1)
+			require.NoError(t, err)
+			assertLease("leased", "locked", "infinite")
+			require.NoError(t, store.ReleaseLease(ctx, "lock", leaseID))
+			assertLease("available", "unlocked", "")
+		})
+	}
+}
+
+// leaseTransport models the azure responses to acquiring a lease on, and to
+// creating, a single blob, which does not exist until it is created. The
+// first contenders to acquire the lease are all told it does not exist, so
+// that they race to create it.
+type leaseTransport struct {
+	mu          sync.Mutex
+	contenders  int
+	notFound    int
+	raced       chan struct{}
+	exists      bool
+	leaseID     string
+	unleasedPut bool
+}
+
+func (t *leaseTransport) Do(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var resp *http.Response
+	switch {
+	case req.URL.Query().Get("comp") == "lease":
+		switch {
+		case !t.exists:
+			t.notFound++
+			if t.notFound == t.contenders {
+				close(t.raced)
+			}
+			t.mu.Unlock()
+			<-t.raced
+			t.mu.Lock()
+			resp = storageErrorResponse(http.StatusNotFound, azStorageBlob.StorageErrorCodeBlobNotFound)
+		case t.leaseID != "":
+			resp = storageErrorResponse(http.StatusConflict, azStorageBlob.StorageErrorCodeLeaseAlreadyPresent)
+		default:
+			t.leaseID = uuid.NewString()
+			resp = fakeResponse(http.StatusCreated, nil)
+			resp.Header.Set("x-ms-lease-id", t.leaseID)
+		}
+	case req.URL.Query().Get("comp") == "blocklist":
+		switch {
+		case t.exists && req.Header.Get("If-None-Match") == "*":
+			resp = storageErrorResponse(http.StatusConflict, azStorageBlob.StorageErrorCodeBlobAlreadyExists)
+		case t.leaseID != "" && req.Header.Get("x-ms-lease-id") != t.leaseID:
+			t.unleasedPut = true
+			resp = storageErrorResponse(http.StatusPreconditionFailed, azStorageBlob.StorageErrorCodeLeaseIDMissing)
+		default:
+			t.exists = true
+			resp = fakeResponse(http.StatusCreated, http.Header{"ETag": {"\"0x1\""}})
+		}
+	default:
+		resp = fakeResponse(http.StatusBadRequest, nil)
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+func TestAcquireLeaseCreateRace(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	const contenders = 4
+	transport := &leaseTransport{contenders: contenders, raced: make(chan struct{})}
+	azp := fakeStorer(t, transport)
+	azp.skipContainerCheck = true
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	leaseIDs := make([]string, contenders)
+	errs := make([]error, contenders)
+	for i := range contenders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leaseIDs[i], errs[i] = azp.AcquireLease(ctx, "lock", 15)
+		}()
+	}
+	wg.Wait()
+
+	// exactly one gets the lease, the others find the blob they tried to
+	// create is leased
+	acquired := 0
+	for i := range contenders {
+		if errs[i] != nil {
+			assert.ErrorIs(t, errs[i], ErrLeaseAlreadyPresent)
+			continue
+		}
+		acquired++
+		assert.Equal(t, transport.leaseID, leaseIDs[i])
+	}
+	assert.Equal(t, 1, acquired)
+	assert.False(t, transport.unleasedPut)
+}
@@ -0,0 +1,243 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+const (
+	// leaseRenewalFraction is the fraction of the lease duration after which a
+	// LeaseHandle renews the lease. Renewing at a third leaves time for a
+	// slow renewal to complete before the lease expires.
+	leaseRenewalFraction = 3
+
+	// leaseExpiryMargin is how long before the lease would expire, counting
+	// from its last successful renewal, that a LeaseHandle stops retrying a
+	// failed renewal. It allows for the latency of the renewal and for clock
+	// skew with the service.
+	leaseExpiryMargin = 2 * time.Second
+)
+
+var (
+	// ErrLeaseReleased is the cause of the cancellation of a LeaseHandle
+	// context when the holder released, or broke, the lease. ErrLeaseLost is
+	// the cause when the lease could not be renewed, eg because it was broken.
+	ErrLeaseReleased = errors.New("lease released")
+)
+
+// LeaseManager is the interface for the complete lease lifecycle of blobs. It
+// is implemented by Storer, MemoryStore and FileStore.
+type LeaseManager interface {
+	Leaser
+	RenewLease(ctx context.Context, objectname string, leaseID string) error
+	ChangeLease(ctx context.Context, objectname string, leaseID string, proposedID string) (string, error)
+	BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error)
+}
+
+var (
+	_ LeaseManager = (*Storer)(nil)
+	_ LeaseManager = (*MemoryStore)(nil)
+	_ LeaseManager = (*FileStore)(nil)
+)
+
+// LeaseHandle holds a lease on a blob, and keeps it by renewing it in the
+// background until it is released.
+//
+// Work which must only be done while the lease is held should use Context.
+// It is cancelled when the lease is lost, and when the lease is released.
+// context.Cause, or Err, reports ErrLeaseLost or ErrLeaseReleased
+// respectively. A renewal which fails, eg because the service is busy, is
+// retried until the lease would have expired, less leaseExpiryMargin, at which
+// point the lease may be taken by another holder. A lease which is known to be
+// lost, eg because it was broken, is not retried.
+type LeaseHandle struct {
+	leaser     LeaseManager
+	objectname string
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	// mu serialises the lease operations, so that a renewal never uses an id
+	// which is being changed
+	mu      sync.Mutex
+	leaseID string
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// AcquireLeaseHandle acquires a lease on the blob, as for AcquireLease, and
+// returns a handle which renews it every third of leaseTimeout. An infinite
+// lease (-1) is never renewed. The handle's Context is derived from ctx, the
+// renewals stop when ctx is done but the lease is not released.
+func AcquireLeaseHandle(
+	ctx context.Context,
+	leaser LeaseManager,
+	objectname string,
+	leaseTimeout int32,
+) (*LeaseHandle, error) {
+
+	var interval, expiry time.Duration
+	if leaseTimeout > 0 {
+		interval = time.Duration(leaseTimeout) * time.Second / leaseRenewalFraction
+		expiry = time.Duration(leaseTimeout)*time.Second - leaseExpiryMargin
+	}
+	return acquireLeaseHandle(ctx, leaser, objectname, leaseTimeout, interval, expiry)
+}
+
+// acquireLeaseHandle acquires a lease which is renewed every interval, or
+// never if interval is zero. A failed renewal is retried until expiry after
+// the last successful one.
+func acquireLeaseHandle(
+	ctx context.Context,
+	leaser LeaseManager,
+	objectname string,
+	leaseTimeout int32,
+	interval time.Duration,
+	expiry time.Duration,
+) (*LeaseHandle, error) {
+
+	// the lease expires counting from when it was requested, at the latest
+	acquired := time.Now()
+	leaseID, err := leaser.AcquireLease(ctx, objectname, leaseTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &LeaseHandle{
+		leaser:     leaser,
+		objectname: objectname,
+		leaseID:    leaseID,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	h.ctx, h.cancel = context.WithCancelCause(ctx)
+
+	if interval <= 0 {
+		close(h.done)
+		return h, nil
+	}
+	go h.keepAlive(interval, acquired.Add(expiry), expiry)
+	return h, nil
+}
+
+// keepAlive renews the lease every interval until the handle is stopped or
+// its context is done. A failed renewal is retried, more often, until the
+// deadline, which is expiry after the last successful renewal. The context is
+// cancelled if the lease is lost, or the deadline passes.
+func (h *LeaseHandle) keepAlive(interval time.Duration, deadline time.Time, expiry time.Duration) {
+	defer close(h.done)
+
+	retryInterval := interval / leaseRenewalFraction
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-h.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// the renewal, including the retries of the azure pipeline, may take
+		// until the deadline
+		renewed := time.Now()
+		renewCtx, cancel := context.WithDeadline(h.ctx, deadline)
+		h.mu.Lock()
+		err := h.leaser.RenewLease(renewCtx, h.objectname, h.leaseID)
+		h.mu.Unlock()
+		cancel()
+		switch {
+		case err == nil:
+			deadline = renewed.Add(expiry)
+			timer.Reset(interval)
+		case errors.Is(err, ErrLeaseLost):
+			logger.Sugar.Infof("lease lost %s: %v", h.objectname, err)
+			h.cancel(fmt.Errorf("%w: %s: %w", ErrLeaseLost, h.objectname, err))
+			return
+		case !time.Now().Add(retryInterval).Before(deadline):
+			logger.Sugar.Infof("lease not renewed in time %s: %v", h.objectname, err)
+			h.cancel(fmt.Errorf("%w: %s: %w", ErrLeaseLost, h.objectname, err))
+			return
+		default:
+			logger.Sugar.Infof("retrying lease renewal %s: %v", h.objectname, err)
+			timer.Reset(retryInterval)
+		}
+	}
+}
+
+// stopKeepAlive stops the renewals and waits for any renewal in progress
+func (h *LeaseHandle) stopKeepAlive() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	<-h.done
+}
+
+// ID returns the current lease id, which changes if Change is called
+func (h *LeaseHandle) ID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.leaseID
+}
+
+// Context returns a context which is cancelled when the lease is lost or
+// released
+func (h *LeaseHandle) Context() context.Context {
+	return h.ctx
+}
+
+// Err returns nil while the lease is held. Otherwise, it returns the reason
+// it is not, which wraps ErrLeaseLost or ErrLeaseReleased, or the error of the
+// context from which the handle was acquired.
+func (h *LeaseHandle) Err() error {
+	if h.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(h.ctx)
+}
+
+// Release stops the renewals, cancels the handle's context and releases the
+// lease. As for ReleaseLease, the lease is released even if ctx is done.
+func (h *LeaseHandle) Release(ctx context.Context) error {
+	h.stopKeepAlive()
+	h.cancel(ErrLeaseReleased)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.leaser.ReleaseLease(ctx, h.objectname, h.leaseID)
+}
+
+// Change changes the lease id to proposedID, or to a new uuid if it is empty,
+// and returns the new id. The renewals continue with the new id.
+func (h *LeaseHandle) Change(ctx context.Context, proposedID string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.Err(); err != nil {
+		return "", err
+	}
+	leaseID, err := h.leaser.ChangeLease(ctx, h.objectname, h.leaseID, proposedID)
+	if err != nil {
+		return "", err
+	}
+	h.leaseID = leaseID
+	return leaseID, nil
+}
+
+// Break stops the renewals, cancels the handle's context and breaks the lease,
+// as for BreakLease. Unlike Release, the blob can't be leased again until the
+// break period has passed, which gives other holders time to notice.
+func (h *LeaseHandle) Break(ctx context.Context, breakPeriod int32) (int32, error) {
+	h.stopKeepAlive()
+	h.cancel(ErrLeaseReleased)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.leaser.BreakLease(ctx, h.objectname, breakPeriod)
+}
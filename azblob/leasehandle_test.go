@@ -0,0 +1,123 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreLeaseHandle(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			h, err := acquireLeaseHandle(ctx, store, "lock", 15, 10*time.Millisecond, 13*time.Second)
+			require.NoError(t, err)
+			require.NoError(t, h.Err())
+
+			leaseID, err := h.Change(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, leaseID, h.ID())
+			time.Sleep(30 * time.Millisecond)
+			_, err = store.Put(ctx, "lock", NewBytesReaderCloser([]byte("VALUE")), WithLeaseID(leaseID))
+			require.NoError(t, err)
+
+			// a lease broken by another holder can't be renewed
+			_, err = store.BreakLease(ctx, "lock", 15)
+			require.NoError(t, err)
+			select {
+			case <-h.Context().Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("lease handle context not cancelled")
+			}
+			assert.ErrorIs(t, h.Err(), ErrLeaseLost)
+			require.NoError(t, h.Release(ctx))
+
+			// an infinite lease is not renewed
+			h, err = AcquireLeaseHandle(ctx, store, "infinite", -1)
+			require.NoError(t, err)
+			_, err = store.AcquireLease(ctx, "infinite", 15)
+			require.Error(t, err)
+			require.NoError(t, h.Release(ctx))
+			assert.ErrorIs(t, h.Err(), ErrLeaseReleased)
+			_, err = store.AcquireLease(ctx, "infinite", 15)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// flakyRenewer fails the first failures renewals, or all of them if failures
+// is negative, as though the service were unavailable
+type flakyRenewer struct {
+	LeaseManager
+	mu        sync.Mutex
+	failures  int
+	renewals  int
+	deadlines []time.Duration
+}
+
+func (f *flakyRenewer) RenewLease(ctx context.Context, objectname string, leaseID string) error {
+	f.mu.Lock()
+	f.renewals++
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadlines = append(f.deadlines, time.Until(deadline))
+	}
+	fail := f.failures != 0
+	if f.failures > 0 {
+		f.failures--
+	}
+	f.mu.Unlock()
+	if fail {
+		return NewStatusError("service unavailable", http.StatusServiceUnavailable)
+	}
+	return f.LeaseManager.RenewLease(ctx, objectname, leaseID)
+}
+
+func TestLeaseHandleRenewalRetries(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	interval := 10 * time.Millisecond
+	expiry := 150 * time.Millisecond
+
+	// failed renewals are retried, the handle is kept if one succeeds in time
+	leaser := &flakyRenewer{LeaseManager: NewMemoryStore(logger.Sugar, "testcontainer"), failures: 3}
+	h, err := acquireLeaseHandle(ctx, leaser, "lock", 15, interval, expiry)
+	require.NoError(t, err)
+	time.Sleep(expiry + 5*interval)
+	require.NoError(t, h.Err())
+	leaser.mu.Lock()
+	assert.Greater(t, leaser.renewals, 4)
+	// the renewals may take until the lease would expire, not just an interval
+	assert.Greater(t, leaser.deadlines[0], interval)
+	leaser.mu.Unlock()
+	require.NoError(t, h.Release(ctx))
+
+	// and the handle is only cancelled once the lease would have expired
+	leaser = &flakyRenewer{LeaseManager: NewMemoryStore(logger.Sugar, "testcontainer"), failures: -1}
+	start := time.Now()
+	h, err = acquireLeaseHandle(ctx, leaser, "lock", 15, interval, expiry)
+	require.NoError(t, err)
+	select {
+	case <-h.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease handle context not cancelled")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), expiry-interval)
+	assert.ErrorIs(t, h.Err(), ErrLeaseLost)
+	leaser.mu.Lock()
+	assert.Greater(t, leaser.renewals, 1)
+	leaser.mu.Unlock()
+	require.NoError(t, h.Release(ctx))
+}
@@ -6,24 +6,25 @@ import (
 	"context"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
 )
 
 // Count counts the number of blobs filtered by the given tags filter
 func (azp *Storer) Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error) {
+	return countFiltered(ctx, azp, tagsFilter, opts...)
+}
 
-	var count int64
-	var m ListMarker
+// countFiltered counts the blobs filtered by the tags filter by paging through
+// the results of the readers FilteredList
+func countFiltered(ctx context.Context, r Reader, tagsFilter string, opts ...Option) (int64, error) {
 
-	for {
-		r, err := azp.FilteredList(ctx, tagsFilter, append(opts, WithListMarker(m))...)
+	var count int64
+	for _, err := range FilteredListAll(ctx, r, tagsFilter, opts...) {
 		if err != nil {
 			return 0, err
 		}
-		count += int64(len(r.Items))
-		if r.Marker == nil || *r.Marker == "" {
-			break
-		}
-		m = r.Marker
+		count++
 	}
 	return count, nil
 }
@@ -59,6 +60,9 @@ type FilterResponse struct {
 //
 // See also: https://learn.microsoft.com/en-us/rest/api/storageservices/find-blobs-by-tags-container?tabs=microsoft-entra-id
 //
+// The tagfilter package can be used to build the filter. Malformed filters are
+// rejected before any request is made.
+//
 // Returns all blobs with the specific tag filter.
 func (azp *Storer) FilteredList(ctx context.Context, tagsFilter string, opts ...Option) (*FilterResponse, error) {
 	var span Spanner
@@ -69,6 +73,10 @@ func (azp *Storer) FilteredList(ctx context.Context, tagsFilter string, opts ...
 
 	var err error
 
+	if _, err = tagfilter.ParseFind(tagsFilter); err != nil {
+		return nil, err
+	}
+
 	options := &StorerOptions{}
 	for _, opt := range opts {
 		opt(options)
@@ -117,6 +125,9 @@ type ListerResponse struct {
 	Status     string
 
 	Items []*azStorageBlob.BlobItemInternal
+
+	// Prefixes are the virtual directories, only listed if WithListDelim is used
+	Prefixes []*azStorageBlob.BlobPrefix
 }
 
 func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, error) {
@@ -148,6 +159,15 @@ func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, e
 	if options.listIncludeMetadata {
 		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemMetadata)
 	}
+	if options.listIncludeSnapshots {
+		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemSnapshots)
+	}
+	if options.listIncludeVersions {
+		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemVersions)
+	}
+	if options.listIncludeDeleted {
+		o.Include = append(o.Include, azStorageBlob.ListBlobsIncludeItemDeleted)
+	}
 	if options.listMaxResults > 0 {
 		o.MaxResults = &options.listMaxResults
 		if span != nil {
@@ -155,12 +175,24 @@ func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, e
 		}
 	}
 
-	// TODO: v1.21 feature which would be great
-	// if options.listDelim != "" {
-	// }
+	if options.listDelim != "" {
+		if span != nil {
+			span.SetTag("delim", options.listDelim)
+		}
+		return azp.listHierarchy(ctx, span, options.listDelim, &azStorageBlob.ContainerListBlobsHierarchyOptions{
+			Include:    o.Include,
+			Marker:     o.Marker,
+			MaxResults: o.MaxResults,
+			Prefix:     o.Prefix,
+		})
+	}
+
 	r := &ListerResponse{}
 	pager := azp.containerClient.ListBlobsFlat(&o)
 	if !pager.NextPage(ctx) {
+		if err := pager.Err(); err != nil {
+			return nil, ErrorFromError(err)
+		}
 		return r, nil
 	}
 	resp := pager.PageResponse()
@@ -182,3 +214,39 @@ func (azp *Storer) List(ctx context.Context, opts ...Option) (*ListerResponse, e
 
 	return r, nil
 }
+
+// listHierarchy lists a page of blobs and virtual directories
+func (azp *Storer) listHierarchy(
+	ctx context.Context,
+	span Spanner,
+	delim string,
+	o *azStorageBlob.ContainerListBlobsHierarchyOptions,
+) (*ListerResponse, error) {
+
+	r := &ListerResponse{}
+	pager := azp.containerClient.ListBlobsHierarchy(delim, o)
+	if !pager.NextPage(ctx) {
+		if err := pager.Err(); err != nil {
+			return nil, ErrorFromError(err)
+		}
+		return r, nil
+	}
+	resp := pager.PageResponse()
+	r.Status = resp.RawResponse.Status
+	r.StatusCode = resp.RawResponse.StatusCode
+
+	if resp.Prefix != nil {
+		r.Prefix = *resp.Prefix
+	}
+
+	r.Marker = resp.NextMarker
+	if r.Marker != nil && span != nil {
+		span.SetTag("nextmarker", *r.Marker)
+	}
+
+	if resp.Segment != nil {
+		r.Items = resp.Segment.BlobItems
+		r.Prefixes = resp.Segment.BlobPrefixes
+	}
+	return r, nil
+}
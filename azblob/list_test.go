@@ -0,0 +1,73 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreFilteredList(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for i := range 5 {
+				_, err := store.Put(
+					ctx, fmt.Sprintf("massif/%d", i), NewBytesReaderCloser([]byte("VALUE")),
+					WithTags(map[string]string{"firstindex": fmt.Sprintf("%016x", i)}))
+				require.NoError(t, err)
+			}
+
+			count, err := store.Count(
+				ctx, tagfilter.And(tagfilter.Container("testcontainer"), tagfilter.Gt("firstindex", "0000000000000001")).String(),
+				WithListMaxResults(2))
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), count)
+
+			_, err = store.FilteredList(ctx, `"firstindex" <> '0'`)
+			assert.ErrorIs(t, err, tagfilter.ErrNotSupportedFind)
+		})
+	}
+}
+
+func TestLocalStoreList(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for i := range 5 {
+				_, err := store.Put(ctx, fmt.Sprintf("tenant/%d", i), NewBytesReaderCloser([]byte("VALUE")))
+				require.NoError(t, err)
+			}
+			_, err := store.Put(ctx, "other", NewBytesReaderCloser([]byte("VALUE")))
+			require.NoError(t, err)
+
+			var names []string
+			var marker ListMarker
+			for {
+				r, err := store.List(ctx, WithListPrefix("tenant/"), WithListMaxResults(2), WithListMarker(marker))
+				require.NoError(t, err)
+				for _, item := range r.Items {
+					names = append(names, *item.Name)
+				}
+				if r.Marker == nil {
+					break
+				}
+				marker = r.Marker
+			}
+			assert.Equal(t, []string{"tenant/0", "tenant/1", "tenant/2", "tenant/3", "tenant/4"}, names)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package azblob
+
+import (
+	"context"
+	"iter"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// ListAll returns an iterator over all the blobs listed by the options,
+// requesting further pages as the iteration proceeds. WithListMaxResults sets
+// the page size.
+//
+// The iteration stops after yielding the first error, including the error of
+// a cancelled ctx. Use WithListContinuation to record a marker from which an
+// interrupted iteration can be resumed.
+func (azp *Storer) ListAll(ctx context.Context, opts ...Option) iter.Seq2[*azStorageBlob.BlobItemInternal, error] {
+	return ListAll(ctx, azp, opts...)
+}
+
+// FilteredListAll returns an iterator over all the blobs filtered by the tags
+// filter, see Storer.ListAll and Storer.FilteredList
+func (azp *Storer) FilteredListAll(ctx context.Context, tagsFilter string, opts ...Option) iter.Seq2[*azStorageBlob.FilterBlobItem, error] {
+	return FilteredListAll(ctx, azp, tagsFilter, opts...)
+}
+
+// ListAll returns an iterator over all the local blobs, see Storer.ListAll
+func (s *localStore) ListAll(ctx context.Context, opts ...Option) iter.Seq2[*azStorageBlob.BlobItemInternal, error] {
+	return ListAll(ctx, s, opts...)
+}
+
+// FilteredListAll returns an iterator over the filtered local blobs, see
+// Storer.FilteredListAll
+func (s *localStore) FilteredListAll(ctx context.Context, tagsFilter string, opts ...Option) iter.Seq2[*azStorageBlob.FilterBlobItem, error] {
+	return FilteredListAll(ctx, s, tagsFilter, opts...)
+}
+
+// ListAll returns an iterator over all the blobs listed by any Reader, see
+// Storer.ListAll
+func ListAll(ctx context.Context, r Reader, opts ...Option) iter.Seq2[*azStorageBlob.BlobItemInternal, error] {
+	return listPages(ctx, opts, func(opts []Option) ([]*azStorageBlob.BlobItemInternal, ListMarker, error) {
+		lr, err := r.List(ctx, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lr.Items, lr.Marker, nil
+	})
+}
+
+// FilteredListAll returns an iterator over all the blobs filtered by any
+// Reader, see Storer.FilteredListAll
+func FilteredListAll(ctx context.Context, r Reader, tagsFilter string, opts ...Option) iter.Seq2[*azStorageBlob.FilterBlobItem, error] {
+	return listPages(ctx, opts, func(opts []Option) ([]*azStorageBlob.FilterBlobItem, ListMarker, error) {
+		fr, err := r.FilteredList(ctx, tagsFilter, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fr.Items, fr.Marker, nil
+	})
+}
+
+// listPages yields the items of each page returned by next, passing the
+// marker of the previous page to each call.
+func listPages[T any](
+	ctx context.Context,
+	opts []Option,
+	next func(opts []Option) ([]T, ListMarker, error),
+) iter.Seq2[T, error] {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		marker := options.listMarker
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			// the marker option is appended so that it takes precedence
+			items, nextMarker, err := next(append(opts[:len(opts):len(opts)], WithListMarker(marker)))
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if nextMarker != nil && *nextMarker == "" {
+				nextMarker = nil
+			}
+			if options.listContinue != nil {
+				options.listContinue(nextMarker)
+			}
+			if nextMarker == nil {
+				return
+			}
+			marker = nextMarker
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreListAll(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for i := range 5 {
+				_, err := store.Put(ctx, fmt.Sprintf("tenant/%d", i), NewBytesReaderCloser([]byte("VALUE")),
+					WithTags(map[string]string{"kind": "tenant"}))
+				require.NoError(t, err)
+			}
+
+			var names []string
+			var markers []ListMarker
+			for item, err := range store.ListAll(ctx, WithListMaxResults(2),
+				WithListContinuation(func(next ListMarker) { markers = append(markers, next) })) {
+				require.NoError(t, err)
+				names = append(names, *item.Name)
+			}
+			assert.Equal(t, []string{"tenant/0", "tenant/1", "tenant/2", "tenant/3", "tenant/4"}, names)
+			require.Len(t, markers, 3)
+			assert.Nil(t, markers[2])
+
+			// resume from the marker recorded after the first page
+			names = nil
+			for item, err := range store.ListAll(ctx, WithListMaxResults(2), WithListMarker(markers[0])) {
+				require.NoError(t, err)
+				names = append(names, *item.Name)
+				if len(names) == 2 {
+					break
+				}
+			}
+			assert.Equal(t, []string{"tenant/2", "tenant/3"}, names)
+
+			count, err := countFiltered(ctx, store, "kind='tenant'", WithListMaxResults(2))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), count)
+
+			cctx, cancel := context.WithCancel(ctx)
+			cancel()
+			for _, err := range store.FilteredListAll(cctx, "kind='tenant'") {
+				assert.ErrorIs(t, err, context.Canceled)
+			}
+		})
+	}
+}
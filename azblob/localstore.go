@@ -0,0 +1,1134 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
+)
+
+// Storage error codes, as documented for the blob service REST api, that are
+// reported by the local BlobStore implementations.
+const (
+	errCodeBlobNotFound                      = "BlobNotFound"
+	errCodeAppendPositionConditionNotMet     = "AppendPositionConditionNotMet"
+	errCodeBlobAlreadyExists                 = "BlobAlreadyExists"
+	errCodeBlobArchived                      = "BlobArchived"
+	errCodeBlobBeingRehydrated               = "BlobBeingRehydrated"
+	errCodeBlockCountExceedsLimit            = "BlockCountExceedsLimit"
+	errCodeCannotVerifyCopySource            = "CannotVerifyCopySource"
+	errCodeConditionNotMet                   = "ConditionNotMet"
+	errCodeCopyIDMismatch                    = "CopyIdMismatch"
+	errCodeInvalidBlobType                   = "InvalidBlobType"
+	errCodeInvalidBlockList                  = "InvalidBlockList"
+	errCodeInvalidHeaderValue                = "InvalidHeaderValue"
+	errCodeInvalidPageRange                  = "InvalidPageRange"
+	errCodeInvalidRange                      = "InvalidRange"
+	errCodeLeaseAlreadyPresent               = "LeaseAlreadyPresent"
+	errCodeLeaseIDMissing                    = "LeaseIdMissing"
+	errCodeLeaseIsBreakingAndCannotBeChanged = "LeaseIsBreakingAndCannotBeChanged"
+	errCodeLeaseIsBrokenAndCannotBeRenewed   = "LeaseIsBrokenAndCannotBeRenewed"
+	errCodeLeaseIDMismatchWithBlobOperation  = "LeaseIdMismatchWithBlobOperation"
+	errCodeLeaseIDMismatchWithLeaseOperation = "LeaseIdMismatchWithLeaseOperation"
+	errCodeLeaseNotPresentWithBlobOperation  = "LeaseNotPresentWithBlobOperation"
+	errCodeLeaseNotPresentWithLeaseOperation = "LeaseNotPresentWithLeaseOperation"
+	errCodeMaxBlobSizeConditionNotMet        = "MaxBlobSizeConditionNotMet"
+	errCodeNoPendingCopyOperation            = "NoPendingCopyOperation"
+	errCodePreviousSnapshotNotFound          = "PreviousSnapshotNotFound"
+	errCodeSnapshotsPresent                  = "SnapshotsPresent"
+	errCodeSourceConditionNotMet             = "SourceConditionNotMet"
+)
+
+const (
+	// defaultListMaxResults is the page size the azure service uses if none is specified
+	defaultListMaxResults = 5000
+
+	infiniteLeaseDuration = -1
+	minLeaseDuration      = 15
+	maxLeaseDuration      = 60
+)
+
+// localBlob is the state the local BlobStore implementations keep for each
+// blob. Once stored, a localBlob is treated as immutable. Changes are made to a
+// copy which then replaces the original.
+type localBlob struct {
+	Name         string            `json:"name"`
+	Data         []byte            `json:"-"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"lastModified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	// ContentMD5 is only set for blobs created by Put, as it is for azure
+	ContentMD5 []byte `json:"contentMD5,omitempty"`
+	// BlobType is empty for block blobs
+	BlobType            string `json:"blobType,omitempty"`
+	CommittedBlockCount int32  `json:"committedBlockCount,omitempty"`
+	// AccessTier is empty for the default tier of the account, which is hot
+	AccessTier string `json:"accessTier,omitempty"`
+	// RehydrateTo is the tier an archived blob is being rehydrated to, the
+	// rehydration completes at RehydrateAt
+	RehydrateTo       string    `json:"rehydrateTo,omitempty"`
+	RehydratePriority string    `json:"rehydratePriority,omitempty"`
+	RehydrateAt       time.Time `json:"rehydrateAt,omitempty"`
+	// The Copy fields are only set for blobs created by Copy
+	CopyID       string `json:"copyId,omitempty"`
+	CopyStatus   string `json:"copyStatus,omitempty"`
+	CopySource   string `json:"copySource,omitempty"`
+	CopyProgress string `json:"copyProgress,omitempty"`
+	// Pages are the written pages of a page blob
+	Pages []PageRange `json:"pages,omitempty"`
+	// Snapshot is only set for snapshots. VersionID is set for every version
+	// written while versioning is enabled.
+	Snapshot  string `json:"snapshot,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	// Deleted is the time at which the blob, snapshot or version was soft
+	// deleted, in the same format as a snapshot time
+	Deleted string `json:"deleted,omitempty"`
+	localLease
+}
+
+// localLease is the lease state of a blob
+type localLease struct {
+	LeaseID string `json:"leaseId,omitempty"`
+	// LeaseExpires is zero for an infinite lease. Once the lease is broken it
+	// is the end of the break period.
+	LeaseExpires time.Time `json:"leaseExpires,omitempty"`
+	// LeaseDuration is the duration, in seconds, with which the lease was
+	// acquired, or -1 for an infinite lease
+	LeaseDuration int32 `json:"leaseDuration,omitempty"`
+	LeaseBroken   bool  `json:"leaseBroken,omitempty"`
+}
+
+// leased returns true if the blob has an active lease at the time now
+func (b *localBlob) leased(now time.Time) bool {
+	return b.LeaseID != "" && (b.LeaseExpires.IsZero() || now.Before(b.LeaseExpires))
+}
+
+// leaseProperties returns the lease state, status and duration of the blob at
+// the time now, as reported by azure
+func (b *localBlob) leaseProperties(now time.Time) (string, string, string) {
+	var state azStorageBlob.LeaseStateType
+	var duration azStorageBlob.LeaseDurationType
+	switch {
+	case b.LeaseID == "":
+		state = azStorageBlob.LeaseStateTypeAvailable
+	case b.LeaseBroken && b.leased(now):
+		state = azStorageBlob.LeaseStateTypeBreaking
+	case b.LeaseBroken:
+		state = azStorageBlob.LeaseStateTypeBroken
+	case !b.leased(now):
+		state = azStorageBlob.LeaseStateTypeExpired
+	case b.LeaseDuration == infiniteLeaseDuration:
+		state = azStorageBlob.LeaseStateTypeLeased
+		duration = azStorageBlob.LeaseDurationTypeInfinite
+	default:
+		state = azStorageBlob.LeaseStateTypeLeased
+		duration = azStorageBlob.LeaseDurationTypeFixed
+	}
+	status := azStorageBlob.LeaseStatusTypeUnlocked
+	if b.leased(now) {
+		status = azStorageBlob.LeaseStatusTypeLocked
+	}
+	return string(state), string(status), string(duration)
+}
+
+// historyKey identifies a snapshot, prior version or soft deleted blob amongst
+// the history of the blob. Keys sort in the order the history was created,
+// soft deleted blobs first, then snapshots, then versions.
+func (b *localBlob) historyKey() string {
+	if b.Snapshot != "" {
+		return "s" + b.Snapshot
+	}
+	if b.VersionID != "" {
+		return "v" + b.VersionID
+	}
+	return "d" + b.Deleted
+}
+
+// metadata returns a copy of the metadata with the keys canonicalised in the
+// same way as they are when read back from azure.
+func (b *localBlob) metadata() map[string]string {
+	m := make(map[string]string, len(b.Metadata))
+	for k, v := range b.Metadata {
+		m[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	return m
+}
+
+// localBackend persists the blob state for localStore. Implementations need
+// not be safe for concurrent use, localStore serialises all access.
+type localBackend interface {
+	// load returns nil, nil if the blob does not exist
+	load(identity string) (*localBlob, error)
+	store(blob *localBlob) error
+	remove(identity string) error
+	// names returns the names of all blobs in any order, including those
+	// which only have snapshots or prior versions
+	names() ([]string, error)
+	// stageBlock keeps an uncommitted block for the blob, replacing any
+	// block with the same id
+	stageBlock(identity string, blockID string, data []byte) error
+	// stagedBlocks returns the uncommitted blocks for the blob by id
+	stagedBlocks(identity string) (map[string][]byte, error)
+	// discardBlocks removes all the uncommitted blocks for the blob
+	discardBlocks(identity string) error
+	// storeHistory keeps a snapshot or prior version of a blob, replacing
+	// any with the same historyKey
+	storeHistory(blob *localBlob) error
+	// loadHistory returns the snapshots and prior versions of the blob in
+	// any order
+	loadHistory(identity string) ([]*localBlob, error)
+	// removeHistory removes a snapshot or prior version of a blob
+	removeHistory(blob *localBlob) error
+}
+
+// localStore implements the BlobStore semantics on top of a localBackend
+type localStore struct {
+	mu        sync.Mutex
+	log       Logger
+	container string
+	backend   localBackend
+	lastETag  int64
+	// lastHistoryTime is the time, in 100ns units, of the last snapshot or
+	// version id issued
+	lastHistoryTime int64
+	versioning      bool
+	// softDeleteRetention is zero if soft delete is not enabled
+	softDeleteRetention time.Duration
+	rehydrationDelay    time.Duration
+	now                 func() time.Time
+}
+
+func newLocalStore(log Logger, container string, backend localBackend) *localStore {
+	return &localStore{
+		log:       log,
+		container: container,
+		backend:   backend,
+		now:       time.Now,
+	}
+}
+
+// nextETag returns a new, strictly increasing, etag in the same format as
+// those issued by azure
+func (s *localStore) nextETag(now time.Time) string {
+	n := now.UnixNano()
+	if n <= s.lastETag {
+		n = s.lastETag + 1
+	}
+	s.lastETag = n
+	return fmt.Sprintf("\"0x%X\"", n)
+}
+
+// nextHistoryID returns a new, strictly increasing, snapshot time or version
+// id in the same format as those issued by azure
+func (s *localStore) nextHistoryID(now time.Time) string {
+	n := now.UnixNano() / 100
+	if n <= s.lastHistoryTime {
+		n = s.lastHistoryTime + 1
+	}
+	s.lastHistoryTime = n
+	return time.Unix(0, n*100).UTC().Format(historyIDFormat)
+}
+
+func blobNotFoundError(identity string) *Error {
+	return newStorageError(
+		fmt.Sprintf("the specified blob does not exist: %s", identity),
+		http.StatusNotFound, errCodeBlobNotFound)
+}
+
+func conditionNotMetError() *Error {
+	return newStorageError(
+		"the condition specified using HTTP conditional header(s) is not met",
+		http.StatusPreconditionFailed, errCodeConditionNotMet)
+}
+
+// checkLease applies the azure lease rules. Operations that modify a leased
+// blob must supply the active lease id. Reads only need to match the lease if
+// a lease id is supplied.
+func checkLease(b *localBlob, leaseID string, now time.Time, write bool) error {
+	if !write && leaseID == "" {
+		return nil
+	}
+	if b == nil || !b.leased(now) {
+		if leaseID != "" {
+			return newStorageError(
+				"there is currently no lease on the blob",
+				http.StatusPreconditionFailed, errCodeLeaseNotPresentWithBlobOperation)
+		}
+		return nil
+	}
+	if leaseID == "" {
+		return newStorageError(
+			"there is currently a lease on the blob and no lease ID was specified in the request",
+			http.StatusPreconditionFailed, errCodeLeaseIDMissing)
+	}
+	if leaseID != b.LeaseID {
+		return newStorageError(
+			"the lease ID specified did not match the lease ID for the blob",
+			http.StatusPreconditionFailed, errCodeLeaseIDMismatchWithBlobOperation)
+	}
+	return nil
+}
+
+// checkConditions evaluates the If- conditions in options against the blob,
+// which is nil if it does not exist. As for azure, a read which fails an
+// If-None-Match or If-Modified-Since condition is reported as notModified
+// rather than as an error.
+func checkConditions(b *localBlob, options *StorerOptions, read bool) (bool, error) {
+
+	if options.etag == "" && options.etagCondition != EtagNotUsed {
+		return false, errors.New("etag value missing")
+	}
+
+	switch options.etagCondition {
+	case ETagMatch:
+		if b == nil || (options.etag != "*" && options.etag != b.ETag) {
+			return false, conditionNotMetError()
+		}
+	case ETagNoneMatch:
+		if b != nil && (options.etag == "*" || options.etag == b.ETag) {
+			if read {
+				return true, nil
+			}
+			if options.etag == "*" {
+				return false, newStorageError(
+					"the specified blob already exists", http.StatusConflict, errCodeBlobAlreadyExists)
+			}
+			return false, conditionNotMetError()
+		}
+	case TagsWhere:
+		expr, err := tagfilter.ParseWhere(options.etag)
+		if err != nil {
+			return false, err
+		}
+		if b == nil || !expr.Match(b.Tags) {
+			return false, conditionNotMetError()
+		}
+	default:
+	}
+
+	if b == nil || options.since == nil {
+		return false, nil
+	}
+	switch options.sinceCondition {
+	case IfConditionModifiedSince:
+		if !b.LastModified.After(*options.since) {
+			if read {
+				return true, nil
+			}
+			return false, conditionNotMetError()
+		}
+	case IfConditionUnmodifiedSince:
+		if b.LastModified.After(*options.since) {
+			return false, conditionNotMetError()
+		}
+	default:
+	}
+	return false, nil
+}
+
+// Reader reads a blob, honouring the same options as Storer.Reader
+func (s *localStore) Reader(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*ReaderResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.loadSelected(identity, options)
+	if err != nil {
+		return nil, err
+	}
+	b = rehydrated(b, s.now())
+	if b == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	// leases only apply to the current blob
+	if options.snapshot == "" && options.versionID == "" {
+		if err = checkLease(b, options.leaseID, s.now(), false); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &ReaderResponse{}
+	if len(options.tags) > 0 || options.getTags {
+		resp.Tags = maps.Clone(b.Tags)
+	}
+	if err = checkRequiredTags(identity, options.tags, b.Tags); err != nil {
+		return nil, err
+	}
+
+	etag := b.ETag
+	lastModified := b.LastModified
+	resp.ETag = &etag
+	resp.LastModified = &lastModified
+
+	resp.BlobSize = int64(len(b.Data))
+	resp.LeaseState, resp.LeaseStatus, resp.LeaseDuration = b.leaseProperties(s.now())
+
+	if options.getMetadata == OnlyMetadata {
+		resp.ContentLength = resp.BlobSize
+		resp.ContentMD5 = b.ContentMD5
+		if b.BlobType == "" {
+			resp.AccessTier = localAccessTier(b)
+		}
+		if b.RehydrateTo != "" {
+			resp.ArchiveStatus = rehydrateStatus(AccessTier(b.RehydrateTo))
+			resp.RehydratePriority = RehydratePriority(b.RehydratePriority)
+		}
+		resp.CopyID = b.CopyID
+		resp.CopyStatus = CopyStatus(b.CopyStatus)
+		resp.CopyProgress = b.CopyProgress
+		resp.Metadata = b.metadata()
+		_ = readerResponseMetadata(resp, resp.Metadata) // the parse error is benign
+		return resp, nil
+	}
+	if b.BlobType == "" && localAccessTier(b) == AccessTierArchive {
+		return nil, newStorageError(
+			fmt.Sprintf("this operation is not permitted on an archived blob: %s", identity),
+			http.StatusConflict, errCodeBlobArchived)
+	}
+
+	notModified, err := checkConditions(b, options, true)
+	if err != nil {
+		return nil, err
+	}
+	if notModified {
+		resp.XMsErrorCode = errCodeConditionNotMet
+		resp.Status = "304 " + errCodeConditionNotMet
+		resp.StatusCode = http.StatusNotModified
+		resp.Reader = io.NopCloser(bytes.NewReader(nil))
+		return resp, nil
+	}
+
+	data := b.Data
+	resp.Status = "200 OK"
+	resp.StatusCode = http.StatusOK
+	if options.rangeOffset != 0 || options.rangeCount != 0 {
+		data, err = localRange(b.Data, options.rangeOffset, options.rangeCount)
+		if err != nil {
+			return nil, err
+		}
+		resp.Status = "206 Partial Content"
+		resp.StatusCode = http.StatusPartialContent
+		resp.ContentRange = fmt.Sprintf(
+			"bytes %d-%d/%d", options.rangeOffset, options.rangeOffset+int64(len(data))-1, len(b.Data))
+	}
+	resp.ContentLength = int64(len(data))
+	resp.Metadata = b.metadata()
+	if options.getMetadata == BothMetadataAndBlob {
+		_ = readerResponseMetadata(resp, resp.Metadata) // the parse error is benign
+	}
+	resp.Reader = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// localRange returns the requested range of data. As for azure, a count of zero
+// reads to the end and it is an error for the offset to be beyond the end.
+func localRange(data []byte, offset int64, count int64) ([]byte, error) {
+	size := int64(len(data))
+	if offset < 0 || count < 0 || offset >= size {
+		return nil, newStorageError(
+			"the range specified is invalid for the current size of the resource",
+			http.StatusRequestedRangeNotSatisfiable, errCodeInvalidRange)
+	}
+	end := size
+	if count > 0 && offset+count < size {
+		end = offset + count
+	}
+	return data[offset:end], nil
+}
+
+// put creates or replaces a blob, checking the lease and any If- conditions
+// against the current blob. The new blob is given a new ETag and keeps the
+// lease of the blob it replaces.
+func (s *localStore) put(b *localBlob, options *StorerOptions) (*WriteResponse, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.putLocked(b, options)
+}
+
+// putLocked is put for callers which hold the lock
+func (s *localStore) putLocked(b *localBlob, options *StorerOptions) (*WriteResponse, error) {
+
+	existing, err := s.backend.load(b.Name)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	now := s.now()
+	if err = checkLease(existing, options.leaseID, now, true); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(existing, options, false); err != nil {
+		return nil, err
+	}
+	if b.AccessTier != "" && !validAccessTier(AccessTier(b.AccessTier)) {
+		return nil, newStorageError(
+			fmt.Sprintf("invalid access tier %q", b.AccessTier),
+			http.StatusBadRequest, errCodeInvalidHeaderValue)
+	}
+
+	b.ETag = s.nextETag(now)
+	b.LastModified = now.UTC().Truncate(time.Second)
+	b.Metadata = maps.Clone(b.Metadata)
+	b.Tags = maps.Clone(b.Tags)
+	if existing != nil {
+		// overwriting a blob does not change its lease
+		b.localLease = existing.localLease
+	}
+	if err = s.storeBlob(existing, b, now); err != nil {
+		return nil, err
+	}
+	return localWriteResponse(b), nil
+}
+
+// Put creates or replaces a blob, honouring the same options as Storer.Put
+func (s *localStore) Put(
+	ctx context.Context,
+	identity string,
+	source io.ReadSeekCloser,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Create or replace local blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if pos, err := source.Seek(0, io.SeekCurrent); pos != 0 || err != nil {
+		return nil, fmt.Errorf("bad body for %s: %v", identity, ErrMustSupportSeek0)
+	}
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+	metadata := options.metadata
+	var digest *contentDigest
+	if hashAlgorithm := options.writeHashAlgorithm(); hashAlgorithm != "" {
+		d, err := seekerDigest(hashAlgorithm, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		digest = &d
+		metadata = d.metadata(options.metadata)
+	}
+	contentMD5 := md5.Sum(data)
+	wr, err := s.put(&localBlob{
+		Name:       identity,
+		Data:       data,
+		Metadata:   metadata,
+		Tags:       options.tags,
+		ContentMD5: contentMD5[:],
+		AccessTier: string(options.accessTier),
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	if digest != nil {
+		digest.setResponse(wr)
+	}
+	return wr, nil
+}
+
+// Write writes a blob from an io.Reader, honouring the same options as Storer.Write
+func (s *localStore) Write(
+	ctx context.Context,
+	identity string,
+	source io.Reader,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Write local blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return writeReader(ctx, s, identity, source, options)
+}
+
+// WriteStream writes a blob from a multipart http request, honouring the same
+// options as Storer.WriteStream
+func (s *localStore) WriteStream(
+	ctx context.Context,
+	identity string,
+	source *http.Request,
+	opts ...Option,
+) (*WriteResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return streamReader(ctx, s.log, s, identity, source, options)
+}
+
+// update applies change to a copy of an existing blob and stores the result
+func (s *localStore) update(identity string, change func(b *localBlob) error) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if existing == nil {
+		return blobNotFoundError(identity)
+	}
+	b := *existing
+	if err = change(&b); err != nil {
+		return err
+	}
+	if err = s.backend.store(&b); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+func (s *localStore) stageBlock(
+	ctx context.Context,
+	identity string,
+	blockID string,
+	data []byte,
+	leaseID string,
+) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	if err = checkLease(existing, leaseID, s.now(), true); err != nil {
+		return err
+	}
+	if err = s.backend.stageBlock(identity, blockID, data); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// commitBlockList replaces the blob with the listed uncommitted blocks. As for
+// azure, any other uncommitted blocks are discarded.
+func (s *localStore) commitBlockList(
+	ctx context.Context,
+	identity string,
+	blockIDs []string,
+	options *StorerOptions,
+) (*WriteResponse, error) {
+	s.log.Debugf("commitBlockList local blob %s: %d blocks", identity, len(blockIDs))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged, err := s.backend.stagedBlocks(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	var data []byte
+	for _, id := range blockIDs {
+		block, ok := staged[id]
+		if !ok {
+			return nil, newStorageError(
+				fmt.Sprintf("the specified block list is invalid: %s", identity),
+				http.StatusBadRequest, errCodeInvalidBlockList)
+		}
+		data = append(data, block...)
+	}
+
+	wr, err := s.putLocked(&localBlob{
+		Name:       identity,
+		Data:       data,
+		Metadata:   options.metadata,
+		Tags:       options.tags,
+		AccessTier: string(options.accessTier),
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.backend.discardBlocks(identity); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return wr, nil
+}
+
+// List returns a page of blobs, ordered by name, honouring the same options
+// as Storer.List
+func (s *localStore) List(ctx context.Context, opts ...Option) (*ListerResponse, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.backend.names()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	sort.Strings(names)
+
+	maxResults := int(options.listMaxResults)
+	if maxResults <= 0 {
+		maxResults = defaultListMaxResults
+	}
+
+	r := &ListerResponse{
+		Prefix:     options.listPrefix,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+	}
+	var lastPrefix string
+	for _, name := range names {
+		if !strings.HasPrefix(name, options.listPrefix) {
+			continue
+		}
+		// with a delimiter, the blobs below a virtual directory are listed as
+		// its prefix. Blobs with the same prefix are adjacent in name order.
+		prefix := ""
+		if options.listDelim != "" {
+			rest := name[len(options.listPrefix):]
+			if i := strings.Index(rest, options.listDelim); i >= 0 {
+				prefix = options.listPrefix + rest[:i+len(options.listDelim)]
+			}
+		}
+		if prefix != "" {
+			// the marker is the key of the first entry on the next page
+			if prefix == lastPrefix || (options.listMarker != nil && prefix < *options.listMarker) {
+				continue
+			}
+			if len(r.Items)+len(r.Prefixes) == maxResults {
+				r.Marker = &prefix
+				break
+			}
+			lastPrefix = prefix
+			r.Prefixes = append(r.Prefixes, &azStorageBlob.BlobPrefix{Name: &prefix})
+			continue
+		}
+		entries, err := s.listEntries(name, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if options.listMarker != nil && entry.key < *options.listMarker {
+				continue
+			}
+			if len(r.Items)+len(r.Prefixes) == maxResults {
+				next := entry.key
+				r.Marker = &next
+				return r, nil
+			}
+			r.Items = append(r.Items, entry.item)
+		}
+	}
+	return r, nil
+}
+
+// localListEntry is a blob, snapshot or prior version listed by List. The
+// entries are listed in key order.
+type localListEntry struct {
+	key  string
+	item *azStorageBlob.BlobItemInternal
+}
+
+// listEntries returns the entries listed for the named blob. The blob itself
+// is listed first, then any soft deleted blobs, snapshots and prior versions
+// requested by the options, oldest first. Azure lists the snapshots before
+// the blob.
+func (s *localStore) listEntries(name string, options *StorerOptions) ([]localListEntry, error) {
+
+	var entries []localListEntry
+	b, err := s.backend.load(name)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if b != nil {
+		item := localBlobItem(b, options)
+		if options.listIncludeVersions && b.VersionID != "" {
+			current := true
+			item.IsCurrentVersion = &current
+		}
+		entries = append(entries, localListEntry{key: name, item: item})
+	}
+	if !options.listIncludeSnapshots && !options.listIncludeVersions && !options.listIncludeDeleted {
+		return entries, nil
+	}
+
+	history, err := s.loadHistory(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].historyKey() < history[j].historyKey() })
+	for _, h := range history {
+		if h.Deleted != "" && !options.listIncludeDeleted {
+			continue
+		}
+		if (h.Snapshot != "" && !options.listIncludeSnapshots) || (h.VersionID != "" && !options.listIncludeVersions) {
+			continue
+		}
+		// blob names can't contain a NUL, so the history of a blob sorts
+		// after the blob and before any other blob
+		entries = append(entries, localListEntry{key: name + "\x00" + h.historyKey(), item: localBlobItem(h, options)})
+	}
+	return entries, nil
+}
+
+// FilteredList returns a page of the blobs, ordered by name, whose tags match
+// the filter. The filter is evaluated locally using the tagfilter package.
+func (s *localStore) FilteredList(ctx context.Context, tagsFilter string, opts ...Option) (*FilterResponse, error) {
+
+	expr, err := tagfilter.ParseFind(tagsFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.backend.names()
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	sort.Strings(names)
+
+	maxResults := int(options.listMaxResults)
+	if maxResults <= 0 {
+		maxResults = defaultListMaxResults
+	}
+
+	r := &FilterResponse{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+	}
+	for _, name := range names {
+		if options.listMarker != nil && name < *options.listMarker {
+			continue
+		}
+		b, err := s.backend.load(name)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+		if b == nil {
+			continue
+		}
+		tags := maps.Clone(b.Tags)
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags[tagfilter.ContainerKey] = s.container
+		if !expr.Match(tags) {
+			continue
+		}
+		if len(r.Items) == maxResults {
+			next := name
+			r.Marker = &next
+			break
+		}
+		blobName, container := b.Name, s.container
+		r.Items = append(r.Items, &azStorageBlob.FilterBlobItem{
+			ContainerName: &container,
+			Name:          &blobName,
+			Tags:          localBlobTags(b.Tags),
+		})
+	}
+	return r, nil
+}
+
+// Count counts the number of blobs filtered by the given tags filter
+func (s *localStore) Count(ctx context.Context, tagsFilter string, opts ...Option) (int64, error) {
+	return countFiltered(ctx, s, tagsFilter, opts...)
+}
+
+// AcquireLease gets a lease on a blob, creating an empty blob if necessary.
+// The leaseTimeout is in seconds, and must be -1 (infinite) or between 15 and 60.
+func (s *localStore) AcquireLease(
+	ctx context.Context, objectname string, leaseTimeout int32,
+) (string, error) {
+	s.log.Debugf("AcquireLease: %v", objectname)
+
+	if leaseTimeout != infiniteLeaseDuration &&
+		(leaseTimeout < minLeaseDuration || leaseTimeout > maxLeaseDuration) {
+		return "", newStorageError(
+			fmt.Sprintf("invalid lease duration %d", leaseTimeout),
+			http.StatusBadRequest, errCodeInvalidHeaderValue)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(objectname)
+	if err != nil {
+		return "", ErrorFromError(err)
+	}
+	now := s.now()
+	var b localBlob
+	if existing != nil {
+		if existing.leased(now) {
+			return "", newStorageError(
+				"there is already a lease present", http.StatusConflict, errCodeLeaseAlreadyPresent)
+		}
+		b = *existing
+	} else {
+		b = localBlob{
+			Name:         objectname,
+			Data:         []byte{},
+			ETag:         s.nextETag(now),
+			LastModified: now.UTC().Truncate(time.Second),
+		}
+	}
+
+	b.localLease = localLease{
+		LeaseID:       uuid.NewString(),
+		LeaseDuration: leaseTimeout,
+	}
+	if leaseTimeout != infiniteLeaseDuration {
+		b.LeaseExpires = now.Add(time.Duration(leaseTimeout) * time.Second)
+	}
+	if err = s.backend.store(&b); err != nil {
+		return "", ErrorFromError(err)
+	}
+	return b.LeaseID, nil
+}
+
+// ReleaseLeaseDeferable this is intended to use with defer - doesn't return error so we don't need to check it
+func (s *localStore) ReleaseLeaseDeferable(ctx context.Context, objectname string, leaseID string) {
+	s.log.Debugf("ReleaseLeaseDeferable: %v", objectname)
+	err := s.ReleaseLease(ctx, objectname, leaseID)
+	if err != nil {
+		s.log.Infof("did not release lease %s: %v", objectname, err)
+	}
+}
+
+// ReleaseLease release a lease on a blob
+func (s *localStore) ReleaseLease(ctx context.Context, objectname string, leaseID string) error {
+	s.log.Debugf("ReleaseLease: %v", objectname)
+
+	return s.update(objectname, func(b *localBlob) error {
+		if err := checkLeaseOperation(b, leaseID); err != nil {
+			return err
+		}
+		b.localLease = localLease{}
+		return nil
+	})
+}
+
+// RenewLease renews a lease on a blob, for the duration with which it was
+// acquired. As for azure, an expired lease can be renewed unless the blob has
+// since been leased again, but a broken lease can't.
+func (s *localStore) RenewLease(ctx context.Context, objectname string, leaseID string) error {
+	s.log.Debugf("RenewLease: %v", objectname)
+
+	now := s.now()
+	return s.update(objectname, func(b *localBlob) error {
+		if err := checkLeaseOperation(b, leaseID); err != nil {
+			return err
+		}
+		if b.LeaseBroken {
+			return newStorageError(
+				"the lease ID matched, but the lease has been broken explicitly and cannot be renewed",
+				http.StatusConflict, errCodeLeaseIsBrokenAndCannotBeRenewed)
+		}
+		if b.LeaseDuration != infiniteLeaseDuration {
+			b.LeaseExpires = now.Add(time.Duration(b.LeaseDuration) * time.Second)
+		}
+		return nil
+	})
+}
+
+// ChangeLease changes the id of the active lease on a blob to proposedID,
+// which must be a uuid, or a new uuid if it is empty. The new lease id is
+// returned.
+func (s *localStore) ChangeLease(
+	ctx context.Context, objectname string, leaseID string, proposedID string,
+) (string, error) {
+	s.log.Debugf("ChangeLease: %v", objectname)
+
+	if proposedID == "" {
+		proposedID = uuid.NewString()
+	}
+	if _, err := uuid.Parse(proposedID); err != nil {
+		return "", newStorageError(
+			fmt.Sprintf("invalid proposed lease id %q", proposedID),
+			http.StatusBadRequest, errCodeInvalidHeaderValue)
+	}
+
+	now := s.now()
+	err := s.update(objectname, func(b *localBlob) error {
+		if !b.leased(now) {
+			return newStorageError(
+				"there is currently no lease on the blob",
+				http.StatusConflict, errCodeLeaseNotPresentWithLeaseOperation)
+		}
+		// as for azure, repeating a change which succeeded is not an error
+		if b.LeaseID == proposedID {
+			return nil
+		}
+		if err := checkLeaseOperation(b, leaseID); err != nil {
+			return err
+		}
+		if b.LeaseBroken {
+			return newStorageError(
+				"the lease ID matched, but the lease is currently in breaking state and cannot be changed",
+				http.StatusConflict, errCodeLeaseIsBreakingAndCannotBeChanged)
+		}
+		b.LeaseID = proposedID
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return proposedID, nil
+}
+
+// BreakLease breaks the lease on a blob, without the lease id. The lease ends
+// after breakPeriod seconds, or at the end of a fixed lease if that is sooner.
+// A negative breakPeriod ends a fixed lease when it expires and an infinite
+// lease immediately. The time remaining, in seconds, is returned. Until then,
+// the lease can be released but not renewed or changed.
+func (s *localStore) BreakLease(ctx context.Context, objectname string, breakPeriod int32) (int32, error) {
+	s.log.Debugf("BreakLease: %v", objectname)
+
+	if breakPeriod > maxLeaseDuration {
+		return 0, newStorageError(
+			fmt.Sprintf("invalid lease break period %d", breakPeriod),
+			http.StatusBadRequest, errCodeInvalidHeaderValue)
+	}
+
+	now := s.now()
+	var remaining time.Duration
+	err := s.update(objectname, func(b *localBlob) error {
+		if b.LeaseID == "" {
+			return newStorageError(
+				"there is currently no lease on the blob",
+				http.StatusConflict, errCodeLeaseNotPresentWithLeaseOperation)
+		}
+		remaining = 0
+		if b.leased(now) {
+			if b.LeaseExpires.IsZero() {
+				remaining = time.Duration(max(breakPeriod, 0)) * time.Second
+			} else {
+				remaining = b.LeaseExpires.Sub(now)
+				if breakPeriod >= 0 {
+					remaining = min(remaining, time.Duration(breakPeriod)*time.Second)
+				}
+			}
+		}
+		b.LeaseBroken = true
+		b.LeaseExpires = now.Add(remaining)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int32((remaining + time.Second - 1) / time.Second), nil
+}
+
+// checkLeaseOperation checks that leaseID is the lease on the blob, for the
+// lease operations which require the lease id
+func checkLeaseOperation(b *localBlob, leaseID string) error {
+	if b.LeaseID == "" {
+		return newStorageError(
+			"there is currently no lease on the blob",
+			http.StatusConflict, errCodeLeaseNotPresentWithLeaseOperation)
+	}
+	if b.LeaseID != leaseID {
+		return newStorageError(
+			"the lease ID specified did not match the lease ID for the blob",
+			http.StatusConflict, errCodeLeaseIDMismatchWithLeaseOperation)
+	}
+	return nil
+}
+
+func localWriteResponse(b *localBlob) *WriteResponse {
+	etag := b.ETag
+	lastModified := b.LastModified
+	return &WriteResponse{
+		ETag:         &etag,
+		LastModified: &lastModified,
+		StatusCode:   http.StatusCreated,
+		Status:       "201 Created",
+	}
+}
+
+// localBlobItem returns the azure list item for the blob
+func localBlobItem(b *localBlob, options *StorerOptions) *azStorageBlob.BlobItemInternal {
+	name := b.Name
+	size := int64(len(b.Data))
+	lastModified := b.LastModified
+	item := &azStorageBlob.BlobItemInternal{
+		Name: &name,
+		Properties: &azStorageBlob.BlobPropertiesInternal{
+			ContentLength: &size,
+			LastModified:  &lastModified,
+		},
+	}
+	if options.listIncludeMetadata {
+		item.Metadata = map[string]*string{}
+		for k, v := range b.metadata() {
+			value := v
+			item.Metadata[k] = &value
+		}
+	}
+	if options.listIncludeTags {
+		item.BlobTags = localBlobTags(b.Tags)
+	}
+	if b.Snapshot != "" {
+		snapshot := b.Snapshot
+		item.Snapshot = &snapshot
+	}
+	if b.VersionID != "" {
+		versionID := b.VersionID
+		item.VersionID = &versionID
+	}
+	if b.Deleted != "" {
+		deleted := true
+		item.Deleted = &deleted
+		if deletedTime, err := time.Parse(historyIDFormat, b.Deleted); err == nil {
+			item.Properties.DeletedTime = &deletedTime
+		}
+	}
+	return item
+}
+
+func localBlobTags(tags map[string]string) *azStorageBlob.BlobTags {
+	blobTags := &azStorageBlob.BlobTags{}
+	for k, v := range tags {
+		key, value := k, v
+		blobTags.BlobTagSet = append(blobTags.BlobTagSet, &azStorageBlob.BlobTag{Key: &key, Value: &value})
+	}
+	return blobTags
+}
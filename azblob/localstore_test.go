@@ -0,0 +1,108 @@
+package azblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// localStores returns each of the local BlobStore implementations, freshly created
+func localStores(t *testing.T) map[string]*localStore {
+	fileStore, err := NewFileStore(logger.Sugar, t.TempDir(), "testcontainer")
+	require.NoError(t, err)
+	return map[string]*localStore{
+		"memory": NewMemoryStore(logger.Sugar, "testcontainer").localStore,
+		"file":   fileStore.localStore,
+	}
+}
+
+func readAll(t *testing.T, rr *ReaderResponse) string {
+	defer rr.Reader.Close()
+	data, err := io.ReadAll(rr.Reader)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestLocalStoreEtags(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			wr, err := store.Put(ctx, "a/blob", NewBytesReaderCloser([]byte("ORIGINAL_VALUE")))
+			require.NoError(t, err)
+
+			wr2, err := store.Put(ctx, "a/blob", NewBytesReaderCloser([]byte("SECOND_VALUE")), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+			assert.NotEqual(t, *wr.ETag, *wr2.ETag)
+
+			_, err = store.Put(ctx, "a/blob", NewBytesReaderCloser([]byte("THIRD_VALUE")), WithEtagMatch(*wr.ETag))
+			require.Error(t, err)
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+
+			rr, err := store.Reader(ctx, "a/blob", WithEtagMatch(*wr2.ETag))
+			require.NoError(t, err)
+			assert.Equal(t, "SECOND_VALUE", readAll(t, rr))
+
+			// reads with a matching If-None-Match are not errors
+			rr, err = store.Reader(ctx, "a/blob", WithEtagNoneMatch(*wr2.ETag))
+			require.NoError(t, err)
+			assert.True(t, rr.ConditionNotMet())
+			assert.False(t, rr.Ok())
+
+			_, err = store.Put(ctx, "a/blob", NewBytesReaderCloser([]byte("NEW")), WithEtagNoneMatch("*"))
+			require.Error(t, err)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+
+			_, err = store.Reader(ctx, "missing")
+			require.Error(t, err)
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+		})
+	}
+}
+
+func TestLocalStoreMetadataAndTags(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(
+				ctx, "blob", NewBytesReaderCloser([]byte("VALUE")),
+				WithMetadata(map[string]string{SizeKey: "5", HashKey: "abc"}),
+				WithTags(map[string]string{"owner": "tenant1"}),
+			)
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "blob", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), rr.Size)
+			assert.Equal(t, "abc", rr.HashValue)
+
+			_, err = store.Reader(ctx, "blob", WithTags(map[string]string{"owner": "tenant1"}))
+			require.NoError(t, err)
+
+			_, err = store.Reader(ctx, "blob", WithTags(map[string]string{"owner": "tenant2"}))
+			require.ErrorIs(t, err, ErrTagsMismatch)
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			_, err = store.Reader(ctx, "blob", WithWhereTagsFilter(tagfilter.Eq("owner", "tenant1")))
+			require.NoError(t, err)
+
+			_, err = store.Reader(ctx, "blob", WithWhereTags(`"owner" = 'tenant2'`))
+			require.Error(t, err)
+			assert.True(t, ErrorFromError(err).IsConditionNotMet())
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package azblob
+
+import (
+	"bytes"
+)
+
+// MemoryStore is a BlobStore which keeps all blobs in memory.
+//
+// It provides the same ETag, If-Modified-Since, lease, metadata and tag
+// semantics as Storer and is intended for unit tests which would otherwise
+// require azurite. It is safe for concurrent use.
+type MemoryStore struct {
+	*localStore
+}
+
+// NewMemoryStore returns an empty in memory BlobStore.
+//
+// The container is reported in list results, it does not otherwise affect
+// the behaviour of the store.
+func NewMemoryStore(log Logger, container string) *MemoryStore {
+	return &MemoryStore{
+		localStore: newLocalStore(log, container, &memoryBackend{
+			blobs:   map[string]*localBlob{},
+			blocks:  map[string]map[string][]byte{},
+			history: map[string]map[string]*localBlob{},
+		}),
+	}
+}
+
+// memoryBackend is a localBackend which keeps the blobs in a map
+type memoryBackend struct {
+	blobs map[string]*localBlob
+	// blocks are the uncommitted blocks for each blob, by block id
+	blocks map[string]map[string][]byte
+	// history are the snapshots and prior versions of each blob, by history key
+	history map[string]map[string]*localBlob
+}
+
+func (m *memoryBackend) load(identity string) (*localBlob, error) {
+	b, ok := m.blobs[identity]
+	if !ok {
+		return nil, nil
+	}
+	return b, nil
+}
+
+func (m *memoryBackend) store(blob *localBlob) error {
+	m.blobs[blob.Name] = blob
+	return nil
+}
+
+func (m *memoryBackend) remove(identity string) error {
+	delete(m.blobs, identity)
+	return nil
+}
+
+func (m *memoryBackend) names() ([]string, error) {
+	names := make([]string, 0, len(m.blobs))
+	for name := range m.blobs {
+		names = append(names, name)
+	}
+	for name := range m.history {
+		if _, ok := m.blobs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (m *memoryBackend) stageBlock(identity string, blockID string, data []byte) error {
+	blocks, ok := m.blocks[identity]
+	if !ok {
+		blocks = map[string][]byte{}
+		m.blocks[identity] = blocks
+	}
+	// the caller may reuse data for the next block
+	blocks[blockID] = bytes.Clone(data)
+	return nil
+}
+
+func (m *memoryBackend) stagedBlocks(identity string) (map[string][]byte, error) {
+	return m.blocks[identity], nil
+}
+
+func (m *memoryBackend) discardBlocks(identity string) error {
+	delete(m.blocks, identity)
+	return nil
+}
+
+func (m *memoryBackend) storeHistory(blob *localBlob) error {
+	history, ok := m.history[blob.Name]
+	if !ok {
+		history = map[string]*localBlob{}
+		m.history[blob.Name] = history
+	}
+	history[blob.historyKey()] = blob
+	return nil
+}
+
+func (m *memoryBackend) loadHistory(identity string) ([]*localBlob, error) {
+	history := make([]*localBlob, 0, len(m.history[identity]))
+	for _, b := range m.history[identity] {
+		history = append(history, b)
+	}
+	return history, nil
+}
+
+func (m *memoryBackend) removeHistory(blob *localBlob) error {
+	history := m.history[blob.Name]
+	delete(history, blob.historyKey())
+	if len(history) == 0 {
+		delete(m.history, blob.Name)
+	}
+	return nil
+}
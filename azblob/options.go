@@ -1,6 +1,13 @@
 package azblob
 
-import "time"
+import (
+	"net"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	"github.com/datatrails/go-datatrails-common/azblob/tagfilter"
+)
 
 type GetMetadata int
 
@@ -19,6 +26,17 @@ const (
 	TagsWhere
 )
 
+type DeleteSnapshotsOption int
+
+const (
+	// DeleteSnapshotsNotUsed fails the delete of a blob which has snapshots
+	DeleteSnapshotsNotUsed DeleteSnapshotsOption = iota
+	// DeleteSnapshotsInclude deletes the blob and all of its snapshots
+	DeleteSnapshotsInclude
+	// DeleteSnapshotsOnly deletes all of the snapshots, but not the blob
+	DeleteSnapshotsOnly
+)
+
 type IfSinceCondition int
 
 const (
@@ -39,18 +57,51 @@ type StorerOptions struct {
 	getMetadata    GetMetadata
 	getTags        bool
 	sizeLimit      int64
+	hashAlgorithm  HashAlgorithm
+	withoutHash    bool
 	etag           string
 	etagCondition  ETagCondition // ETagMatch || ETagNoneMatch
 	sinceCondition IfSinceCondition
 	since          *time.Time
+	// Options for Delete()
+	deleteSnapshots DeleteSnapshotsOption
+	// Options for Copy()
+	sourceETag string
+	// Options for CreateContainer()
+	publicAccess PublicAccess
+	// Options for GenerateBlobSAS() and GenerateContainerSAS()
+	sasPermissions string
+	sasExpiry      time.Time
+	sasIPRange     azStorageBlob.IPRange
+	sasProtocol    SASProtocol
+	// Options for Put(), Write() and SetTier()
+	accessTier        AccessTier
+	rehydratePriority RehydratePriority
+	// Options for Reader()
+	snapshot           string
+	versionID          string
+	rangeOffset        int64
+	rangeCount         int64
+	downloadRetries    int
+	downloadRetriesSet bool
+	// Options for DownloadParallel()
+	downloadChunkSize   int64
+	downloadConcurrency int
+	// Options for Append()
+	appendPosition *int64
+	appendMaxSize  *int64
 	// Options for List()
 	listPrefix     string
 	listDelim      string
 	listMarker     ListMarker
 	listMaxResults int32
+	listContinue   func(next ListMarker)
 	// extra data model items to include in the respponse
-	listIncludeTags     bool
-	listIncludeMetadata bool
+	listIncludeTags      bool
+	listIncludeMetadata  bool
+	listIncludeSnapshots bool
+	listIncludeVersions  bool
+	listIncludeDeleted   bool
 	// There are more, but these are all we need for now
 }
 type ListMarker *string
@@ -70,7 +121,20 @@ func WithListMaxResults(maxResults int32) Option {
 	}
 }
 
-// TODO: this is an sdk v1.2.1 feature
+// WithListContinuation sets a function which ListAll and FilteredListAll call
+// once all the items of a page have been yielded. It is called with the marker
+// for the next page, which is nil after the last page. To resume an iteration
+// which was interrupted, pass the most recent marker to WithListMarker.
+func WithListContinuation(next func(next ListMarker)) Option {
+	return func(a *StorerOptions) {
+		a.listContinue = next
+	}
+}
+
+// WithListDelim lists hierarchically. Blobs whose names contain the delimiter
+// after the list prefix are not listed. Instead, each distinct name up to and
+// including the first such delimiter is returned, once, in the Prefixes of the
+// ListerResponse. These are the virtual directories below the list prefix.
 func WithListDelim(delim string) Option {
 	return func(a *StorerOptions) {
 		a.listDelim = delim
@@ -95,6 +159,37 @@ func WithListMetadata() Option {
 	}
 }
 
+// WithListSnapshots includes the snapshots of each blob in the listing
+func WithListSnapshots() Option {
+	return func(a *StorerOptions) {
+		a.listIncludeSnapshots = true
+	}
+}
+
+// WithListVersions includes the prior versions of each blob in the listing.
+// The current version of each blob has IsCurrentVersion set.
+func WithListVersions() Option {
+	return func(a *StorerOptions) {
+		a.listIncludeVersions = true
+	}
+}
+
+// WithListDeleted includes the soft deleted blobs in the listing. Combined
+// with WithListSnapshots, the soft deleted snapshots are also listed.
+func WithListDeleted() Option {
+	return func(a *StorerOptions) {
+		a.listIncludeDeleted = true
+	}
+}
+
+// WithDeleteSnapshots determines whether Delete deletes the snapshots of the
+// blob - Delete() only
+func WithDeleteSnapshots(deleteSnapshots DeleteSnapshotsOption) Option {
+	return func(a *StorerOptions) {
+		a.deleteSnapshots = deleteSnapshots
+	}
+}
+
 func WithModifiedSince(since *time.Time) Option {
 	return func(a *StorerOptions) {
 		if since == nil {
@@ -147,6 +242,11 @@ func WithWhereTags(whereTags string) Option {
 	}
 }
 
+// WithWhereTagsFilter is WithWhereTags for an expression built using the tagfilter package
+func WithWhereTagsFilter(expr tagfilter.Expr) Option {
+	return WithWhereTags(expr.String())
+}
+
 // Specifying an option that is no used is silently ignored. i.e. Specifying
 // WithMetadata() in a call to Reader() will not raise an error.
 
@@ -157,7 +257,7 @@ func WithLeaseID(leaseID string) Option {
 	}
 }
 
-// WithMetadata specifies metadata to add - Write() only
+// WithMetadata specifies metadata to add - Write(), Copy() and CreateContainer() only
 func WithMetadata(metadata map[string]string) Option {
 	return func(a *StorerOptions) {
 		a.metadata = metadata
@@ -172,7 +272,8 @@ func WithGetMetadata(value GetMetadata) Option {
 }
 
 // WithTags specifies tags to add - Reader() and Write(). For Write) the tags are written
-// with the blob. For Reader() the tags are used to apply ownership permissions.
+// with the blob. For Reader() the tags are used to apply ownership permissions,
+// the blob is only read if it has all of the tags (see ErrTagsMismatch).
 func WithTags(tags map[string]string) Option {
 	return func(a *StorerOptions) {
 		a.tags = tags
@@ -185,6 +286,177 @@ func WithGetTags() Option {
 	}
 }
 
+// WithRange reads count bytes starting at offset - Reader() only. A count of
+// zero reads to the end of the blob.
+func WithRange(offset int64, count int64) Option {
+	return func(a *StorerOptions) {
+		a.rangeOffset = offset
+		a.rangeCount = count
+	}
+}
+
+// WithSnapshot reads the snapshot, identified by the snapshot time returned
+// by Snapshot, rather than the blob - Reader() only
+func WithSnapshot(snapshot string) Option {
+	return func(a *StorerOptions) {
+		a.snapshot = snapshot
+	}
+}
+
+// WithVersionID reads the identified version of the blob - Reader() only
+func WithVersionID(versionID string) Option {
+	return func(a *StorerOptions) {
+		a.versionID = versionID
+	}
+}
+
+// WithDownloadRetries sets the number of times a download is resumed, from the
+// last received offset, after a transient failure reading the response body -
+// Reader() only. The resumed download is conditional on the ETag of the
+// original response, so a blob that changes in the meantime is never spliced.
+// Zero disables resumption. The default is 3.
+func WithDownloadRetries(retries int) Option {
+	return func(a *StorerOptions) {
+		a.downloadRetries = retries
+		a.downloadRetriesSet = true
+	}
+}
+
+// WithDownloadChunkSize sets the size of each ranged read made by
+// DownloadParallel. The default is 4MiB.
+func WithDownloadChunkSize(size int64) Option {
+	return func(a *StorerOptions) {
+		a.downloadChunkSize = size
+	}
+}
+
+// WithDownloadConcurrency sets the number of ranged reads DownloadParallel
+// makes at once. The default is 5.
+func WithDownloadConcurrency(concurrency int) Option {
+	return func(a *StorerOptions) {
+		a.downloadConcurrency = concurrency
+	}
+}
+
+// WithHash selects the algorithm of the hash which Write(), Put() and
+// WriteStream() compute as the content is written, the default is
+// DefaultHashAlgorithm. The hash and size of the content are stored in the
+// HashKey, HashAlgKey, SizeKey and TimeKey metadata, and are also returned in
+// the WriteResponse.
+//
+// For Put() the source is read twice, once to compute the hash and once to
+// upload it, so that the metadata is set in the same operation as the content.
+func WithHash(algorithm HashAlgorithm) Option {
+	return func(a *StorerOptions) {
+		a.hashAlgorithm = algorithm
+	}
+}
+
+// WithoutHash stops Write() and Put() computing the hash of the content, so
+// that the blob has no hash or size metadata. WriteStream() always computes
+// the hash.
+func WithoutHash() Option {
+	return func(a *StorerOptions) {
+		a.withoutHash = true
+	}
+}
+
+// writeHashAlgorithm returns the algorithm of the hash computed by Write() and
+// Put(), or "" if they compute none
+func (o *StorerOptions) writeHashAlgorithm() HashAlgorithm {
+	switch {
+	case o.withoutHash:
+		return ""
+	case o.hashAlgorithm != "":
+		return o.hashAlgorithm
+	default:
+		return DefaultHashAlgorithm
+	}
+}
+
+// WithSourceEtagMatch copies only if the ETag of the source matches etag -
+// Copy() only. The error StorageErrorCode is SourceConditionNotMet if it does
+// not.
+func WithSourceEtagMatch(etag string) Option {
+	return func(a *StorerOptions) {
+		a.sourceETag = etag
+	}
+}
+
+// WithPublicAccess allows anonymous reads of the blobs in the container -
+// CreateContainer() only.
+func WithPublicAccess(access PublicAccess) Option {
+	return func(a *StorerOptions) {
+		a.publicAccess = access
+	}
+}
+
+// WithAccessTier sets the access tier of the blob as it is written - Write(),
+// Put() and WriteStream() only. The default is the account default, usually
+// AccessTierHot.
+func WithAccessTier(tier AccessTier) Option {
+	return func(a *StorerOptions) {
+		a.accessTier = tier
+	}
+}
+
+// WithRehydratePriority sets the priority with which an archived blob is
+// rehydrated - SetTier() only. The default is RehydratePriorityStandard.
+func WithRehydratePriority(priority RehydratePriority) Option {
+	return func(a *StorerOptions) {
+		a.rehydratePriority = priority
+	}
+}
+
+// WithSASPermissions sets the permissions granted by a SAS, in the format of
+// BlobSASPermissions or ContainerSASPermissions, eg "rl" for read and list.
+func WithSASPermissions(permissions string) Option {
+	return func(a *StorerOptions) {
+		a.sasPermissions = permissions
+	}
+}
+
+// WithSASExpiry sets the time at which a SAS expires
+func WithSASExpiry(expiry time.Time) Option {
+	return func(a *StorerOptions) {
+		a.sasExpiry = expiry
+	}
+}
+
+// WithSASIPRange restricts a SAS to requests from the addresses start to end,
+// inclusive. end may be nil for a single address.
+func WithSASIPRange(start net.IP, end net.IP) Option {
+	return func(a *StorerOptions) {
+		a.sasIPRange = azStorageBlob.IPRange{Start: start, End: end}
+	}
+}
+
+// WithSASProtocol restricts the protocol of requests made with a SAS, by
+// default both http and https are permitted
+func WithSASProtocol(protocol SASProtocol) Option {
+	return func(a *StorerOptions) {
+		a.sasProtocol = protocol
+	}
+}
+
+// WithAppendPosition appends only if the blob is exactly offset bytes long -
+// Append() only. The error StorageErrorCode is AppendPositionConditionNotMet
+// if it is not.
+func WithAppendPosition(offset int64) Option {
+	return func(a *StorerOptions) {
+		a.appendPosition = &offset
+	}
+}
+
+// WithAppendMaxSize appends only if the blob will be no longer than maxSize
+// bytes - Append() only. The error StorageErrorCode is
+// MaxBlobSizeConditionNotMet if it would be.
+func WithAppendMaxSize(maxSize int64) Option {
+	return func(a *StorerOptions) {
+		a.appendMaxSize = &maxSize
+	}
+}
+
 // WithSizeLimit specifies the size limit of the blob.
 // -1 for unlimited. 0+ for limited.
 func WithSizeLimit(sizeLimit int64) Option {
@@ -0,0 +1,545 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// PageSize is the size of a page blob page. Page blob sizes, and the
+	// offsets and sizes of page writes, must be multiples of PageSize.
+	PageSize = 512
+
+	// blobTypePage is the localBlob.BlobType of page blobs
+	blobTypePage = "PageBlob"
+)
+
+var (
+	ErrPageAlignment = fmt.Errorf("page blob offsets and sizes must be multiples of %d", PageSize)
+)
+
+// PageRange is a range of pages in a page blob. Offset and Count are always
+// multiples of PageSize.
+type PageRange struct {
+	Offset int64
+	Count  int64
+}
+
+// PageRangesDiff is the difference between a page blob and a previous snapshot
+type PageRangesDiff struct {
+	// Updated are the pages written since the snapshot
+	Updated []PageRange
+	// Cleared are the pages cleared since the snapshot
+	Cleared []PageRange
+}
+
+func checkPageAlignment(values ...int64) error {
+	for _, v := range values {
+		if v < 0 || v%PageSize != 0 {
+			return fmt.Errorf("%w: %d", ErrPageAlignment, v)
+		}
+	}
+	return nil
+}
+
+// CreatePageBlob creates a page blob of size bytes, with all pages clear, or
+// replaces an existing blob of any type.
+//
+// Use WithEtagNoneMatch("*") to create the blob only if it does not exist.
+// The metadata and tags are set by the same operation. Page blobs are read
+// using Reader, WithRange reads the pages in a range.
+func (azp *Storer) CreatePageBlob(
+	ctx context.Context,
+	identity string,
+	size int64,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("CreatePageBlob %s: %d bytes", identity, size)
+
+	if err := checkPageAlignment(size); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	pageBlobClient, err := azp.containerClient.NewPageBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := pageBlobClient.Create(
+		ctx,
+		size,
+		&azStorageBlob.PageBlobCreateOptions{
+			BlobAccessConditions: &blobAccessConditions,
+			Metadata:             options.metadata,
+			BlobTagsMap:          options.tags,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return pageWriteResponse(r.RawResponse, r.ETag, r.LastModified), nil
+}
+
+// WritePages writes data to the pages starting at offset, in place. The offset
+// and the length of data must be multiples of PageSize and at most 4MiB may be
+// written at once.
+//
+// The ETag, since and lease options make the write conditional.
+func (azp *Storer) WritePages(
+	ctx context.Context,
+	identity string,
+	offset int64,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("WritePages %s: %d bytes at %d", identity, len(data), offset)
+
+	if err := checkPageAlignment(offset, int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	pageBlobClient, err := azp.containerClient.NewPageBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := pageBlobClient.UploadPages(
+		ctx,
+		NewBytesReaderCloser(data),
+		&azStorageBlob.PageBlobUploadPagesOptions{
+			PageRange:            &azStorageBlob.HttpRange{Offset: offset, Count: int64(len(data))},
+			BlobAccessConditions: &blobAccessConditions,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return pageWriteResponse(r.RawResponse, r.ETag, r.LastModified), nil
+}
+
+// ClearPages clears count bytes of pages starting at offset. Cleared pages
+// read as zeros and are not reported by GetPageRanges.
+//
+// The ETag, since and lease options make the clear conditional.
+func (azp *Storer) ClearPages(
+	ctx context.Context,
+	identity string,
+	offset int64,
+	count int64,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("ClearPages %s: %d bytes at %d", identity, count, offset)
+
+	if err := checkPageAlignment(offset, count); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	pageBlobClient, err := azp.containerClient.NewPageBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := pageBlobClient.ClearPages(
+		ctx,
+		azStorageBlob.HttpRange{Offset: offset, Count: count},
+		&azStorageBlob.PageBlobClearPagesOptions{
+			BlobAccessConditions: &blobAccessConditions,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return pageWriteResponse(r.RawResponse, r.ETag, r.LastModified), nil
+}
+
+// GetPageRanges returns the ranges of the page blob which have been written,
+// and not since cleared, in offset order.
+func (azp *Storer) GetPageRanges(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) ([]PageRange, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	pageBlobClient, err := azp.containerClient.NewPageBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	pager := pageBlobClient.GetPageRanges(&azStorageBlob.PageBlobGetPageRangesOptions{
+		BlobAccessConditions: &blobAccessConditions,
+	})
+	var ranges []PageRange
+	for pager.NextPage(ctx) {
+		ranges = appendPageRanges(ranges, pager.PageResponse().PageRange)
+	}
+	if err = pager.Err(); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return ranges, nil
+}
+
+// GetPageRangesDiff returns the pages of the page blob which have been written
+// or cleared since the snapshot prevSnapshot was taken.
+func (azp *Storer) GetPageRangesDiff(
+	ctx context.Context,
+	identity string,
+	prevSnapshot string,
+	opts ...Option,
+) (*PageRangesDiff, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	pageBlobClient, err := azp.containerClient.NewPageBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	pager := pageBlobClient.GetPageRangesDiff(&azStorageBlob.PageBlobGetPageRangesDiffOptions{
+		PrevSnapshot:         &prevSnapshot,
+		BlobAccessConditions: &blobAccessConditions,
+	})
+	diff := &PageRangesDiff{}
+	for pager.NextPage(ctx) {
+		page := pager.PageResponse()
+		diff.Updated = appendPageRanges(diff.Updated, page.PageRange)
+		for _, r := range page.ClearRange {
+			if r.Start == nil || r.End == nil {
+				continue
+			}
+			diff.Cleared = append(diff.Cleared, PageRange{Offset: *r.Start, Count: *r.End - *r.Start + 1})
+		}
+	}
+	if err = pager.Err(); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	return diff, nil
+}
+
+// appendPageRanges converts the inclusive start and end of the azure page
+// ranges to offset and count.
+func appendPageRanges(ranges []PageRange, pageRanges []*azStorageBlob.PageRange) []PageRange {
+	for _, r := range pageRanges {
+		if r.Start == nil || r.End == nil {
+			continue
+		}
+		ranges = append(ranges, PageRange{Offset: *r.Start, Count: *r.End - *r.Start + 1})
+	}
+	return ranges
+}
+
+func pageWriteResponse(raw *http.Response, etag *string, lastModified *time.Time) *WriteResponse {
+	w := WriteResponse{
+		ETag:         etag,
+		LastModified: lastModified,
+	}
+	if raw == nil {
+		return &w
+	}
+	w.Status = raw.Status
+	w.StatusCode = raw.StatusCode
+	value, ok := raw.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w
+}
+
+// CreatePageBlob creates a page blob, see Storer.CreatePageBlob
+func (s *localStore) CreatePageBlob(
+	ctx context.Context,
+	identity string,
+	size int64,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("CreatePageBlob local blob %s: %d bytes", identity, size)
+
+	if err := checkPageAlignment(size); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return s.put(&localBlob{
+		Name:     identity,
+		Data:     make([]byte, size),
+		Metadata: options.metadata,
+		Tags:     options.tags,
+		BlobType: blobTypePage,
+	}, options)
+}
+
+// updatePages applies change to a copy of an existing page blob, after
+// checking the page range, the lease and the conditions.
+func (s *localStore) updatePages(
+	identity string,
+	offset int64,
+	count int64,
+	options *StorerOptions,
+	change func(b *localBlob),
+) (*WriteResponse, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if existing == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	if existing.BlobType != blobTypePage {
+		return nil, newStorageError(
+			fmt.Sprintf("the blob type is invalid for this operation: %s", identity),
+			http.StatusConflict, errCodeInvalidBlobType)
+	}
+	if offset+count > int64(len(existing.Data)) {
+		return nil, newStorageError(
+			"the page range specified is invalid",
+			http.StatusRequestedRangeNotSatisfiable, errCodeInvalidPageRange)
+	}
+	now := s.now()
+	if err = checkLease(existing, options.leaseID, now, true); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(existing, options, false); err != nil {
+		return nil, err
+	}
+
+	b := *existing
+	b.Data = append([]byte(nil), existing.Data...)
+	change(&b)
+	b.ETag = s.nextETag(now)
+	b.LastModified = now.UTC().Truncate(time.Second)
+	if err = s.storeBlob(existing, &b, now); err != nil {
+		return nil, err
+	}
+	return localWriteResponse(&b), nil
+}
+
+// WritePages writes pages in place, see Storer.WritePages
+func (s *localStore) WritePages(
+	ctx context.Context,
+	identity string,
+	offset int64,
+	data []byte,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("WritePages local blob %s: %d bytes at %d", identity, len(data), offset)
+
+	if err := checkPageAlignment(offset, int64(len(data))); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	count := int64(len(data))
+	return s.updatePages(identity, offset, count, options, func(b *localBlob) {
+		copy(b.Data[offset:], data)
+		b.Pages = setPages(b.Pages, offset, count, true)
+	})
+}
+
+// ClearPages clears pages, see Storer.ClearPages
+func (s *localStore) ClearPages(
+	ctx context.Context,
+	identity string,
+	offset int64,
+	count int64,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("ClearPages local blob %s: %d bytes at %d", identity, count, offset)
+
+	if err := checkPageAlignment(offset, count); err != nil {
+		return nil, err
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return s.updatePages(identity, offset, count, options, func(b *localBlob) {
+		clear(b.Data[offset : offset+count])
+		b.Pages = setPages(b.Pages, offset, count, false)
+	})
+}
+
+// GetPageRanges returns the written pages, see Storer.GetPageRanges
+func (s *localStore) GetPageRanges(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) ([]PageRange, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if b == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	if err = checkLease(b, options.leaseID, s.now(), false); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(b, options, false); err != nil {
+		return nil, err
+	}
+	return append([]PageRange(nil), b.Pages...), nil
+}
+
+// GetPageRangesDiff returns the pages changed since a snapshot, see
+// Storer.GetPageRangesDiff. The local stores compare the page content, so
+// pages which were rewritten with the same content are not reported.
+func (s *localStore) GetPageRangesDiff(
+	ctx context.Context,
+	identity string,
+	prevSnapshot string,
+	opts ...Option,
+) (*PageRangesDiff, error) {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if b == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	if err = checkLease(b, options.leaseID, s.now(), false); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(b, options, false); err != nil {
+		return nil, err
+	}
+	prev, err := s.loadSelected(identity, &StorerOptions{snapshot: prevSnapshot})
+	if err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		return nil, newStorageError(
+			fmt.Sprintf("the previous snapshot is not found: %s", prevSnapshot),
+			http.StatusConflict, errCodePreviousSnapshotNotFound)
+	}
+
+	diff := &PageRangesDiff{}
+	size := int64(max(len(b.Data), len(prev.Data)))
+	for offset := int64(0); offset < size; offset += PageSize {
+		written := pageWritten(b.Pages, offset)
+		wasWritten := pageWritten(prev.Pages, offset)
+		switch {
+		case written && (!wasWritten || !bytes.Equal(b.Data[offset:offset+PageSize], prev.Data[offset:offset+PageSize])):
+			diff.Updated = setPages(diff.Updated, offset, PageSize, true)
+		case !written && wasWritten:
+			diff.Cleared = setPages(diff.Cleared, offset, PageSize, true)
+		}
+	}
+	return diff, nil
+}
+
+// pageWritten returns true if the page at offset is in the written ranges
+func pageWritten(pages []PageRange, offset int64) bool {
+	for _, p := range pages {
+		if offset >= p.Offset && offset < p.Offset+p.Count {
+			return true
+		}
+	}
+	return false
+}
+
+// setPages marks the pages in the range as written, or as clear, and returns
+// the resulting ranges sorted and merged.
+func setPages(pages []PageRange, offset int64, count int64, written bool) []PageRange {
+	end := offset + count
+	result := make([]PageRange, 0, len(pages)+1)
+	for _, p := range pages {
+		pend := p.Offset + p.Count
+		// keep the parts of p outside [offset, end)
+		if p.Offset < offset {
+			result = append(result, PageRange{Offset: p.Offset, Count: min(pend, offset) - p.Offset})
+		}
+		if pend > end {
+			start := max(p.Offset, end)
+			result = append(result, PageRange{Offset: start, Count: pend - start})
+		}
+	}
+	if written && count > 0 {
+		result = append(result, PageRange{Offset: offset, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Offset < result[j].Offset })
+
+	merged := result[:0]
+	for _, p := range result {
+		if n := len(merged); n > 0 && merged[n-1].Offset+merged[n-1].Count == p.Offset {
+			merged[n-1].Count += p.Count
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged
+}
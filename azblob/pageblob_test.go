@@ -0,0 +1,85 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStorePageBlob(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	page := func(c byte) []byte { return bytes.Repeat([]byte{c}, PageSize) }
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.CreatePageBlob(ctx, "index", 100)
+			require.ErrorIs(t, err, ErrPageAlignment)
+
+			wr, err := store.CreatePageBlob(ctx, "index", 4*PageSize, WithEtagNoneMatch("*"))
+			require.NoError(t, err)
+
+			_, err = store.WritePages(ctx, "index", PageSize, append(page('a'), page('b')...), WithEtagMatch(*wr.ETag))
+			require.NoError(t, err)
+			_, err = store.WritePages(ctx, "index", 3*PageSize, page('d'))
+			require.NoError(t, err)
+			_, err = store.WritePages(ctx, "index", 4*PageSize, page('e'))
+			require.Error(t, err)
+			assert.Equal(t, errCodeInvalidPageRange, ErrorFromError(err).StorageErrorCode())
+
+			ranges, err := store.GetPageRanges(ctx, "index")
+			require.NoError(t, err)
+			assert.Equal(t, []PageRange{{Offset: PageSize, Count: 3 * PageSize}}, ranges)
+
+			_, err = store.ClearPages(ctx, "index", 2*PageSize, PageSize)
+			require.NoError(t, err)
+			ranges, err = store.GetPageRanges(ctx, "index")
+			require.NoError(t, err)
+			assert.Equal(t, []PageRange{{Offset: PageSize, Count: PageSize}, {Offset: 3 * PageSize, Count: PageSize}}, ranges)
+
+			rr, err := store.Reader(ctx, "index", WithRange(PageSize, 3*PageSize))
+			require.NoError(t, err)
+			assert.Equal(t, string(page('a'))+string(page(0))+string(page('d')), readAll(t, rr))
+		})
+	}
+}
+
+func TestLocalStorePageRangesDiff(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.CreatePageBlob(ctx, "pages", 4*PageSize)
+			require.NoError(t, err)
+			_, err = store.WritePages(ctx, "pages", 0, bytes.Repeat([]byte{1}, 2*PageSize))
+			require.NoError(t, err)
+			wr, err := store.Snapshot(ctx, "pages")
+			require.NoError(t, err)
+
+			_, err = store.ClearPages(ctx, "pages", 0, PageSize)
+			require.NoError(t, err)
+			_, err = store.WritePages(ctx, "pages", 2*PageSize, bytes.Repeat([]byte{2}, PageSize))
+			require.NoError(t, err)
+
+			diff, err := store.GetPageRangesDiff(ctx, "pages", *wr.Snapshot)
+			require.NoError(t, err)
+			assert.Equal(t, []PageRange{{Offset: 2 * PageSize, Count: PageSize}}, diff.Updated)
+			assert.Equal(t, []PageRange{{Offset: 0, Count: PageSize}}, diff.Cleared)
+
+			_, err = store.GetPageRangesDiff(ctx, "pages", "2000-01-01T00:00:00.0000000Z")
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+		})
+	}
+}
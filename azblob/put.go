@@ -24,11 +24,24 @@ func (azp *Storer) Put(
 		opt(options)
 	}
 
+	var digest *contentDigest
+	if hashAlgorithm := options.writeHashAlgorithm(); hashAlgorithm != "" {
+		d, err := seekerDigest(hashAlgorithm, source)
+		if err != nil {
+			return nil, err
+		}
+		digest = &d
+		options.metadata = d.metadata(options.metadata)
+	}
+
 	wr, err := azp.putBlob(
 		ctx, identity, source, options)
 	if err != nil {
 		return nil, err
 	}
+	if digest != nil {
+		digest.setResponse(wr)
+	}
 	return wr, nil
 }
 
@@ -66,6 +79,7 @@ func (azp *Storer) putBlob(
 			BlobAccessConditions: &blobAccessConditions,
 			Metadata:             options.metadata,
 			TagsMap:              options.tags,
+			Tier:                 accessTierOption(options),
 		},
 	)
 	if err != nil {
@@ -60,12 +60,13 @@ func NewReaderNoAuth(log Logger, url string, opts ...ReaderOption) (Reader, erro
 		credential:           nil,
 		rootURL:              url,
 		startSpanFromContext: readerOptions.startSpanFromContext,
+		retryOptions:         readerOptions.retryOptions,
 		log:                  log,
 	}
 
 	azp.serviceClient, err = azStorageBlob.NewServiceClientWithNoCredential(
 		url,
-		nil,
+		azp.azureClientOptions(),
 	)
 	if err != nil {
 		return nil, err
@@ -95,6 +96,9 @@ func NewReaderNoAuth(log Logger, url string, opts ...ReaderOption) (Reader, erro
 
 // NewReaderDefaultAuth is a azure blob reader client that obtains credentials from the
 // environment - including aad pod identity / workload identity.
+//
+// The reader is a *Storer, which can sign user delegation SAS, see
+// Storer.GenerateBlobSAS.
 func NewReaderDefaultAuth(log Logger, url string, opts ...ReaderOption) (Reader, error) {
 	var err error
 	if url == "" {
@@ -111,6 +115,7 @@ func NewReaderDefaultAuth(log Logger, url string, opts ...ReaderOption) (Reader,
 		credential:           nil,
 		rootURL:              url,
 		startSpanFromContext: readerOptions.startSpanFromContext,
+		retryOptions:         readerOptions.retryOptions,
 		log:                  log,
 	}
 
@@ -118,11 +123,12 @@ func NewReaderDefaultAuth(log Logger, url string, opts ...ReaderOption) (Reader,
 	if err != nil {
 		return nil, err
 	}
+	azp.tokenCredential = credentials
 
 	azp.serviceClient, err = azStorageBlob.NewServiceClient(
 		url,
 		credentials,
-		nil,
+		azp.azureClientOptions(),
 	)
 	if err != nil {
 		return nil, err
@@ -9,6 +9,8 @@ type ReaderOptions struct {
 	container string
 
 	startSpanFromContext startSpanFromContextFunc
+
+	retryOptions *RetryOptions
 }
 
 type ReaderOption func(*ReaderOptions)
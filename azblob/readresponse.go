@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/textproto"
 	"strconv"
+	"strings"
 	"time"
 
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -16,15 +17,40 @@ type ReadResponseScannedStatus func(resp *ReaderResponse, metaData map[string]st
 type ReaderResponse struct {
 	Reader            io.ReadCloser
 	HashValue         string
+	HashAlgorithm     HashAlgorithm // set whenever HashValue is
 	MimeType          string
 	ContentLength     int64
-	Size              int64 // MIME size
+	ContentRange      string // set for WithRange reads, eg "bytes 0-511/4096"
+	BlobSize          int64  // the size of the whole blob, even for WithRange reads
+	ContentMD5        []byte // set by OnlyMetadata reads, if the service has an MD5 for the blob
+	Size              int64  // MIME size
 	Tags              map[string]string
 	TimestampAccepted string
 	ScannedStatus     string
 	ScannedBadReason  string
 	ScannedTimestamp  string
 
+	// AccessTier, ArchiveStatus and RehydratePriority are only set for
+	// OnlyMetadata reads. ArchiveStatus and RehydratePriority are only set
+	// while an archived blob is being rehydrated, see WaitForRehydration.
+	AccessTier        AccessTier
+	ArchiveStatus     ArchiveStatus
+	RehydratePriority RehydratePriority
+
+	// The Copy fields are only set for OnlyMetadata reads, and only if the
+	// blob was created by Copy, see WaitForCopy. CopyProgress is the number of
+	// bytes copied and the total, eg "512/4096".
+	CopyID                string
+	CopyStatus            CopyStatus
+	CopyProgress          string
+	CopyStatusDescription string
+
+	// The Lease fields report the lease on the blob, see BreakLease. The
+	// LeaseDuration is only set while the blob is leased.
+	LeaseState    string // "available", "leased", "expired", "breaking" or "broken"
+	LeaseStatus   string // "locked" or "unlocked"
+	LeaseDuration string // "infinite" or "fixed"
+
 	BlobClient *azStorageBlob.BlobClient
 
 	// The following are copied as appropriate from the azure sdk response.
@@ -43,12 +69,41 @@ func (r *ReaderResponse) ConditionNotMet() bool {
 	return r.XMsErrorCode == string(azStorageBlob.StorageErrorCodeConditionNotMet)
 }
 
-// Ok returns true if the http status was 200 or 201
+// Rehydrating returns true if the blob is archived and is being rehydrated to
+// an online tier. It is only meaningful for OnlyMetadata reads.
+func (r *ReaderResponse) Rehydrating() bool {
+	return r.ArchiveStatus != ""
+}
+
+// Leased returns true if the blob has a lease, including one which is
+// being broken, so that writes require the lease id
+func (r *ReaderResponse) Leased() bool {
+	return r.LeaseStatus == string(azStorageBlob.LeaseStatusTypeLocked)
+}
+
+// setLease sets the Lease fields from the azure sdk response
+func (r *ReaderResponse) setLease(
+	state *azStorageBlob.LeaseStateType,
+	status *azStorageBlob.LeaseStatusType,
+	duration *azStorageBlob.LeaseDurationType,
+) {
+	if state != nil {
+		r.LeaseState = string(*state)
+	}
+	if status != nil {
+		r.LeaseStatus = string(*status)
+	}
+	if duration != nil {
+		r.LeaseDuration = string(*duration)
+	}
+}
+
+// Ok returns true if the http status was 200 or 201, or 206 for WithRange reads
 // This method is provided for use in combination with specific headers like
 // If-Match and ETags conditions.  In thos circumstances we often get err=nil
 // but no content.
 func (r *ReaderResponse) Ok() bool {
-	return r.StatusCode == 200 || r.StatusCode == 201
+	return r.StatusCode == 200 || r.StatusCode == 201 || r.StatusCode == 206
 }
 
 const (
@@ -89,12 +144,19 @@ func downloadReaderResponse(r azStorageBlob.BlobDownloadResponse, rr *ReaderResp
 	rr.LastModified = r.LastModified
 	rr.ETag = r.ETag
 	rr.Metadata = r.Metadata
+	rr.setLease(r.LeaseState, r.LeaseStatus, r.LeaseDuration)
 
 	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
 	if ok && len(value) > 0 {
 		rr.XMsErrorCode = value[0]
 	}
 
+	value, ok = r.RawResponse.Header["Content-Range"]
+	if ok && len(value) > 0 {
+		rr.ContentRange = value[0]
+		rr.BlobSize = contentRangeSize(value[0])
+	}
+
 	s, ok := r.RawResponse.Header["Content-Length"]
 	if !ok {
 		return nil
@@ -106,9 +168,39 @@ func downloadReaderResponse(r azStorageBlob.BlobDownloadResponse, rr *ReaderResp
 
 	var err error
 	rr.ContentLength, err = strconv.ParseInt(s[0], 10, 64)
+	if err == nil && rr.ContentRange == "" {
+		rr.BlobSize = rr.ContentLength
+	}
 	return err
 }
 
+// contentRangeSize returns the complete length from a Content-Range header
+// value of the form "bytes <first>-<last>/<complete-length>", or 0 if it is
+// not known.
+func contentRangeSize(contentRange string) int64 {
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// metadataHashAlgorithm returns the algorithm used for the HashKey metadata,
+// blobs written before the algorithm was recorded are always sha256.
+func metadataHashAlgorithm(metaData map[string]string) HashAlgorithm {
+	if metaData[textproto.CanonicalMIMEHeaderKey(HashKey)] == "" {
+		return ""
+	}
+	if alg := metaData[textproto.CanonicalMIMEHeaderKey(HashAlgKey)]; alg != "" {
+		return HashAlgorithm(alg)
+	}
+	return DefaultHashAlgorithm
+}
+
 // readerResponseMetadata processes and conditions values from the metadata we have specific support for.
 func readerResponseMetadata(resp *ReaderResponse, metaData map[string]string) error {
 	size, parseErr := strconv.ParseInt(metaData[textproto.CanonicalMIMEHeaderKey(SizeKey)], 10, 64)
@@ -118,6 +210,7 @@ func readerResponseMetadata(resp *ReaderResponse, metaData map[string]string) er
 	}
 	resp.Size = size
 	resp.HashValue = metaData[textproto.CanonicalMIMEHeaderKey(HashKey)]
+	resp.HashAlgorithm = metadataHashAlgorithm(metaData)
 	resp.MimeType = metaData[textproto.CanonicalMIMEHeaderKey(MimeKey)]
 	resp.TimestampAccepted = metaData[textproto.CanonicalMIMEHeaderKey(TimeKey)]
 	if resp.setReadResponseScannedStatus != nil {
@@ -0,0 +1,160 @@
+package azblob
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// pipelineModule and pipelineVersion identify the requests made by this
+	// package, rather than by the sdk clients, in the User-Agent
+	pipelineModule  = "datatrails-azblob"
+	pipelineVersion = "v1"
+)
+
+// so we dont have to import the azure packages anywhere else
+type RetryOptions = policy.RetryOptions
+type ClientOptions = azStorageBlob.ClientOptions
+
+// WithClientOptions sets the options of the azure sdk clients, eg the
+// transport, telemetry or additional policies. The storer adds a policy which
+// logs each retried request.
+func WithClientOptions(options ClientOptions) StorerOption {
+	return func(a *Storer) {
+		a.clientOptions = options
+	}
+}
+
+// WithRetryPolicy sets the retry policy of the azure sdk clients, overriding
+// any set by WithClientOptions. The defaults are 3 retries, with exponential
+// backoff from 800ms, for 408, 429, 500, 502, 503 and 504 responses and
+// transport errors. MaxRetries < 0 disables retries.
+//
+// A Retry-After, on a 429 or 503 response, is used as the delay before the
+// retry. If it is longer than MaxRetryDelay the request is not retried, so
+// MaxRetryDelay bounds the time spent waiting for a throttled account.
+//
+// eg a bulk job which must complete eventually might use
+//
+//	RetryOptions{MaxRetries: 10, RetryDelay: 2 * time.Second, MaxRetryDelay: 2 * time.Minute}
+//
+// and a latency sensitive api, which should fail fast, might use
+//
+//	RetryOptions{MaxRetries: 1, TryTimeout: 2 * time.Second, MaxRetryDelay: 500 * time.Millisecond}
+//
+// A single call can override the policy using policy.WithRetryOptions on its
+// context.
+func WithRetryPolicy(retry RetryOptions) StorerOption {
+	return func(a *Storer) {
+		a.retryOptions = &retry
+	}
+}
+
+// WithReaderRetryPolicy sets the retry policy of the reader, see
+// WithRetryPolicy
+func WithReaderRetryPolicy(retry RetryOptions) ReaderOption {
+	return func(a *ReaderOptions) {
+		a.retryOptions = &retry
+	}
+}
+
+// azureClientOptions returns the options for the azure sdk clients
+func (azp *Storer) azureClientOptions() *azStorageBlob.ClientOptions {
+	options := azp.clientOptions
+	if azp.retryOptions != nil {
+		options.Retry = *azp.retryOptions
+	}
+	// clone, so that the caller's policies are not appended to
+	options.PerCallPolicies = append(slices.Clone(options.PerCallPolicies), retryCountPolicy{})
+	options.PerRetryPolicies = append(slices.Clone(options.PerRetryPolicies), retryLogPolicy{azp: azp})
+	return &options
+}
+
+// do sends a request which is not supported by the azure sdk clients, eg a
+// blob batch, with the same transport and retry policy as the clients. The
+// request body, if any, is read so that it can be retried.
+func (azp *Storer) do(req *http.Request) (*http.Response, error) {
+
+	options := azp.azureClientOptions()
+	pipeline := runtime.NewPipeline(pipelineModule, pipelineVersion, runtime.PipelineOptions{}, &policy.ClientOptions{
+		Logging:          options.Logging,
+		Retry:            options.Retry,
+		Telemetry:        options.Telemetry,
+		Transport:        options.Transport,
+		PerCallPolicies:  options.PerCallPolicies,
+		PerRetryPolicies: options.PerRetryPolicies,
+	})
+	pipelineReq, err := runtime.NewRequestFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.Do(pipelineReq)
+}
+
+// retryAttempt records the tries of a single request. It is shared by the
+// retries of the request.
+type retryAttempt struct {
+	try        int
+	statusCode int
+	err        error
+}
+
+// retryCountPolicy is run once per request, before the retry policy, and
+// gives each request its retryAttempt
+type retryCountPolicy struct{}
+
+func (retryCountPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(&retryAttempt{})
+	return req.Next()
+}
+
+// retryLogPolicy is run for every try of a request, and logs the retries, with
+// the outcome of the previous try. If the storer has a span function, each
+// retry also gets a span.
+type retryLogPolicy struct {
+	azp *Storer
+}
+
+func (p retryLogPolicy) Do(req *policy.Request) (*http.Response, error) {
+
+	var attempt *retryAttempt
+	if !req.OperationValue(&attempt) {
+		return req.Next()
+	}
+	attempt.try++
+	if attempt.try > 1 {
+		p.logRetry(req, attempt)
+	}
+
+	resp, err := req.Next()
+	attempt.statusCode, attempt.err = 0, err
+	if resp != nil {
+		attempt.statusCode = resp.StatusCode
+	}
+	return resp, err
+}
+
+// logRetry logs the retry. The url query is not logged, as it may be a SAS.
+func (p retryLogPolicy) logRetry(req *policy.Request, attempt *retryAttempt) {
+
+	raw := req.Raw()
+	p.azp.log.Infof("retrying %s %s: try %d, previous status %d: %v",
+		raw.Method, raw.URL.Path, attempt.try, attempt.statusCode, attempt.err)
+
+	if p.azp.startSpanFromContext == nil {
+		return
+	}
+	span, _ := p.azp.startSpanFromContext(raw.Context(), p.azp.log, "Retry")
+	defer span.Close()
+	span.SetTag("method", raw.Method)
+	span.SetTag("path", raw.URL.Path)
+	span.SetTag("try", attempt.try)
+	span.SetTag("statusCode", attempt.statusCode)
+	if attempt.err != nil {
+		span.SetTag("error", attempt.err.Error())
+	}
+}
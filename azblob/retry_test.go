@@ -0,0 +1,111 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/datatrails/go-datatrails-common/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	tests := []struct {
+		name       string
+		retry      RetryOptions
+		responses  []*http.Response
+		statusCode int
+		tries      int
+	}{
+		{
+			name:  "retry after",
+			retry: RetryOptions{MaxRetries: 3, MaxRetryDelay: time.Second},
+			responses: []*http.Response{
+				fakeResponse(http.StatusServiceUnavailable, http.Header{"Retry-After-Ms": {"10"}}),
+				fakeResponse(http.StatusTooManyRequests, http.Header{"Retry-After-Ms": {"10"}}),
+				fakeResponse(http.StatusOK, nil),
+			},
+			statusCode: http.StatusOK,
+			tries:      3,
+		},
+		{
+			name:  "retry after exceeds max delay",
+			retry: RetryOptions{MaxRetries: 3, MaxRetryDelay: 10 * time.Millisecond},
+			responses: []*http.Response{
+				fakeResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}}),
+				fakeResponse(http.StatusOK, nil),
+			},
+			statusCode: http.StatusServiceUnavailable,
+			tries:      1,
+		},
+		{
+			name:  "retries exhausted",
+			retry: RetryOptions{MaxRetries: 2, RetryDelay: time.Millisecond},
+			responses: []*http.Response{
+				fakeResponse(http.StatusInternalServerError, nil),
+			},
+			statusCode: http.StatusInternalServerError,
+			tries:      3,
+		},
+		{
+			name:  "no retries",
+			retry: RetryOptions{MaxRetries: -1},
+			responses: []*http.Response{
+				fakeResponse(http.StatusInternalServerError, nil),
+				fakeResponse(http.StatusOK, nil),
+			},
+			statusCode: http.StatusInternalServerError,
+			tries:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &fakeTransport{responses: tt.responses}
+			azp := &Storer{log: logger.Sugar}
+			WithClientOptions(ClientOptions{Transport: transport})(azp)
+			WithRetryPolicy(tt.retry)(azp)
+
+			req, err := http.NewRequestWithContext(
+				context.Background(), http.MethodPost,
+				"https://account.blob.core.windows.net/container?comp=batch",
+				strings.NewReader("BODY"))
+			require.NoError(t, err)
+			resp, err := azp.do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			// the body is sent again with each retry
+			assert.Len(t, transport.bodies, tt.tries)
+			for _, body := range transport.bodies {
+				assert.Equal(t, "BODY", body)
+			}
+		})
+	}
+}
+
+func TestAzureClientOptions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	azp := &Storer{log: logger.Sugar}
+	perCall := make([]policy.Policy, 0, 4)
+	WithClientOptions(ClientOptions{PerCallPolicies: perCall})(azp)
+
+	options := azp.azureClientOptions()
+	assert.Len(t, options.PerCallPolicies, 1)
+	assert.Len(t, options.PerRetryPolicies, 1)
+	// the caller's policies are not appended to
+	assert.Nil(t, perCall[:1][0])
+	assert.Equal(t, RetryOptions{}, options.Retry)
+
+	WithRetryPolicy(RetryOptions{MaxRetries: 7})(azp)
+	assert.Equal(t, int32(7), azp.azureClientOptions().Retry.MaxRetries)
+}
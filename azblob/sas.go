@@ -0,0 +1,432 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// defaultSASLifetime is the lifetime of a SAS if WithSASExpiry is not given
+	defaultSASLifetime = time.Hour
+
+	// sasClockSkew is subtracted from the start time of user delegation keys
+	// so that they are valid on servers whose clocks are behind ours
+	sasClockSkew = 5 * time.Minute
+
+	// userDelegationKeyLifetime is the minimum lifetime of a user delegation
+	// key, the key is re-used for all SAS which expire before it does. Azure
+	// allows at most 7 days.
+	userDelegationKeyLifetime    = 24 * time.Hour
+	maxUserDelegationKeyLifetime = 7 * 24 * time.Hour
+
+	// userDelegationSASVersion is the service version of user delegation SAS,
+	// the string to sign differs between versions
+	userDelegationSASVersion = "2020-02-10"
+
+	// storageScope is the azure ad scope for blob storage requests
+	storageScope = "https://storage.azure.com/.default"
+)
+
+var (
+	ErrSASNoCredential = errors.New("sas requires a shared key or azure ad credential")
+)
+
+// SASProtocol is the protocol permitted for requests made with a SAS
+type SASProtocol = azStorageBlob.SASProtocol
+
+const (
+	SASProtocolHTTPS                    = azStorageBlob.SASProtocolHTTPS
+	SASProtocolHTTPSandHTTP SASProtocol = "https,http"
+)
+
+// BlobSASPermissions and ContainerSASPermissions build the permissions string
+// for WithSASPermissions, eg BlobSASPermissions{Read: true}.String()
+type BlobSASPermissions = azStorageBlob.BlobSASPermissions
+type ContainerSASPermissions = azStorageBlob.ContainerSASPermissions
+
+// SASResponse is a generated shared access signature
+type SASResponse struct {
+	// Token is the SAS query string, without a leading '?'
+	Token string
+	// URL is the url of the blob or container with the Token
+	URL    string
+	Expiry time.Time
+}
+
+// GenerateBlobSAS returns a shared access signature which grants access to the
+// identified blob, without any other credential, until it expires. For
+// example, the URL with the default permissions is a time limited download
+// link.
+//
+// The SAS is signed with the storer's shared key. A storer which has an azure
+// ad credential instead, eg from NewReaderDefaultAuth, signs a user
+// delegation SAS. The identity must then be assigned a role which permits
+// "generate a user delegation key" as well as the access granted.
+//
+// Supported options are WithSASPermissions, which defaults to read,
+// WithSASExpiry, which defaults to an hour, WithSASIPRange and
+// WithSASProtocol.
+func (azp *Storer) GenerateBlobSAS(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*SASResponse, error) {
+	azp.log.Debugf("GenerateBlobSAS %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	permissions := BlobSASPermissions{Read: true}.String()
+	if options.sasPermissions != "" {
+		perms := &BlobSASPermissions{}
+		if err = perms.Parse(options.sasPermissions); err != nil {
+			return nil, NewStatusError(err.Error(), http.StatusBadRequest)
+		}
+		permissions = perms.String()
+	}
+	return azp.generateSAS(ctx, blobClient.URL(), "b", identity, permissions, options)
+}
+
+// GenerateContainerSAS returns a shared access signature which grants access
+// to the storer's container, and all of the blobs in it, until it expires. The
+// Token can be given to NewReaderSAS.
+//
+// The SAS is signed as for GenerateBlobSAS and supports the same options. The
+// permissions default to read and list.
+func (azp *Storer) GenerateContainerSAS(ctx context.Context, opts ...Option) (*SASResponse, error) {
+	azp.log.Debugf("GenerateContainerSAS %s", azp.Container)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	permissions := ContainerSASPermissions{Read: true, List: true}.String()
+	if options.sasPermissions != "" {
+		perms := &ContainerSASPermissions{}
+		if err := perms.Parse(options.sasPermissions); err != nil {
+			return nil, NewStatusError(err.Error(), http.StatusBadRequest)
+		}
+		permissions = perms.String()
+	}
+	return azp.generateSAS(ctx, azp.containerClient.URL(), "c", "", permissions, options)
+}
+
+// generateSAS signs a SAS for the resource, a blob ("b") or the container
+// ("c"), with the permissions, which must already be in canonical order
+func (azp *Storer) generateSAS(
+	ctx context.Context,
+	resourceURL string,
+	resource string,
+	identity string,
+	permissions string,
+	options *StorerOptions,
+) (*SASResponse, error) {
+
+	expiry := options.sasExpiry
+	if expiry.IsZero() {
+		expiry = time.Now().Add(defaultSASLifetime)
+	}
+	expiry = expiry.UTC().Truncate(time.Second)
+
+	var token string
+	switch {
+	case azp.credential != nil:
+		values := azStorageBlob.BlobSASSignatureValues{
+			Protocol:      options.sasProtocol,
+			ExpiryTime:    expiry,
+			Permissions:   permissions,
+			IPRange:       options.sasIPRange,
+			ContainerName: azp.Container,
+			BlobName:      identity,
+		}
+		params, err := values.NewSASQueryParameters(azp.credential)
+		if err != nil {
+			return nil, NewStatusError(err.Error(), http.StatusBadRequest)
+		}
+		token = params.Encode()
+	case azp.tokenCredential != nil:
+		key, err := azp.userDelegationKey(ctx, expiry)
+		if err != nil {
+			return nil, err
+		}
+		token, err = azp.userDelegationSAS(key, resource, identity, permissions, expiry, options)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrSASNoCredential
+	}
+
+	return &SASResponse{
+		Token:  token,
+		URL:    resourceURL + "?" + token,
+		Expiry: expiry,
+	}, nil
+}
+
+// userDelegationKey is the key returned by the "Get User Delegation Key" rest
+// api. The times are kept as strings as they are signed exactly as returned.
+type userDelegationKey struct {
+	SignedOid     string `xml:"SignedOid"`
+	SignedTid     string `xml:"SignedTid"`
+	SignedStart   string `xml:"SignedStart"`
+	SignedExpiry  string `xml:"SignedExpiry"`
+	SignedService string `xml:"SignedService"`
+	SignedVersion string `xml:"SignedVersion"`
+	Value         string `xml:"Value"`
+
+	expiry time.Time
+}
+
+// userDelegationKey returns a user delegation key which is valid until at
+// least expiry. The key is cached by the storer and re-used until it expires.
+//
+// The lock is not held while a key is fetched, so that a SAS which the cached
+// key can sign is not held up by the fetch of a longer lived key.
+func (azp *Storer) userDelegationKey(ctx context.Context, expiry time.Time) (*userDelegationKey, error) {
+
+	azp.delegationKeyMu.Lock()
+	cached := azp.delegationKey
+	azp.delegationKeyMu.Unlock()
+	if cached != nil && !cached.expiry.Before(expiry) {
+		return cached, nil
+	}
+
+	key, err := azp.getUserDelegationKey(ctx, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	// a concurrent fetch may have cached a key which lasts longer
+	azp.delegationKeyMu.Lock()
+	defer azp.delegationKeyMu.Unlock()
+	if azp.delegationKey == nil || azp.delegationKey.expiry.Before(key.expiry) {
+		azp.delegationKey = key
+	}
+	return key, nil
+}
+
+// getUserDelegationKey gets a user delegation key which is valid until at
+// least expiry from azure
+func (azp *Storer) getUserDelegationKey(ctx context.Context, expiry time.Time) (*userDelegationKey, error) {
+
+	now := time.Now().UTC().Truncate(time.Second)
+	keyExpiry := now.Add(userDelegationKeyLifetime)
+	if keyExpiry.Before(expiry) {
+		keyExpiry = expiry
+	}
+	if keyExpiry.After(now.Add(maxUserDelegationKeyLifetime)) {
+		return nil, NewStatusError(
+			fmt.Sprintf("a user delegation sas must expire within %s", maxUserDelegationKeyLifetime),
+			http.StatusBadRequest)
+	}
+	azp.log.Debugf("Get user delegation key until %s", keyExpiry.Format(azStorageBlob.SASTimeFormat))
+
+	token, err := azp.tokenCredential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	body := fmt.Sprintf(
+		"<?xml version=\"1.0\" encoding=\"utf-8\"?><KeyInfo><Start>%s</Start><Expiry>%s</Expiry></KeyInfo>",
+		now.Add(-sasClockSkew).Format(azStorageBlob.SASTimeFormat),
+		keyExpiry.Format(azStorageBlob.SASTimeFormat),
+	)
+	serviceURL, err := url.Parse(azp.rootURL)
+	if err != nil {
+		return nil, err
+	}
+	serviceURL.RawQuery = url.Values{"restype": {"service"}, "comp": {"userdelegationkey"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serviceURL.String(), bytes.NewReader([]byte(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("x-ms-version", batchServiceVersion)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	resp, err := azp.do(req)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStorageError(
+			fmt.Sprintf("get user delegation key failed: %s", resp.Status),
+			resp.StatusCode, resp.Header.Get(xMsErrorCodeHeader))
+	}
+	key := &userDelegationKey{}
+	if err = xml.NewDecoder(resp.Body).Decode(key); err != nil {
+		return nil, fmt.Errorf("bad user delegation key response: %w", err)
+	}
+	key.expiry, err = time.Parse(time.RFC3339, key.SignedExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("bad user delegation key response: %w", err)
+	}
+	return key, nil
+}
+
+// userDelegationSAS returns the query string of a SAS signed with key. The sdk
+// only signs with a shared key, so this follows the "Create a user delegation
+// SAS" rest api documentation for userDelegationSASVersion.
+func (azp *Storer) userDelegationSAS(
+	key *userDelegationKey,
+	resource string,
+	identity string,
+	permissions string,
+	expiry time.Time,
+	options *StorerOptions,
+) (string, error) {
+
+	canonicalName := "/blob/" + azp.sasAccountName() + "/" + azp.Container
+	if identity != "" {
+		canonicalName += "/" + identity
+	}
+	signedExpiry := expiry.Format(azStorageBlob.SASTimeFormat)
+	ipRange := options.sasIPRange.String()
+
+	stringToSign := strings.Join([]string{
+		permissions,
+		"", // signed start, the sas is valid immediately
+		signedExpiry,
+		canonicalName,
+		key.SignedOid,
+		key.SignedTid,
+		key.SignedStart,
+		key.SignedExpiry,
+		key.SignedService,
+		key.SignedVersion,
+		"", // signed authorized user object id
+		"", // signed unauthorized user object id
+		"", // signed correlation id
+		ipRange,
+		string(options.sasProtocol),
+		userDelegationSASVersion,
+		resource,
+		"", // signed snapshot time
+		"", // rscc
+		"", // rscd
+		"", // rsce
+		"", // rscl
+		"", // rsct
+	}, "\n")
+
+	keyValue, err := base64.StdEncoding.DecodeString(key.Value)
+	if err != nil {
+		return "", fmt.Errorf("bad user delegation key: %w", err)
+	}
+	h := hmac.New(sha256.New, keyValue)
+	h.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	query := url.Values{
+		"sv":    {userDelegationSASVersion},
+		"sr":    {resource},
+		"se":    {signedExpiry},
+		"sp":    {permissions},
+		"skoid": {key.SignedOid},
+		"sktid": {key.SignedTid},
+		"skt":   {key.SignedStart},
+		"ske":   {key.SignedExpiry},
+		"sks":   {key.SignedService},
+		"skv":   {key.SignedVersion},
+		"sig":   {signature},
+	}
+	if ipRange != "" {
+		query.Set("sip", ipRange)
+	}
+	if options.sasProtocol != "" {
+		query.Set("spr", string(options.sasProtocol))
+	}
+	return query.Encode(), nil
+}
+
+// sasAccountName returns the storage account name, from the service url if
+// the storer was not given it
+func (azp *Storer) sasAccountName() string {
+	if azp.AccountName != "" {
+		return azp.AccountName
+	}
+	return accountNameFromURL(azp.rootURL)
+}
+
+// NewReaderSAS is a azure blob reader client that is authorized by a shared
+// access signature, eg the Token generated by Storer.GenerateContainerSAS.
+//
+// Paramaters:
+//
+//	url: The root path for the blob store requests, must not be empty
+//	token: The SAS query string, with or without a leading '?'
+//	opts: optional arguments, as for NewReaderNoAuth
+//
+// NOTE: the reader can only do what the SAS permits, and only until it
+// expires. A container SAS must be for the container given by WithContainer.
+func NewReaderSAS(log Logger, url string, token string, opts ...ReaderOption) (Reader, error) {
+	var err error
+	if url == "" {
+		return nil, errors.New("url is a required parameter and cannot be empty")
+	}
+	token = strings.TrimPrefix(token, "?")
+	if token == "" {
+		return nil, errors.New("token is a required parameter and cannot be empty")
+	}
+
+	readerOptions := ParseReaderOptions(opts...)
+
+	azp := &Storer{
+		AccountName:          readerOptions.accountName, // just for logging
+		ResourceGroup:        "",                        // just for logging
+		Subscription:         "",                        // just for logging
+		Container:            readerOptions.container,
+		credential:           nil,
+		rootURL:              url,
+		startSpanFromContext: readerOptions.startSpanFromContext,
+		retryOptions:         readerOptions.retryOptions,
+		log:                  log,
+	}
+
+	// the sdk keeps the query of the service url on the urls of the container
+	// and blob clients made from it
+	azp.serviceClient, err = azStorageBlob.NewServiceClientWithNoCredential(
+		url+"?"+token,
+		azp.azureClientOptions(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if readerOptions.container == "" {
+		return azp, nil
+	}
+
+	azp.containerURL = fmt.Sprintf(
+		"%s%s",
+		url,
+		readerOptions.container,
+	)
+	azp.containerClient, err = azp.serviceClient.NewContainerClient(readerOptions.container)
+	if err != nil {
+		return nil, err
+	}
+
+	return azp, nil
+}
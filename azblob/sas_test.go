@@ -0,0 +1,346 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+// fakeTokenCredential returns a fixed azure ad token
+type fakeTokenCredential struct{}
+
+func (fakeTokenCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// testDelegationKey is a user delegation key as returned by azure
+var testDelegationKey = userDelegationKey{
+	SignedOid:     "11111111-1111-1111-1111-111111111111",
+	SignedTid:     "22222222-2222-2222-2222-222222222222",
+	SignedStart:   "2030-01-01T00:00:00Z",
+	SignedExpiry:  "2030-01-03T00:00:00Z",
+	SignedService: "b",
+	SignedVersion: "2021-12-02",
+	Value:         "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+}
+
+func TestUserDelegationSAS(t *testing.T) {
+	azp := &Storer{AccountName: "devstoreaccount1", Container: "testcontainer"}
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		resource    string
+		identity    string
+		permissions string
+		opts        []Option
+		expected    url.Values
+	}{
+		{
+			name:        "blob",
+			resource:    "b",
+			identity:    "blob",
+			permissions: "r",
+			opts: []Option{
+				WithSASIPRange(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.9")),
+				WithSASProtocol(SASProtocolHTTPS),
+			},
+			// the signature is of
+			//
+			//	r\n\n2030-01-02T03:04:05Z\n/blob/devstoreaccount1/testcontainer/blob\n
+			//	<skoid>\n<sktid>\n<skt>\n<ske>\nb\n2021-12-02\n\n\n\n
+			//	10.0.0.1-10.0.0.9\nhttps\n2020-02-10\nb\n\n\n\n\n\n
+			expected: url.Values{
+				"sv":  {"2020-02-10"},
+				"sr":  {"b"},
+				"se":  {"2030-01-02T03:04:05Z"},
+				"sp":  {"r"},
+				"sip": {"10.0.0.1-10.0.0.9"},
+				"spr": {"https"},
+				"sig": {"77HlZzepZWrgXEnOS7Iv+JZtYdY/pdZRG9wLvPri9QY="},
+			},
+		},
+		{
+			name:        "container",
+			resource:    "c",
+			permissions: "rl",
+			expected: url.Values{
+				"sv":  {"2020-02-10"},
+				"sr":  {"c"},
+				"se":  {"2030-01-02T03:04:05Z"},
+				"sp":  {"rl"},
+				"sig": {"XLzXg72yy6khXgsIhkCVm2Zi4S657qzizdoHRzh5j2s="},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := &StorerOptions{}
+			for _, opt := range test.opts {
+				opt(options)
+			}
+			token, err := azp.userDelegationSAS(&testDelegationKey, test.resource, test.identity, test.permissions, expiry, options)
+			require.NoError(t, err)
+			query, err := url.ParseQuery(token)
+			require.NoError(t, err)
+
+			expected := url.Values{
+				"skoid": {testDelegationKey.SignedOid},
+				"sktid": {testDelegationKey.SignedTid},
+				"skt":   {testDelegationKey.SignedStart},
+				"ske":   {testDelegationKey.SignedExpiry},
+				"sks":   {testDelegationKey.SignedService},
+				"skv":   {testDelegationKey.SignedVersion},
+			}
+			for k, v := range test.expected {
+				expected[k] = v
+			}
+			assert.Equal(t, expected, query)
+		})
+	}
+
+	key := testDelegationKey
+	key.Value = "not base64"
+	_, err := azp.userDelegationSAS(&key, "b", "blob", "r", expiry, &StorerOptions{})
+	assert.Error(t, err)
+}
+
+func TestSharedKeySAS(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	// the well known key of the azurite storage emulator
+	credential, err := azStorageBlob.NewSharedKeyCredential(
+		"devstoreaccount1",
+		"Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+	)
+	require.NoError(t, err)
+	containerURL := "http://127.0.0.1:10000/devstoreaccount1/testcontainer"
+	containerClient, err := azStorageBlob.NewContainerClientWithSharedKey(containerURL, credential, nil)
+	require.NoError(t, err)
+	azp := &Storer{
+		Container:       "testcontainer",
+		credential:      credential,
+		containerClient: containerClient,
+		log:             logger.Sugar,
+	}
+	ctx := context.Background()
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// the signature is of
+	//
+	//	rw\n\n2030-01-02T03:04:05Z\n/blob/devstoreaccount1/testcontainer/blob\n
+	//	\n\nhttps\n2019-12-12\nb\n\n\n\n\n\n
+	sas, err := azp.GenerateBlobSAS(ctx, "blob",
+		WithSASPermissions("wr"), WithSASExpiry(expiry), WithSASProtocol(SASProtocolHTTPS))
+	require.NoError(t, err)
+	assert.Equal(t, expiry, sas.Expiry)
+	assert.Equal(t, containerURL+"/blob?"+sas.Token, sas.URL)
+	query, err := url.ParseQuery(sas.Token)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"sv":  {"2019-12-12"},
+		"sr":  {"b"},
+		"se":  {"2030-01-02T03:04:05Z"},
+		"sp":  {"rw"},
+		"spr": {"https"},
+		"sig": {"sPYka8CXtsr6M0AC/WgE8GD23lyK2twY57qbwoOtXgU="},
+	}, query)
+
+	// the signature is of
+	//
+	//	rl\n\n2030-01-02T03:04:05Z\n/blob/devstoreaccount1/testcontainer\n
+	//	\n\n\n2019-12-12\nc\n\n\n\n\n\n
+	sas, err = azp.GenerateContainerSAS(ctx, WithSASExpiry(expiry.Add(500*time.Millisecond)))
+	require.NoError(t, err)
+	assert.Equal(t, expiry, sas.Expiry)
+	assert.Equal(t, containerURL+"?"+sas.Token, sas.URL)
+	query, err = url.ParseQuery(sas.Token)
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{
+		"sv":  {"2019-12-12"},
+		"sr":  {"c"},
+		"se":  {"2030-01-02T03:04:05Z"},
+		"sp":  {"rl"},
+		"sig": {"d+/s/WpELGjHFIQw6aceDGPri/eqceVDQtwXhy6QR40="},
+	}, query)
+
+	_, err = azp.GenerateBlobSAS(ctx, "blob", WithSASPermissions("q"))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+	_, err = (&Storer{Container: "testcontainer", containerClient: containerClient, log: logger.Sugar}).GenerateContainerSAS(ctx)
+	assert.ErrorIs(t, err, ErrSASNoCredential)
+}
+
+// delegationKeyServer is a fake "Get User Delegation Key" endpoint which
+// returns keys expiring at each of expiries in turn, and records the request
+// bodies
+type delegationKeyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	expiries []time.Time
+	bodies   []string
+}
+
+func newDelegationKeyServer(expiries ...time.Time) *delegationKeyServer {
+	s := &delegationKeyServer{expiries: expiries}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *delegationKeyServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(body))
+	if r.URL.Query().Get("comp") != "userdelegationkey" || r.Header.Get("Authorization") != "Bearer token" ||
+		len(s.expiries) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	expiry := s.expiries[0]
+	s.expiries = s.expiries[1:]
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w,
+		"<?xml version=\"1.0\" encoding=\"utf-8\"?><UserDelegationKey>"+
+			"<SignedOid>%s</SignedOid><SignedTid>%s</SignedTid>"+
+			"<SignedStart>%s</SignedStart><SignedExpiry>%s</SignedExpiry>"+
+			"<SignedService>b</SignedService><SignedVersion>2021-12-02</SignedVersion>"+
+			"<Value>%s</Value></UserDelegationKey>",
+		testDelegationKey.SignedOid, testDelegationKey.SignedTid,
+		testDelegationKey.SignedStart, expiry.Format(azStorageBlob.SASTimeFormat),
+		testDelegationKey.Value,
+	)
+}
+
+// requests returns the bodies of the requests made so far
+func (s *delegationKeyServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.bodies)
+}
+
+func TestUserDelegationKeyCache(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	server := newDelegationKeyServer(now.Add(userDelegationKeyLifetime), now.Add(48*time.Hour))
+	defer server.Close()
+	azp := &Storer{
+		AccountName:     "devstoreaccount1",
+		Container:       "testcontainer",
+		rootURL:         server.URL + "/devstoreaccount1/",
+		tokenCredential: fakeTokenCredential{},
+		log:             logger.Sugar,
+	}
+	ctx := context.Background()
+
+	key, err := azp.userDelegationKey(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, server.requests(), 1)
+	assert.Equal(t, testDelegationKey.Value, key.Value)
+	assert.Equal(t, now.Add(userDelegationKeyLifetime), key.expiry)
+
+	// the key is re-used for any SAS which expires before it does
+	reused, err := azp.userDelegationKey(ctx, now.Add(userDelegationKeyLifetime))
+	require.NoError(t, err)
+	assert.Same(t, key, reused)
+	assert.Len(t, server.requests(), 1)
+
+	// and renewed, until the SAS expiry, for one which does not
+	renewed, err := azp.userDelegationKey(ctx, now.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.NotSame(t, key, renewed)
+	assert.Equal(t, now.Add(48*time.Hour), renewed.expiry)
+	requests := server.requests()
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1], "<Expiry>"+now.Add(48*time.Hour).Format(azStorageBlob.SASTimeFormat)+"</Expiry>")
+
+	// azure does not issue keys for more than 7 days
+	_, err = azp.userDelegationKey(ctx, now.Add(maxUserDelegationKeyLifetime+time.Hour))
+	assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+	assert.Len(t, server.requests(), 2)
+
+	// the key signs the SAS of a storer with an azure ad credential
+	containerClient, err := azStorageBlob.NewContainerClient(azp.rootURL+azp.Container, azp.tokenCredential, nil)
+	require.NoError(t, err)
+	azp.containerClient = containerClient
+	sas, err := azp.GenerateContainerSAS(ctx, WithSASExpiry(now.Add(time.Hour)))
+	require.NoError(t, err)
+	assert.Len(t, server.requests(), 2)
+	query, err := url.ParseQuery(sas.Token)
+	require.NoError(t, err)
+	assert.Equal(t, userDelegationSASVersion, query.Get("sv"))
+	assert.Equal(t, testDelegationKey.SignedOid, query.Get("skoid"))
+}
+
+func TestUserDelegationKeyFetch(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	server := newDelegationKeyServer(now.Add(48 * time.Hour))
+	defer server.Close()
+	// the fetch is held until it is released
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	gated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		server.serve(w, r)
+	}))
+	defer gated.Close()
+
+	cached := testDelegationKey
+	cached.expiry = now.Add(userDelegationKeyLifetime)
+	azp := &Storer{
+		AccountName:     "devstoreaccount1",
+		Container:       "testcontainer",
+		rootURL:         gated.URL + "/devstoreaccount1/",
+		tokenCredential: fakeTokenCredential{},
+		delegationKey:   &cached,
+		log:             logger.Sugar,
+	}
+	ctx := context.Background()
+
+	renewed := make(chan *userDelegationKey)
+	go func() {
+		key, err := azp.userDelegationKey(ctx, now.Add(48*time.Hour))
+		assert.NoError(t, err)
+		renewed <- key
+	}()
+	<-requested
+
+	// the cached key is returned while a longer lived key is fetched
+	key, err := azp.userDelegationKey(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Same(t, &cached, key)
+
+	close(release)
+	key = <-renewed
+	require.NotNil(t, key)
+	assert.Equal(t, now.Add(48*time.Hour), key.expiry)
+	reused, err := azp.userDelegationKey(ctx, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Same(t, key, reused)
+	assert.Len(t, server.requests(), 1)
+}
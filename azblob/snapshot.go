@@ -0,0 +1,330 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// historyIDFormat is the format of snapshot times and version ids
+	historyIDFormat = "2006-01-02T15:04:05.0000000Z"
+)
+
+// Snapshot creates a read only, point in time, copy of the blob. The snapshot
+// is identified by the time in the Snapshot field of the response, which is
+// read using WithSnapshot.
+//
+// WithMetadata sets the metadata of the snapshot, otherwise it has the
+// metadata of the blob. The ETag, since and lease options make the snapshot
+// conditional on the state of the blob.
+//
+// A blob which has snapshots can only be deleted using WithDeleteSnapshots.
+func (azp *Storer) Snapshot(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("Snapshot %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := blobClient.CreateSnapshot(
+		ctx,
+		&azStorageBlob.BlobCreateSnapshotOptions{
+			Metadata:                 options.metadata,
+			LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+			ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		Snapshot:     r.Snapshot,
+		VersionID:    r.VersionID,
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w, nil
+}
+
+// PromoteVersion makes a prior version of the blob the current version, by
+// copying it over the blob. Versioning must be enabled for the storage
+// account. With versioning, the blob it replaces is kept as a prior version.
+//
+// WithMetadata and WithTags set the metadata and tags of the blob, otherwise
+// it has the metadata of the version and no tags. The ETag, since and lease
+// options make the copy conditional on the state of the current blob.
+func (azp *Storer) PromoteVersion(
+	ctx context.Context,
+	identity string,
+	versionID string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	azp.log.Debugf("PromoteVersion %s: %s", identity, versionID)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobAccessConditions, err := storerOptionConditions(options)
+	if err != nil {
+		return nil, err
+	}
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	versionClient, err := blobClient.WithVersionID(versionID)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	r, err := blobClient.StartCopyFromURL(
+		ctx,
+		versionClient.URL(),
+		&azStorageBlob.BlobStartCopyOptions{
+			Metadata:                 options.metadata,
+			TagsMap:                  options.tags,
+			LeaseAccessConditions:    blobAccessConditions.LeaseAccessConditions,
+			ModifiedAccessConditions: blobAccessConditions.ModifiedAccessConditions,
+		},
+	)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	w := WriteResponse{
+		ETag:         r.ETag,
+		LastModified: r.LastModified,
+		VersionID:    r.VersionID,
+	}
+	w.Status = r.RawResponse.Status
+	w.StatusCode = r.RawResponse.StatusCode
+	value, ok := r.RawResponse.Header[xMsErrorCodeHeader]
+	if ok && len(value) > 0 {
+		w.XMsErrorCode = value[0]
+	}
+	return &w, nil
+}
+
+// blobClient returns a client for the blob, or for the snapshot or version of
+// it given by WithSnapshot or WithVersionID
+func (azp *Storer) blobClient(identity string, options *StorerOptions) (*azStorageBlob.BlobClient, error) {
+
+	blobClient, err := azp.containerClient.NewBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if options.snapshot != "" {
+		blobClient, err = blobClient.WithSnapshot(options.snapshot)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+	}
+	if options.versionID != "" {
+		blobClient, err = blobClient.WithVersionID(options.versionID)
+		if err != nil {
+			return nil, ErrorFromError(err)
+		}
+	}
+	return blobClient, nil
+}
+
+// SetVersioning enables, or disables, the emulation of blob versioning. For
+// azure, versioning is a property of the storage account. When enabled, every
+// write to a blob gives it a new version id and keeps the blob it replaced as
+// a prior version. Deleted blobs are also kept as prior versions.
+func (s *localStore) SetVersioning(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.versioning = enabled
+}
+
+// Snapshot creates a snapshot of a blob, see Storer.Snapshot
+func (s *localStore) Snapshot(
+	ctx context.Context,
+	identity string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("Snapshot local blob %s", identity)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if existing == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	now := s.now()
+	if err = checkLease(existing, options.leaseID, now, false); err != nil {
+		return nil, err
+	}
+	if _, err = checkConditions(existing, options, false); err != nil {
+		return nil, err
+	}
+
+	snapshot := *existing
+	snapshot.Snapshot = s.nextHistoryID(now)
+	snapshot.VersionID = ""
+	snapshot.localLease = localLease{}
+	if options.metadata != nil {
+		snapshot.Metadata = maps.Clone(options.metadata)
+	}
+	if err = s.backend.storeHistory(&snapshot); err != nil {
+		return nil, ErrorFromError(err)
+	}
+
+	wr := localWriteResponse(existing)
+	snapshotID := snapshot.Snapshot
+	wr.Snapshot = &snapshotID
+	return wr, nil
+}
+
+// PromoteVersion copies a prior version over the blob, see Storer.PromoteVersion
+func (s *localStore) PromoteVersion(
+	ctx context.Context,
+	identity string,
+	versionID string,
+	opts ...Option,
+) (*WriteResponse, error) {
+	s.log.Debugf("PromoteVersion local blob %s: %s", identity, versionID)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version, err := s.loadSelected(identity, &StorerOptions{versionID: versionID})
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		return nil, blobNotFoundError(identity)
+	}
+	metadata := version.Metadata
+	if options.metadata != nil {
+		metadata = options.metadata
+	}
+	wr, err := s.putLocked(&localBlob{
+		Name:                identity,
+		Data:                bytes.Clone(version.Data),
+		Metadata:            metadata,
+		Tags:                options.tags,
+		ContentMD5:          version.ContentMD5,
+		BlobType:            version.BlobType,
+		CommittedBlockCount: version.CommittedBlockCount,
+		Pages:               slices.Clone(version.Pages),
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+	promoted, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if promoted != nil && promoted.VersionID != "" {
+		// the response must not refer to the stored blob
+		promotedID := promoted.VersionID
+		wr.VersionID = &promotedID
+	}
+	return wr, nil
+}
+
+// loadSelected loads the blob, or the snapshot or version of it given by
+// WithSnapshot or WithVersionID. It returns nil if it does not exist.
+func (s *localStore) loadSelected(identity string, options *StorerOptions) (*localBlob, error) {
+
+	b, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if options.snapshot == "" && options.versionID == "" {
+		return b, nil
+	}
+	if options.snapshot == "" && b != nil && b.VersionID == options.versionID {
+		return b, nil
+	}
+	history, err := s.loadHistory(identity)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range history {
+		if h.Deleted != "" {
+			continue
+		}
+		if options.snapshot != "" && h.Snapshot == options.snapshot {
+			return h, nil
+		}
+		if options.snapshot == "" && h.Snapshot == "" && h.VersionID == options.versionID {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+// storeBlob stores b as the current blob, replacing existing, which is nil if
+// there is no current blob. With versioning, b is given a new version id and
+// existing is kept as a prior version.
+func (s *localStore) storeBlob(existing *localBlob, b *localBlob, now time.Time) error {
+
+	b.VersionID = ""
+	if s.versioning {
+		if err := s.keepVersion(existing); err != nil {
+			return err
+		}
+		b.VersionID = s.nextHistoryID(now)
+	}
+	if err := s.backend.store(b); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// keepVersion keeps b as a prior version, if versioning is enabled and b has a
+// version id. b may be nil.
+func (s *localStore) keepVersion(b *localBlob) error {
+
+	if !s.versioning || b == nil || b.VersionID == "" {
+		return nil
+	}
+	prior := *b
+	prior.localLease = localLease{}
+	if err := s.backend.storeHistory(&prior); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
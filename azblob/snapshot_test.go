@@ -0,0 +1,132 @@
+package azblob
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreSnapshots(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			_, err := store.Put(ctx, "index", NewBytesReaderCloser([]byte("first")))
+			require.NoError(t, err)
+			wr, err := store.Snapshot(ctx, "index", WithMetadata(map[string]string{"note": "first"}))
+			require.NoError(t, err)
+			require.NotNil(t, wr.Snapshot)
+			snapshot := *wr.Snapshot
+			*wr.Snapshot = "changed" // the response does not refer to the stored snapshot
+			_, err = store.Put(ctx, "index", NewBytesReaderCloser([]byte("second")))
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "index", WithSnapshot(snapshot), WithGetMetadata(BothMetadataAndBlob))
+			require.NoError(t, err)
+			assert.Equal(t, "first", readAll(t, rr))
+			assert.Equal(t, "first", rr.Metadata["Note"])
+			rr, err = store.Reader(ctx, "index")
+			require.NoError(t, err)
+			assert.Equal(t, "second", readAll(t, rr))
+
+			_, err = store.Reader(ctx, "index", WithSnapshot("2000-01-01T00:00:00.0000000Z"))
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+
+			var items []string
+			for item, err := range store.ListAll(ctx, WithListSnapshots(), WithListMaxResults(1)) {
+				require.NoError(t, err)
+				if item.Snapshot != nil {
+					items = append(items, *item.Name+"@"+*item.Snapshot)
+					continue
+				}
+				items = append(items, *item.Name)
+			}
+			assert.Equal(t, []string{"index", "index@" + snapshot}, items)
+
+			err = store.Delete(ctx, "index")
+			require.Error(t, err)
+			assert.Equal(t, http.StatusConflict, ErrorFromError(err).StatusCode())
+		})
+	}
+}
+
+func TestLocalStoreVersions(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store.SetVersioning(true)
+
+			for _, content := range []string{"a", "b", "c"} {
+				_, err := store.Put(ctx, "index", NewBytesReaderCloser([]byte(content)))
+				require.NoError(t, err)
+			}
+
+			var versions []string
+			var current string
+			for item, err := range store.ListAll(ctx, WithListVersions()) {
+				require.NoError(t, err)
+				require.NotNil(t, item.VersionID)
+				versions = append(versions, *item.VersionID)
+				if item.IsCurrentVersion != nil && *item.IsCurrentVersion {
+					current = *item.VersionID
+				}
+			}
+			require.Len(t, versions, 3)
+			assert.Equal(t, versions[0], current)
+
+			// the prior versions are listed oldest first
+			rr, err := store.Reader(ctx, "index", WithVersionID(versions[1]))
+			require.NoError(t, err)
+			assert.Equal(t, "a", readAll(t, rr))
+
+			wr, err := store.PromoteVersion(ctx, "index", versions[1])
+			require.NoError(t, err)
+			require.NotNil(t, wr.VersionID)
+
+			// the response does not refer to the stored blob
+			promotedID := *wr.VersionID
+			*wr.VersionID = "changed"
+			found := false
+			for item, err := range store.ListAll(ctx, WithListVersions()) {
+				require.NoError(t, err)
+				found = found || *item.VersionID == promotedID
+			}
+			assert.True(t, found)
+			*wr.VersionID = promotedID
+
+			rr, err = store.Reader(ctx, "index")
+			require.NoError(t, err)
+			assert.Equal(t, "a", readAll(t, rr))
+			rr, err = store.Reader(ctx, "index", WithVersionID(current))
+			require.NoError(t, err)
+			assert.Equal(t, "c", readAll(t, rr))
+
+			// deleted blobs are kept as prior versions
+			require.NoError(t, store.Delete(ctx, "index"))
+			_, err = store.Reader(ctx, "index")
+			assert.Equal(t, http.StatusNotFound, ErrorFromError(err).StatusCode())
+			rr, err = store.Reader(ctx, "index", WithVersionID(*wr.VersionID))
+			require.NoError(t, err)
+			assert.Equal(t, "a", readAll(t, rr))
+
+			count := 0
+			for item, err := range store.ListAll(ctx, WithListVersions()) {
+				require.NoError(t, err)
+				assert.Nil(t, item.IsCurrentVersion)
+				count++
+			}
+			assert.Equal(t, 4, count)
+		})
+	}
+}
@@ -4,8 +4,10 @@ package azblob
 
 import (
 	"errors"
-	"fmt"
+	"sync"
+	"sync/atomic"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
@@ -25,7 +27,13 @@ type Storer struct {
 	Subscription  string
 	Container     string
 
-	credential      *SharedKeyCredential
+	credential *SharedKeyCredential
+	// tokenCredential is the azure ad credential, if any, used to sign user
+	// delegation SAS
+	tokenCredential azcore.TokenCredential
+	// sas is the query string of the shared access signature which authorizes
+	// a storer with neither, see NewFromConnectionString
+	sas             string
 	rootURL         string
 	containerURL    string
 	containerClient *ContainerClient
@@ -35,6 +43,22 @@ type Storer struct {
 	setReadResponseScannedStatus ReadResponseScannedStatus
 
 	startSpanFromContext startSpanFromContextFunc
+
+	// emulateTagConditions is set for backends which don't support x-ms-if-tags
+	emulateTagConditions bool
+
+	// containerExists caches the result of checkContainer
+	containerExists    atomic.Bool
+	skipContainerCheck bool
+
+	// delegationKey caches the user delegation key which signs SAS
+	delegationKeyMu sync.Mutex
+	delegationKey   *userDelegationKey
+
+	// clientOptions and retryOptions configure the azure sdk clients, see
+	// WithClientOptions and WithRetryPolicy
+	clientOptions ClientOptions
+	retryOptions  *RetryOptions
 }
 
 type StorerOption func(*Storer)
@@ -51,6 +75,16 @@ func WithStorerSpanFromContext(s startSpanFromContextFunc) StorerOption {
 	}
 }
 
+// WithEmulatedTagConditions makes Reader check the tags required by WithTags
+// by reading the tags before reading the blob, rather than by making the tags a
+// condition of the read. This is only for backends that do not support tag
+// conditions (x-ms-if-tags), as the check is not atomic with the read.
+func WithEmulatedTagConditions() StorerOption {
+	return func(a *Storer) {
+		a.emulateTagConditions = true
+	}
+}
+
 // New returns new az blob read/write object
 func New(
 	log Logger,
@@ -83,7 +117,7 @@ func New(
 		log.Infof("Storer: %v", ErrUnspecifiedContainer)
 		return nil, ErrUnspecifiedContainer
 	}
-	azp := Storer{
+	azp := &Storer{
 		AccountName:   accountName,
 		ResourceGroup: resourceGroup,
 		Subscription:  subscription,
@@ -92,29 +126,8 @@ func New(
 		rootURL:       rootURL,
 		log:           log,
 	}
-	for _, option := range options {
-		option(&azp)
-	}
-
-	azp.containerURL = fmt.Sprintf(
-		"%s%s",
-		rootURL,
-		container,
-	)
-	azp.serviceClient, err = azStorageBlob.NewServiceClientWithSharedKey(
-		rootURL,
-		credential,
-		nil,
-	)
-	if err != nil {
-		log.Infof("unable to create serviceclient %s: %v", azp.containerURL, err)
+	if err = azp.connect(options); err != nil {
 		return nil, err
 	}
-	azp.containerClient, err = azp.serviceClient.NewContainerClient(container)
-	if err != nil {
-		log.Infof("unable to create containerclient %s: %v", container, err)
-		return nil, err
-	}
-
-	return &azp, nil
+	return azp, nil
 }
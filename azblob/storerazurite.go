@@ -2,7 +2,6 @@ package azblob
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"strings"
 
@@ -61,7 +60,7 @@ func (s *Storer) GetServiceClient() *azStorageBlob.ServiceClient {
 // emulator It uses the well known account name and key by default. If
 // overriding, be sure to also configure AZURITE_ACCOUNTS for the emulator
 // See: https://learn.microsoft.com/en-us/azure/storage/common/storage-use-azurite
-func NewDev(cfg DevConfig, container string) (*Storer, error) {
+func NewDev(cfg DevConfig, container string, options ...StorerOption) (*Storer, error) {
 	logger.Sugar.Infof(
 		"Attempt environment auth with accountName: %s, for container: %s",
 		cfg.AccountName, container,
@@ -87,25 +86,9 @@ func NewDev(cfg DevConfig, container string) (*Storer, error) {
 		rootURL:       cfg.URL,
 		log:           logger.Sugar,
 	}
-
-	azp.containerURL = fmt.Sprintf(
-		"%s%s", cfg.URL, container,
-	)
-	azp.serviceClient, err = azStorageBlob.NewServiceClientWithSharedKey(
-		cfg.URL,
-		cred,
-		nil,
-	)
-	if err != nil {
-		logger.Sugar.Infof("unable to create serviceclient %s: %v", azp.containerURL, err)
+	if err = azp.connect(options); err != nil {
 		return nil, err
 	}
-	azp.containerClient, err = azp.serviceClient.NewContainerClient(container)
-	if err != nil {
-		logger.Sugar.Infof("unable to create containerclient %s: %v", container, err)
-		return nil, err
-	}
-
 	return azp, nil
 }
 
@@ -0,0 +1,285 @@
+// Package tagfilter builds, parses and evaluates azure blob index tag filter
+// expressions.
+//
+// The same syntax is used for the where clause of Find Blobs by Tags (see
+// azblob.Storer.FilteredList) and for the x-ms-if-tags conditional header (see
+// azblob.WithWhereTags). Find Blobs by Tags supports a restricted form of the
+// syntax, see CheckFind.
+//
+// See: https://learn.microsoft.com/en-us/azure/storage/blobs/storage-manage-find-blobs
+package tagfilter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Op is a tag comparison operator
+type Op string
+
+const (
+	OpEq Op = "="
+	OpNe Op = "<>"
+	OpGt Op = ">"
+	OpGe Op = ">="
+	OpLt Op = "<"
+	OpLe Op = "<="
+)
+
+const (
+	// ContainerKey is the pseudo tag which matches the container name. When
+	// evaluating expressions which refer to it, the caller must add the
+	// container name to the tags under this key.
+	ContainerKey = "@container"
+
+	maxKeyLength   = 128
+	maxValueLength = 256
+	// maxFindTags is the maximum number of distinct tags Find Blobs by Tags
+	// accepts in a single filter
+	maxFindTags = 10
+)
+
+var (
+	ErrEmpty             = errors.New("tagfilter: empty expression")
+	ErrBadKey            = errors.New("tagfilter: invalid tag key")
+	ErrBadValue          = errors.New("tagfilter: invalid tag value")
+	ErrNotSupportedFind  = errors.New("tagfilter: expression is not supported by find blobs by tags")
+	ErrNotSupportedWhere = errors.New("tagfilter: expression is not supported in a tag condition")
+)
+
+// Expr is a tag filter expression
+type Expr interface {
+	// String returns the expression in azure tag filter syntax
+	String() string
+	// Match evaluates the expression against the tags of a single blob. As for
+	// azure, values are compared lexicographically and a comparison against a
+	// tag the blob does not have is false.
+	Match(tags map[string]string) bool
+}
+
+// Comparison compares a single tag with a value
+type Comparison struct {
+	Key   string
+	Op    Op
+	Value string
+}
+
+// AndExpr is true if all of its terms are true
+type AndExpr []Expr
+
+// OrExpr is true if any of its terms are true
+type OrExpr []Expr
+
+func Eq(key, value string) *Comparison { return &Comparison{Key: key, Op: OpEq, Value: value} }
+func Ne(key, value string) *Comparison { return &Comparison{Key: key, Op: OpNe, Value: value} }
+func Gt(key, value string) *Comparison { return &Comparison{Key: key, Op: OpGt, Value: value} }
+func Ge(key, value string) *Comparison { return &Comparison{Key: key, Op: OpGe, Value: value} }
+func Lt(key, value string) *Comparison { return &Comparison{Key: key, Op: OpLt, Value: value} }
+func Le(key, value string) *Comparison { return &Comparison{Key: key, Op: OpLe, Value: value} }
+
+// Container restricts Find Blobs by Tags to a single container
+func Container(name string) *Comparison { return Eq(ContainerKey, name) }
+
+func And(terms ...Expr) AndExpr { return AndExpr(terms) }
+func Or(terms ...Expr) OrExpr   { return OrExpr(terms) }
+
+// FromMap returns an expression which requires each of the tags to have
+// exactly the given value. The terms are ordered by key so that the result is
+// deterministic.
+func FromMap(tags map[string]string) AndExpr {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expr := make(AndExpr, 0, len(keys))
+	for _, k := range keys {
+		expr = append(expr, Eq(k, tags[k]))
+	}
+	return expr
+}
+
+func (c *Comparison) String() string {
+	key := c.Key
+	if key != ContainerKey {
+		key = `"` + key + `"`
+	}
+	return fmt.Sprintf("%s %s '%s'", key, c.Op, c.Value)
+}
+
+func (c *Comparison) Match(tags map[string]string) bool {
+	v, ok := tags[c.Key]
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case OpEq:
+		return v == c.Value
+	case OpNe:
+		return v != c.Value
+	case OpGt:
+		return v > c.Value
+	case OpGe:
+		return v >= c.Value
+	case OpLt:
+		return v < c.Value
+	case OpLe:
+		return v <= c.Value
+	default:
+		return false
+	}
+}
+
+func (a AndExpr) String() string { return joinTerms(a, " AND ") }
+
+func (a AndExpr) Match(tags map[string]string) bool {
+	for _, term := range a {
+		if !term.Match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o OrExpr) String() string { return joinTerms(o, " OR ") }
+
+func (o OrExpr) Match(tags map[string]string) bool {
+	for _, term := range o {
+		if term.Match(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinTerms renders the terms of a compound expression. Nested compound terms
+// are parenthesised, single comparisons never are. So a conjunction of
+// comparisons is always valid for Find Blobs by Tags.
+func joinTerms(terms []Expr, sep string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		s := term.String()
+		switch t := term.(type) {
+		case AndExpr:
+			if len(t) > 1 {
+				s = "(" + s + ")"
+			}
+		case OrExpr:
+			if len(t) > 1 {
+				s = "(" + s + ")"
+			}
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, sep)
+}
+
+// validKeyChar returns true for the characters azure permits in tag keys and
+// values.
+func validKeyChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune(" +-./:=_", r)
+}
+
+// Validate checks the keys and values of every comparison in the expression
+// against the azure limits on tag keys and values.
+func Validate(e Expr) error {
+	return walk(e, func(c *Comparison) error {
+		if c.Key != ContainerKey {
+			if len(c.Key) == 0 || len(c.Key) > maxKeyLength || strings.IndexFunc(c.Key, func(r rune) bool { return !validKeyChar(r) }) >= 0 {
+				return fmt.Errorf("%w: %q", ErrBadKey, c.Key)
+			}
+		}
+		if len(c.Value) > maxValueLength || strings.IndexFunc(c.Value, func(r rune) bool { return !validKeyChar(r) }) >= 0 {
+			return fmt.Errorf("%w: %q", ErrBadValue, c.Value)
+		}
+		switch c.Op {
+		case OpEq, OpNe, OpGt, OpGe, OpLt, OpLe:
+		default:
+			return fmt.Errorf("tagfilter: invalid operator %q", c.Op)
+		}
+		return nil
+	})
+}
+
+// CheckFind returns an error if the expression can't be used with Find Blobs
+// by Tags. Only conjunctions of comparisons, on at most 10 distinct tags, are
+// supported. The not equals operator is not supported, and the container may
+// only be compared for equality.
+func CheckFind(e Expr) error {
+	if err := Validate(e); err != nil {
+		return err
+	}
+	var terms []Expr
+	switch t := e.(type) {
+	case *Comparison:
+		terms = []Expr{t}
+	case AndExpr:
+		terms = t
+	default:
+		return fmt.Errorf("%w: %s", ErrNotSupportedFind, e)
+	}
+	keys := map[string]bool{}
+	for _, term := range terms {
+		c, ok := term.(*Comparison)
+		if !ok || c.Op == OpNe || (c.Key == ContainerKey && c.Op != OpEq) {
+			return fmt.Errorf("%w: %s", ErrNotSupportedFind, term)
+		}
+		if c.Key != ContainerKey {
+			keys[c.Key] = true
+		}
+	}
+	if len(keys) > maxFindTags {
+		return fmt.Errorf("%w: more than %d tags", ErrNotSupportedFind, maxFindTags)
+	}
+	return nil
+}
+
+// CheckWhere returns an error if the expression can't be used as a tag
+// condition (x-ms-if-tags). The full syntax is supported except for the
+// container pseudo tag.
+func CheckWhere(e Expr) error {
+	if err := Validate(e); err != nil {
+		return err
+	}
+	return walk(e, func(c *Comparison) error {
+		if c.Key == ContainerKey {
+			return fmt.Errorf("%w: %s", ErrNotSupportedWhere, c)
+		}
+		return nil
+	})
+}
+
+// walk calls fn for each comparison in the expression
+func walk(e Expr, fn func(c *Comparison) error) error {
+	switch t := e.(type) {
+	case *Comparison:
+		return fn(t)
+	case AndExpr:
+		if len(t) == 0 {
+			return ErrEmpty
+		}
+		for _, term := range t {
+			if err := walk(term, fn); err != nil {
+				return err
+			}
+		}
+	case OrExpr:
+		if len(t) == 0 {
+			return ErrEmpty
+		}
+		for _, term := range t {
+			if err := walk(term, fn); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("tagfilter: unknown expression type %T", e)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package tagfilter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrSyntax = errors.New("tagfilter: syntax error")
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokKey
+	tokValue
+	tokOp
+	tokAnd
+	tokOr
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Parse parses an azure tag filter expression.
+//
+// Keys may be double quoted or bare, values must be single quoted. For example
+//
+//	"firstindex">'0000000000000000'
+//	lastid > '018e84dbbb6513a6'
+//	@container='zoo' AND cat='tiger' AND penguin='emperorpenguin'
+//	("owner" = 'a' OR "owner" = 'b') AND "status" <> 'deleted'
+//
+// The full syntax accepted for tag conditions is parsed. Use CheckFind or
+// CheckWhere to check the result is acceptable for a specific use.
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, t.text, t.pos)
+	}
+	if err = Validate(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ParseFind parses a filter and checks it is acceptable to Find Blobs by Tags
+func ParseFind(s string) (Expr, error) {
+	e, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckFind(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ParseWhere parses a filter and checks it is acceptable as a tag condition
+func ParseWhere(s string) (Expr, error) {
+	e, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckWhere(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("+-./:_@", c) >= 0
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated quote at %d", ErrSyntax, i)
+			}
+			kind := tokKey
+			if c == '\'' {
+				kind = tokValue
+			}
+			tokens = append(tokens, token{kind: kind, text: s[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case c == '=':
+			tokens = append(tokens, token{kind: tokOp, text: "=", pos: i})
+			i++
+		case c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				op += string(s[i+1])
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		case isBareKeyChar(c):
+			start := i
+			for i < len(s) && isBareKeyChar(s[i]) {
+				i++
+			}
+			word := s[start:i]
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{kind: tokAnd, text: word, pos: start})
+			case "OR":
+				tokens = append(tokens, token{kind: tokOr, text: word, pos: start})
+			default:
+				tokens = append(tokens, token{kind: tokKey, text: word, pos: start})
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, c, i)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+// parseOr parses: and { OR and }
+func (p *parser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	terms := OrExpr{}
+	terms = appendOr(terms, first)
+	for p.peek().kind == tokOr {
+		p.take()
+		term, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = appendOr(terms, term)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+// parseAnd parses: primary { AND primary }
+func (p *parser) parseAnd() (Expr, error) {
+	first, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	terms := AndExpr{}
+	terms = appendAnd(terms, first)
+	for p.peek().kind == tokAnd {
+		p.take()
+		term, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		terms = appendAnd(terms, term)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+// parsePrimary parses: "(" expr ")" | key op value
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.take()
+	switch t.kind {
+	case tokLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.take(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("%w: expected ')' at %d", ErrSyntax, closing.pos)
+		}
+		return e, nil
+	case tokKey:
+		op := p.take()
+		if op.kind != tokOp {
+			return nil, fmt.Errorf("%w: expected operator at %d", ErrSyntax, op.pos)
+		}
+		value := p.take()
+		if value.kind != tokValue {
+			return nil, fmt.Errorf("%w: expected single quoted value at %d", ErrSyntax, value.pos)
+		}
+		return &Comparison{Key: t.text, Op: Op(op.text), Value: value.text}, nil
+	default:
+		return nil, fmt.Errorf("%w: expected tag key or '(' at %d", ErrSyntax, t.pos)
+	}
+}
+
+// appendAnd flattens parenthesised conjunctions, so that "(a AND b) AND c"
+// parses the same as "a AND b AND c"
+func appendAnd(terms AndExpr, term Expr) AndExpr {
+	if nested, ok := term.(AndExpr); ok {
+		return append(terms, nested...)
+	}
+	return append(terms, term)
+}
+
+// appendOr flattens parenthesised disjunctions
+func appendOr(terms OrExpr, term Expr) OrExpr {
+	if nested, ok := term.(OrExpr); ok {
+		return append(terms, nested...)
+	}
+	return append(terms, term)
+}
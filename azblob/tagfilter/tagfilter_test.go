@@ -0,0 +1,120 @@
+package tagfilter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   string
+		tags   map[string]string
+		match  bool
+	}{
+		{
+			name:   "quoted key",
+			filter: `"firstindex">'0000000000000000'`,
+			want:   `"firstindex" > '0000000000000000'`,
+			tags:   map[string]string{"firstindex": "0000000000000001"},
+			match:  true,
+		},
+		{
+			name:   "bare key",
+			filter: `lastid > '018e84dbbb6513a6'`,
+			want:   `"lastid" > '018e84dbbb6513a6'`,
+			tags:   map[string]string{"lastid": "018e84dbbb6513a5"},
+			match:  false,
+		},
+		{
+			name:   "container",
+			filter: `@container='zoo' AND cat='tiger' AND penguin='emperorpenguin'`,
+			want:   `@container = 'zoo' AND "cat" = 'tiger' AND "penguin" = 'emperorpenguin'`,
+			tags:   map[string]string{ContainerKey: "zoo", "cat": "tiger", "penguin": "emperorpenguin"},
+			match:  true,
+		},
+		{
+			name:   "or and precedence",
+			filter: `"owner" = 'a' OR "owner" = 'b' and "status" <> 'deleted'`,
+			want:   `"owner" = 'a' OR ("owner" = 'b' AND "status" <> 'deleted')`,
+			tags:   map[string]string{"owner": "b", "status": "deleted"},
+			match:  false,
+		},
+		{
+			name:   "parentheses",
+			filter: `("owner" = 'a' OR "owner" = 'b') AND "status" <> 'deleted'`,
+			want:   `("owner" = 'a' OR "owner" = 'b') AND "status" <> 'deleted'`,
+			tags:   map[string]string{"owner": "b", "status": "live"},
+			match:  true,
+		},
+		{
+			name:   "missing tag",
+			filter: `"status" <> 'deleted'`,
+			want:   `"status" <> 'deleted'`,
+			tags:   map[string]string{},
+			match:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, e.String())
+			assert.Equal(t, tt.match, e.Match(tt.tags))
+
+			// the rendered form must parse back to the same expression
+			again, err := Parse(e.String())
+			require.NoError(t, err)
+			assert.Equal(t, e, again)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		err    error
+	}{
+		{name: "empty", filter: "", err: ErrSyntax},
+		{name: "unquoted value", filter: `owner = a`, err: ErrSyntax},
+		{name: "unterminated", filter: `owner = 'a`, err: ErrSyntax},
+		{name: "missing operator", filter: `owner 'a'`, err: ErrSyntax},
+		{name: "unbalanced", filter: `(owner = 'a'`, err: ErrSyntax},
+		{name: "trailing", filter: `owner = 'a' AND`, err: ErrSyntax},
+		{name: "bad key", filter: `"own!er" = 'a'`, err: ErrBadKey},
+		{name: "bad value", filter: `owner = 'a!'`, err: ErrBadValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.filter)
+			assert.True(t, errors.Is(err, tt.err), "got %v", err)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	find := And(Container("zoo"), Gt("firstindex", "0000000000000000"))
+	assert.NoError(t, CheckFind(find))
+	assert.ErrorIs(t, CheckWhere(find), ErrNotSupportedWhere)
+
+	where := Or(Eq("owner", "a"), Ne("status", "deleted"))
+	assert.NoError(t, CheckWhere(where))
+	assert.ErrorIs(t, CheckFind(where), ErrNotSupportedFind)
+	assert.ErrorIs(t, CheckFind(Ne("status", "deleted")), ErrNotSupportedFind)
+	assert.ErrorIs(t, CheckFind(And()), ErrEmpty)
+
+	_, err := ParseFind(`"owner" = 'a' OR "owner" = 'b'`)
+	assert.ErrorIs(t, err, ErrNotSupportedFind)
+}
+
+func TestFromMap(t *testing.T) {
+	e := FromMap(map[string]string{"tenant": "t1", "owner": "o1"})
+	assert.Equal(t, `"owner" = 'o1' AND "tenant" = 't1'`, e.String())
+	assert.True(t, e.Match(map[string]string{"tenant": "t1", "owner": "o1", "other": "x"}))
+	assert.False(t, e.Match(map[string]string{"tenant": "t1"}))
+}
@@ -0,0 +1,254 @@
+package azblob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// defaultRehydrationPollInterval is the interval at which
+	// WaitForRehydration reads the blob properties if no interval is given.
+	// Standard priority rehydration typically takes hours.
+	defaultRehydrationPollInterval = time.Minute
+)
+
+// AccessTier is the access tier of a block blob, which trades the cost of
+// storage against the cost, and latency, of access.
+type AccessTier = azStorageBlob.AccessTier
+
+const (
+	AccessTierHot  = azStorageBlob.AccessTierHot
+	AccessTierCool = azStorageBlob.AccessTierCool
+	// AccessTierCold requires service version 2021-12-02 or later. SetTier
+	// uses the version of the sdk, which is earlier, so the cold tier can only
+	// be set by WithAccessTier on a local store or by SetTierBatch.
+	AccessTierCold    AccessTier = "Cold"
+	AccessTierArchive            = azStorageBlob.AccessTierArchive
+)
+
+// ArchiveStatus is set while an archived blob is being rehydrated to an online
+// tier
+type ArchiveStatus = azStorageBlob.ArchiveStatus
+
+const (
+	ArchiveStatusRehydratePendingToHot                = azStorageBlob.ArchiveStatusRehydratePendingToHot
+	ArchiveStatusRehydratePendingToCool               = azStorageBlob.ArchiveStatusRehydratePendingToCool
+	ArchiveStatusRehydratePendingToCold ArchiveStatus = "rehydrate-pending-to-cold"
+)
+
+// RehydratePriority is the priority with which an archived blob is rehydrated
+type RehydratePriority = azStorageBlob.RehydratePriority
+
+const (
+	RehydratePriorityStandard = azStorageBlob.RehydratePriorityStandard
+	RehydratePriorityHigh     = azStorageBlob.RehydratePriorityHigh
+)
+
+// validAccessTier returns true for the tiers supported by standard storage
+// accounts
+func validAccessTier(tier AccessTier) bool {
+	switch tier {
+	case AccessTierHot, AccessTierCool, AccessTierCold, AccessTierArchive:
+		return true
+	default:
+		return false
+	}
+}
+
+// accessTierOption returns the tier set by WithAccessTier, or nil for the
+// account default
+func accessTierOption(options *StorerOptions) *AccessTier {
+	if options.accessTier == "" {
+		return nil
+	}
+	tier := options.accessTier
+	return &tier
+}
+
+// rehydrateStatus returns the archive status of a blob which is being
+// rehydrated to tier
+func rehydrateStatus(tier AccessTier) ArchiveStatus {
+	return ArchiveStatus("rehydrate-pending-to-" + strings.ToLower(string(tier)))
+}
+
+// SetTier sets the access tier of a block blob.
+//
+// Moving a blob to AccessTierArchive takes effect immediately, the content
+// can then not be read until the blob is rehydrated. Moving an archived blob
+// to an online tier starts the rehydration, which takes up to 15 hours for
+// RehydratePriorityStandard. Until then the blob remains archived and its
+// ArchiveStatus reports the pending rehydration, see WaitForRehydration.
+//
+// Supported options are WithLeaseID and WithRehydratePriority.
+func (azp *Storer) SetTier(
+	ctx context.Context,
+	identity string,
+	tier AccessTier,
+	opts ...Option,
+) error {
+	azp.log.Debugf("SetTier %s: %s", identity, tier)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blobClient, err := azp.blobClient(identity, options)
+	if err != nil {
+		return err
+	}
+	tierOptions := &azStorageBlob.BlobSetTierOptions{}
+	if options.leaseID != "" {
+		tierOptions.LeaseAccessConditions = &azStorageBlob.LeaseAccessConditions{LeaseID: &options.leaseID}
+	}
+	if options.rehydratePriority != "" {
+		tierOptions.RehydratePriority = &options.rehydratePriority
+	}
+	_, err = blobClient.SetTier(ctx, tier, tierOptions)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
+
+// WaitForRehydration reads the properties of the identified blob every
+// interval until it is no longer being rehydrated. The last properties read
+// are returned, the AccessTier reports whether the blob was rehydrated or
+// whether it was never archived. The wait is bounded only by ctx. An interval
+// of zero uses a default of one minute. The options are passed to Reader.
+func WaitForRehydration(
+	ctx context.Context,
+	r Reader,
+	identity string,
+	interval time.Duration,
+	opts ...Option,
+) (*ReaderResponse, error) {
+
+	if interval <= 0 {
+		interval = defaultRehydrationPollInterval
+	}
+	opts = append(opts[:len(opts):len(opts)], WithGetMetadata(OnlyMetadata))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rr, err := r.Reader(ctx, identity, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if !rr.Rehydrating() {
+			return rr, nil
+		}
+		select {
+		case <-ctx.Done():
+			return rr, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetRehydrationDelay sets the time it takes to rehydrate an archived blob,
+// the default is zero. Azure takes hours.
+func (s *localStore) SetRehydrationDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rehydrationDelay = delay
+}
+
+// rehydrated returns the blob as it is at the time now, which differs from b
+// only if a pending rehydration has completed
+func rehydrated(b *localBlob, now time.Time) *localBlob {
+	if b == nil || b.RehydrateTo == "" || now.Before(b.RehydrateAt) {
+		return b
+	}
+	c := *b
+	c.AccessTier = c.RehydrateTo
+	c.RehydrateTo = ""
+	c.RehydratePriority = ""
+	c.RehydrateAt = time.Time{}
+	return &c
+}
+
+// localAccessTier returns the tier of the blob, which is hot if none was set
+func localAccessTier(b *localBlob) AccessTier {
+	if b.AccessTier == "" {
+		return AccessTierHot
+	}
+	return AccessTier(b.AccessTier)
+}
+
+// SetTier sets the access tier of a local block blob, honouring the same
+// options as Storer.SetTier. As for azure, this does not change the ETag or
+// last modified time of the blob. Rehydration takes the time set by
+// SetRehydrationDelay, regardless of priority.
+func (s *localStore) SetTier(
+	ctx context.Context,
+	identity string,
+	tier AccessTier,
+	opts ...Option,
+) error {
+	s.log.Debugf("SetTier local blob %s: %s", identity, tier)
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if !validAccessTier(tier) {
+		return newStorageError(
+			fmt.Sprintf("invalid access tier %q", tier),
+			http.StatusBadRequest, errCodeInvalidHeaderValue)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return ErrorFromError(err)
+	}
+	existing = rehydrated(existing, now)
+	if existing == nil {
+		return blobNotFoundError(identity)
+	}
+	if existing.BlobType != "" {
+		return newStorageError(
+			fmt.Sprintf("the blob type is invalid for this operation: %s", identity),
+			http.StatusConflict, errCodeInvalidBlobType)
+	}
+	if err = checkLease(existing, options.leaseID, now, false); err != nil {
+		return err
+	}
+
+	b := *existing
+	switch {
+	case b.RehydrateTo != "":
+		// azure only allows the priority of a pending rehydration to be raised
+		if tier == AccessTierArchive || options.rehydratePriority != RehydratePriorityHigh {
+			return newStorageError(
+				fmt.Sprintf("this operation is not permitted because the blob is being rehydrated: %s", identity),
+				http.StatusConflict, errCodeBlobBeingRehydrated)
+		}
+		b.RehydratePriority = string(RehydratePriorityHigh)
+	case localAccessTier(&b) == AccessTierArchive && tier != AccessTierArchive:
+		priority := options.rehydratePriority
+		if priority == "" {
+			priority = RehydratePriorityStandard
+		}
+		b.RehydrateTo = string(tier)
+		b.RehydratePriority = string(priority)
+		b.RehydrateAt = now.Add(s.rehydrationDelay)
+	default:
+		b.AccessTier = string(tier)
+	}
+	if err = s.backend.store(&b); err != nil {
+		return ErrorFromError(err)
+	}
+	return nil
+}
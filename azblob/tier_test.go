@@ -0,0 +1,97 @@
+package azblob
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreAccessTier(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			store.now = func() time.Time { return now }
+			store.SetRehydrationDelay(time.Hour)
+
+			_, err := store.Put(ctx, "cool", NewBytesReaderCloser([]byte("VALUE")), WithAccessTier(AccessTierCool))
+			require.NoError(t, err)
+			rr, err := store.Reader(ctx, "cool", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierCool, rr.AccessTier)
+
+			_, err = store.Write(ctx, "blob", bytes.NewReader([]byte("VALUE")))
+			require.NoError(t, err)
+			rr, err = store.Reader(ctx, "blob", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierHot, rr.AccessTier)
+			etag := *rr.ETag
+
+			err = store.SetTier(ctx, "blob", AccessTier("Lukewarm"))
+			assert.Equal(t, http.StatusBadRequest, ErrorFromError(err).StatusCode())
+
+			require.NoError(t, store.SetTier(ctx, "blob", AccessTierArchive))
+			_, err = store.Reader(ctx, "blob")
+			assert.Equal(t, errCodeBlobArchived, ErrorFromError(err).StorageErrorCode())
+			rr, err = store.Reader(ctx, "blob", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.Equal(t, AccessTierArchive, rr.AccessTier)
+			assert.Equal(t, etag, *rr.ETag)
+			assert.False(t, rr.Rehydrating())
+
+			require.NoError(t, store.SetTier(ctx, "blob", AccessTierHot))
+			rr, err = store.Reader(ctx, "blob", WithGetMetadata(OnlyMetadata))
+			require.NoError(t, err)
+			assert.True(t, rr.Rehydrating())
+			assert.Equal(t, AccessTierArchive, rr.AccessTier)
+			assert.Equal(t, ArchiveStatusRehydratePendingToHot, rr.ArchiveStatus)
+			assert.Equal(t, RehydratePriorityStandard, rr.RehydratePriority)
+
+			// only the priority of a pending rehydration can be changed
+			err = store.SetTier(ctx, "blob", AccessTierCool)
+			assert.Equal(t, errCodeBlobBeingRehydrated, ErrorFromError(err).StorageErrorCode())
+			require.NoError(t, store.SetTier(ctx, "blob", AccessTierHot, WithRehydratePriority(RehydratePriorityHigh)))
+
+			waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			rr, err = WaitForRehydration(waitCtx, store, "blob", time.Millisecond)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			require.NotNil(t, rr)
+			assert.Equal(t, RehydratePriorityHigh, rr.RehydratePriority)
+
+			now = now.Add(2 * time.Hour)
+			rr, err = WaitForRehydration(ctx, store, "blob", time.Millisecond)
+			require.NoError(t, err)
+			assert.False(t, rr.Rehydrating())
+			assert.Equal(t, AccessTierHot, rr.AccessTier)
+			rr, err = store.Reader(ctx, "blob")
+			require.NoError(t, err)
+			assert.Equal(t, "VALUE", readAll(t, rr))
+		})
+	}
+}
+
+func TestAccessTierOption(t *testing.T) {
+
+	options := &StorerOptions{}
+	assert.Nil(t, accessTierOption(options))
+
+	WithAccessTier(AccessTierCold)(options)
+	tier := accessTierOption(options)
+	require.NotNil(t, tier)
+	assert.Equal(t, AccessTierCold, *tier)
+
+	assert.Equal(t, ArchiveStatus("rehydrate-pending-to-hot"), rehydrateStatus(AccessTierHot))
+	assert.True(t, validAccessTier(AccessTierArchive))
+	assert.False(t, validAccessTier(AccessTier("Premium")))
+}
@@ -5,17 +5,12 @@ package azblob
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/textproto"
-	"strconv"
-	"time"
 
-	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	mimetype "github.com/gabriel-vasile/mimetype"
 )
 
@@ -27,59 +22,17 @@ const (
 	chunkSize = 2 * 1024 * 1024
 )
 
-func (azp *Storer) checkContainer(ctx context.Context) error {
-	azp.log.Debugf("Checking container URL %s", azp.containerURL)
-	_, err := azp.containerClient.GetProperties(ctx, nil)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
-// setMetadata sets metadata in blob storage
-func (azp *Storer) setMetadata(
-	ctx context.Context,
-	identity string,
-	metadata map[string]string,
-) error {
-	azp.log.Debugf("setMetadata BlockBlob %s: %v", identity, metadata)
-
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	_, err = blobClient.SetMetadata(ctx, metadata, nil)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
-// setTags sets Tags in blob storage
-func (azp *Storer) setTags(
-	ctx context.Context,
-	identity string,
-	tags map[string]string,
-) error {
-	azp.log.Debugf("setTags BlockBlob %s: %v", identity, tags)
-
-	blobClient, err := azp.containerClient.NewBlobClient(identity)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	_, err = blobClient.SetTags(
-		ctx,
-		&azStorageBlob.BlobSetTagsOptions{
-			TagsMap: tags,
-		},
-	)
-	if err != nil {
-		return ErrorFromError(err)
-	}
-	return nil
-}
-
 // Write writes to blob from io.Reader.
+//
+// The existence of the container is checked before the content is uploaded,
+// see WithoutContainerCheck.
+//
+// The content is staged in blocks which are then committed. The ETag, since
+// and tag conditions are checked when the blocks are committed, and the
+// metadata and tags are set by the same commit. So, for example,
+// WithEtagNoneMatch("*") creates the blob only if it does not exist, and a blob
+// is never visible without its metadata and tags. Note that a failed condition
+// is only detected after all of the content has been uploaded.
 func (azp *Storer) Write(
 	ctx context.Context,
 	identity string,
@@ -98,29 +51,8 @@ func (azp *Storer) Write(
 		opt(options)
 	}
 
-	if options.etagCondition != EtagNotUsed {
-		return nil, errors.New("etag conditions are not supported on streaming uploads")
-	}
-
-	wr, err := azp.writeStream(ctx, identity, source, options.leaseID)
-	if err != nil {
-		return nil, err
-	}
-	if options.metadata != nil {
-		// upload metadata
-		err = azp.setMetadata(ctx, identity, options.metadata)
-		if err != nil {
-			return nil, err
-		}
-	}
-	if options.tags != nil {
-		// upload tags
-		err = azp.setTags(ctx, identity, options.tags)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return wr, nil
+	wr, err := writeReader(ctx, azp, identity, source, options)
+	return wr, azp.containerChecked(err)
 }
 
 // Write writes to blob from http request.
@@ -141,61 +73,67 @@ func (azp *Storer) WriteStream(
 		opt(options)
 	}
 
-	return azp.streamReader(ctx, identity, source, options)
+	wr, err := streamReader(ctx, azp.log, azp, identity, source, options)
+	return wr, azp.containerChecked(err)
 }
 
-func (azp *Storer) writeStream(
+// writeReader stages source, then commits it with the metadata and tags. It is
+// the implementation of Write shared by the BlobStore implementations.
+func writeReader(
 	ctx context.Context,
+	azp blockWriter,
 	identity string,
-	reader io.Reader,
-	leaseID string,
+	source io.Reader,
+	options *StorerOptions,
 ) (*WriteResponse, error) {
 
-	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
-	if err != nil {
-		azp.log.Infof("Cannot get block blob client blob: %v", err)
-		return nil, ErrorFromError(err)
-	}
-	blobAccessConditions := azStorageBlob.BlobAccessConditions{
-		LeaseAccessConditions:    &azStorageBlob.LeaseAccessConditions{},
-		ModifiedAccessConditions: &azStorageBlob.ModifiedAccessConditions{},
-	}
-	if leaseID != "" {
-		blobAccessConditions.LeaseAccessConditions.LeaseID = &leaseID
+	hashAlgorithm := options.writeHashAlgorithm()
+	var uploadData *hashingReader
+	if hashAlgorithm != "" {
+		var err error
+		uploadData, err = newHashingReader(hashAlgorithm, source)
+		if err != nil {
+			return nil, err
+		}
+		source = uploadData
 	}
 
-	// Sream uploading does not support setting tags because the pages are
-	// uploaded in parallel and the tags can only be set once those pages block
-	// ids are commited. Use putBlob if you want this behaviour.
-	r, err := blockBlobClient.UploadStream(
-		ctx,
-		reader,
-		azStorageBlob.UploadStreamOptions{
-			BufferSize:           chunkSize,
-			MaxBuffers:           3,
-			BlobAccessConditions: &blobAccessConditions,
-		},
-	)
+	blockIDs, err := stageReader(ctx, azp, identity, newUploadID(), source, options.leaseID)
 	if err != nil {
-		azp.log.Infof("Cannot upload blob: %v", err)
-		return nil, ErrorFromError(err)
+		return nil, err
+	}
 
+	var digest *contentDigest
+	commitOptions := *options
+	if uploadData != nil {
+		d := uploadData.digest(hashAlgorithm)
+		digest = &d
+		commitOptions.metadata = d.metadata(options.metadata)
+	}
+	wr, err := azp.commitBlockList(ctx, identity, blockIDs, &commitOptions)
+	if err != nil {
+		return nil, err
 	}
-	return uploadStreamWriteResponse(r), nil
+	if digest != nil {
+		digest.setResponse(wr)
+	}
+	return wr, nil
 }
 
-func (azp *Storer) streamReader(
+func streamReader(
 	ctx context.Context,
+	log Logger,
+	azp blockWriter,
 	identity string,
 	r *http.Request,
 	options *StorerOptions,
 ) (*WriteResponse, error) {
 
-	azp.log.Debugf("streamReader: %v", r)
+	log.Debugf("streamReader: %v", r)
 	var err error
 
 	if r.ContentLength < 1 {
-		azp.log.Infof("No content to be uploaded")
+		log.Infof("No content to be uploaded")
 		return nil, NewStatusError(fmt.Sprintf("no content to be uploaded"), http.StatusBadRequest)
 	}
 	// get the multipart reader
@@ -203,12 +141,17 @@ func (azp *Storer) streamReader(
 	// "request Content-Type isn't multipart/form-data"
 	reader, err := r.MultipartReader()
 	if err != nil {
-		azp.log.Infof("failed to get multipart reader: %v", err)
+		log.Infof("failed to get multipart reader: %v", err)
 		return nil, NewStatusError(fmt.Sprintf("failed to get multipart reader: %v", err), http.StatusBadRequest)
 	}
 
 	var resp *WriteResponse
 
+	hashAlgorithm := options.hashAlgorithm
+	if hashAlgorithm == "" {
+		hashAlgorithm = DefaultHashAlgorithm
+	}
+
 	// we don't know how many files to expect but we only accept one - for now that is
 	numFiles := 1
 	for {
@@ -216,7 +159,7 @@ func (azp *Storer) streamReader(
 		part, err := reader.NextPart()
 		if err == io.EOF { //nolint https://github.com/golang/go/issues/39155
 			// we've got all of it just exit
-			azp.log.Debugf("got complete file")
+			log.Debugf("got complete file")
 			break
 		}
 
@@ -226,11 +169,11 @@ func (azp *Storer) streamReader(
 		}
 
 		defer part.Close()
-		azp.log.Debugf("uploading %s", part.FileName())
+		log.Debugf("uploading %s", part.FileName())
 
 		if numFiles > 1 {
 			// we got multiple files - bad request
-			azp.log.Infof("only one file expected")
+			log.Infof("only one file expected")
 			return nil, NewStatusError("only one file expected", http.StatusBadRequest)
 		}
 
@@ -240,10 +183,9 @@ func (azp *Storer) streamReader(
 		}
 
 		// set up our hashing reader
-		hasher := sha256.New()
-		uploadData := &hashingReader{
-			hasher: hasher,
-			part:   part,
+		uploadData, err := newHashingReader(hashAlgorithm, part)
+		if err != nil {
+			return nil, NewStatusError(err.Error(), http.StatusBadRequest)
 		}
 
 		// check we are within the correct size if size limited
@@ -257,7 +199,7 @@ func (azp *Storer) streamReader(
 				return nil, copyErr
 			}
 
-			azp.log.Infof("request file size: %d", count)
+			log.Infof("request file size: %d", count)
 
 			if count >= options.sizeLimit {
 				return nil, NewStatusError("filesize exceeds maximum", http.StatusPaymentRequired)
@@ -286,43 +228,33 @@ func (azp *Storer) streamReader(
 			// catenate the header and remaining data to make it look like a new reader
 			uploadData.part = io.MultiReader(header, uploadData.part)
 		}
-		azp.log.Debugf("Mime type is: %s", mimeType)
+		log.Debugf("Mime type is: %s", mimeType)
 
 		// prepare blob
-		resp, err = azp.writeStream(ctx, identity, uploadData, options.leaseID)
+		blockIDs, err := stageReader(ctx, azp, identity, newUploadID(), uploadData, options.leaseID)
 		if err != nil {
 			return nil, err
 		}
 
 		// get hash, size and mime type from reader
-		var h [sha256.Size]byte
-		uploadData.hasher.Sum(h[:0])
-		resp.HashValue = hex.EncodeToString(h[:])
-		resp.Size = uploadData.size
-		resp.MimeType = mimeType
-		resp.TimestampAccepted = time.Now().UTC().Format(time.RFC3339)
+		digest := uploadData.digest(hashAlgorithm)
 
 		// construct metadata
-		meta := map[string]string{
-			HashKey: resp.HashValue,
-			SizeKey: strconv.FormatInt(resp.Size, 10),
-			MimeKey: resp.MimeType,
-			TimeKey: resp.TimestampAccepted,
-		}
+		meta := digest.metadata(nil)
+		meta[MimeKey] = mimeType
 		for k, v := range options.metadata {
 			meta[k] = v
 		}
-		// upload metadata
-		err = azp.setMetadata(ctx, identity, meta)
-		if err != nil {
-			return nil, err
-		}
 
-		// upload tags
-		err = azp.setTags(ctx, identity, options.tags)
+		// commit the blob, with its metadata and tags
+		commitOptions := *options
+		commitOptions.metadata = meta
+		resp, err = azp.commitBlockList(ctx, identity, blockIDs, &commitOptions)
 		if err != nil {
 			return nil, err
 		}
+		digest.setResponse(resp)
+		resp.MimeType = mimeType
 
 		numFiles++
 	}
@@ -0,0 +1,254 @@
+package azblob
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBlocks is the maximum number of blocks in a block blob
+	maxBlocks = 50000
+)
+
+var (
+	ErrBadUploadID      = errors.New("upload id must be a uuid")
+	ErrBadBlockNumber   = fmt.Errorf("block number must be in the range 0 to %d", maxBlocks-1)
+	ErrMissingBlocks    = errors.New("upload session has missing blocks")
+	ErrNoUploadedBlocks = errors.New("upload session has no uploaded blocks")
+)
+
+// stagedBlock is an uncommitted block
+type stagedBlock struct {
+	ID   string
+	Size int64
+}
+
+// sessionWriter is the set of primitive operations needed by UploadSession
+type sessionWriter interface {
+	blockWriter
+	// uncommittedBlocks returns all the uncommitted blocks for the blob
+	uncommittedBlocks(ctx context.Context, identity string, leaseID string) ([]stagedBlock, error)
+}
+
+// UploadSession uploads a block blob in numbered blocks, which are committed
+// as the blob content in a single, final, operation.
+//
+// The blocks are staged with ids determined by the session id and the block
+// number. So an interrupted upload can be resumed, by any process, using
+// ResumeUpload with the id of the original session. ListUncommitted reports
+// which blocks have already been uploaded.
+//
+// Uncommitted blocks are discarded by azure if they are not committed within a
+// week, or when any other upload to the same blob is committed.
+type UploadSession struct {
+	bw       sessionWriter
+	identity string
+	uploadID string
+	leaseID  string
+}
+
+// UncommittedBlock describes a block uploaded by an UploadSession which has
+// not yet been committed
+type UncommittedBlock struct {
+	Number int
+	Size   int64
+}
+
+// BeginUpload starts a new UploadSession for the identified blob.
+//
+// WithLeaseID is the only supported option. It is required to upload blocks
+// for a leased blob.
+func (azp *Storer) BeginUpload(ctx context.Context, identity string, opts ...Option) (*UploadSession, error) {
+	return beginUpload(azp, identity, newUploadID(), opts...)
+}
+
+// ResumeUpload returns the UploadSession, for the identified blob, which was
+// started with the given id. See UploadSession.ID
+func (azp *Storer) ResumeUpload(ctx context.Context, identity string, uploadID string, opts ...Option) (*UploadSession, error) {
+	return beginUpload(azp, identity, uploadID, opts...)
+}
+
+// BeginUpload starts a new UploadSession, see Storer.BeginUpload
+func (s *localStore) BeginUpload(ctx context.Context, identity string, opts ...Option) (*UploadSession, error) {
+	return beginUpload(s, identity, newUploadID(), opts...)
+}
+
+// ResumeUpload resumes an UploadSession, see Storer.ResumeUpload
+func (s *localStore) ResumeUpload(ctx context.Context, identity string, uploadID string, opts ...Option) (*UploadSession, error) {
+	return beginUpload(s, identity, uploadID, opts...)
+}
+
+func beginUpload(bw sessionWriter, identity string, uploadID string, opts ...Option) (*UploadSession, error) {
+	// The block ids must all be the same length, which is only guaranteed if
+	// the upload ids are.
+	if _, err := uuid.Parse(uploadID); err != nil || len(uploadID) != len(uuid.Nil.String()) {
+		return nil, fmt.Errorf("%w: %q", ErrBadUploadID, uploadID)
+	}
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return &UploadSession{
+		bw:       bw,
+		identity: identity,
+		uploadID: uploadID,
+		leaseID:  options.leaseID,
+	}, nil
+}
+
+// ID returns the id needed to resume the session
+func (u *UploadSession) ID() string {
+	return u.uploadID
+}
+
+// Identity returns the name of the blob the session uploads
+func (u *UploadSession) Identity() string {
+	return u.identity
+}
+
+// UploadBlock uploads block number n. Blocks may be uploaded in any order,
+// and concurrently. Uploading a block again replaces it.
+func (u *UploadSession) UploadBlock(ctx context.Context, n int, data []byte) error {
+	if n < 0 || n >= maxBlocks {
+		return fmt.Errorf("%w: %d", ErrBadBlockNumber, n)
+	}
+	return u.bw.stageBlock(ctx, u.identity, blockID(u.uploadID, n), data, u.leaseID)
+}
+
+// blockNumber returns the block number for a block id, and false if the
+// block was not staged by this session.
+func (u *UploadSession) blockNumber(id string) (int, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return 0, false
+	}
+	number, ok := strings.CutPrefix(string(decoded), u.uploadID+"-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// ListUncommitted returns the blocks uploaded by this session, ordered by
+// block number. Blocks staged for the same blob by other sessions are ignored.
+func (u *UploadSession) ListUncommitted(ctx context.Context) ([]UncommittedBlock, error) {
+	staged, err := u.bw.uncommittedBlocks(ctx, u.identity, u.leaseID)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []UncommittedBlock
+	for _, block := range staged {
+		n, ok := u.blockNumber(block.ID)
+		if !ok {
+			continue
+		}
+		blocks = append(blocks, UncommittedBlock{Number: n, Size: block.Size})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Number < blocks[j].Number })
+	return blocks, nil
+}
+
+// Commit replaces the content of the blob with the uploaded blocks, in block
+// number order. The blocks must be numbered from zero with no gaps.
+//
+// As for Write, the ETag, since and tag conditions are checked by the commit
+// and the metadata and tags are set by it. WithLeaseID is taken from the
+// session if it is not given.
+func (u *UploadSession) Commit(ctx context.Context, opts ...Option) (*WriteResponse, error) {
+
+	options := &StorerOptions{leaseID: u.leaseID}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	blocks, err := u.ListUncommitted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoUploadedBlocks, u.identity)
+	}
+	blockIDs := make([]string, 0, len(blocks))
+	for i, block := range blocks {
+		if block.Number != i {
+			return nil, fmt.Errorf("%w: %s block %d", ErrMissingBlocks, u.identity, i)
+		}
+		blockIDs = append(blockIDs, blockID(u.uploadID, i))
+	}
+	return u.bw.commitBlockList(ctx, u.identity, blockIDs, options)
+}
+
+func (azp *Storer) uncommittedBlocks(
+	ctx context.Context,
+	identity string,
+	leaseID string,
+) ([]stagedBlock, error) {
+
+	blockBlobClient, err := azp.containerClient.NewBlockBlobClient(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	options := &azStorageBlob.BlockBlobGetBlockListOptions{}
+	if leaseID != "" {
+		options.BlobAccessConditions = &azStorageBlob.BlobAccessConditions{
+			LeaseAccessConditions: &azStorageBlob.LeaseAccessConditions{LeaseID: &leaseID},
+		}
+	}
+	r, err := blockBlobClient.GetBlockList(ctx, azStorageBlob.BlockListTypeUncommitted, options)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	blocks := make([]stagedBlock, 0, len(r.UncommittedBlocks))
+	for _, block := range r.UncommittedBlocks {
+		if block.Name == nil {
+			continue
+		}
+		b := stagedBlock{ID: *block.Name}
+		if block.Size != nil {
+			b.Size = *block.Size
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+func (s *localStore) uncommittedBlocks(
+	ctx context.Context,
+	identity string,
+	leaseID string,
+) ([]stagedBlock, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.backend.load(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	if err = checkLease(existing, leaseID, s.now(), false); err != nil {
+		return nil, err
+	}
+	staged, err := s.backend.stagedBlocks(identity)
+	if err != nil {
+		return nil, ErrorFromError(err)
+	}
+	blocks := make([]stagedBlock, 0, len(staged))
+	for id, data := range staged {
+		blocks = append(blocks, stagedBlock{ID: id, Size: int64(len(data))})
+	}
+	return blocks, nil
+}
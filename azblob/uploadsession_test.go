@@ -0,0 +1,88 @@
+package azblob
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreUploadSession(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileStore(logger.Sugar, dir, "testcontainer")
+	require.NoError(t, err)
+
+	session, err := store.BeginUpload(ctx, "big")
+	require.NoError(t, err)
+	require.NoError(t, session.UploadBlock(ctx, 2, []byte("CC")))
+	require.NoError(t, session.UploadBlock(ctx, 0, []byte("A")))
+
+	// blocks staged by other sessions are not reported
+	other, err := store.BeginUpload(ctx, "big")
+	require.NoError(t, err)
+	require.NoError(t, other.UploadBlock(ctx, 1, []byte("X")))
+
+	_, err = session.Commit(ctx)
+	require.ErrorIs(t, err, ErrMissingBlocks)
+
+	// resume the upload using a separate store instance on the same directory
+	resumed, err := NewFileStore(logger.Sugar, dir, "testcontainer")
+	require.NoError(t, err)
+	session, err = resumed.ResumeUpload(ctx, "big", session.ID())
+	require.NoError(t, err)
+
+	blocks, err := session.ListUncommitted(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []UncommittedBlock{{Number: 0, Size: 1}, {Number: 2, Size: 2}}, blocks)
+
+	require.NoError(t, session.UploadBlock(ctx, 1, []byte("B")))
+	_, err = session.Commit(ctx, WithEtagNoneMatch("*"), WithTags(map[string]string{"owner": "tenant1"}))
+	require.NoError(t, err)
+
+	rr, err := resumed.Reader(ctx, "big", WithTags(map[string]string{"owner": "tenant1"}))
+	require.NoError(t, err)
+	assert.Equal(t, "ABCC", readAll(t, rr))
+
+	// committing discards all the uncommitted blocks for the blob
+	blocks, err = other.ListUncommitted(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, blocks)
+
+	_, err = resumed.ResumeUpload(ctx, "big", "not-a-uuid")
+	assert.ErrorIs(t, err, ErrBadUploadID)
+}
+
+func TestLocalStoreUploadSessionReusedBuffer(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			session, err := store.BeginUpload(ctx, "big")
+			require.NoError(t, err)
+
+			// the staged blocks are not changed by reusing the buffer
+			buf := make([]byte, 2)
+			for i, block := range []string{"AA", "BB", "CC"} {
+				copy(buf, block)
+				require.NoError(t, session.UploadBlock(ctx, i, buf))
+			}
+			copy(buf, "ZZ")
+			_, err = session.Commit(ctx)
+			require.NoError(t, err)
+
+			rr, err := store.Reader(ctx, "big")
+			require.NoError(t, err)
+			assert.Equal(t, "AABBCC", readAll(t, rr))
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package azblob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+const (
+	// defaultWalkDelim separates the virtual directories of Walk and Glob
+	defaultWalkDelim = "/"
+)
+
+// WalkFunc is called by Walk for each virtual directory and blob below the
+// walked prefix. For a virtual directory item is nil and name ends with the
+// delimiter.
+//
+// As for fs.WalkDirFunc, returning fs.SkipDir for a virtual directory skips
+// its contents, and returning fs.SkipAll stops the walk without error. Any
+// other error stops the walk and is returned by it.
+type WalkFunc func(name string, item *azStorageBlob.BlobItemInternal) error
+
+// Walk lists the blobs below prefix depth first, as if the delimiter separated
+// directories, calling fn for each virtual directory and blob in name order.
+// The delimiter is "/" unless WithListDelim is given. Other list options, such
+// as WithListTags, are applied to every listing.
+func Walk(ctx context.Context, r Reader, prefix string, fn WalkFunc, opts ...Option) error {
+
+	options := &StorerOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	delim := options.listDelim
+	if delim == "" {
+		delim = defaultWalkDelim
+	}
+
+	err := walk(ctx, r, prefix, delim, fn, opts)
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walk calls fn for the entries directly below prefix, recursing into the
+// virtual directories
+func walk(ctx context.Context, r Reader, prefix string, delim string, fn WalkFunc, opts []Option) error {
+
+	var marker ListMarker
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lr, err := r.List(ctx, append(opts[:len(opts):len(opts)],
+			WithListPrefix(prefix), WithListDelim(delim), WithListMarker(marker))...)
+		if err != nil {
+			return err
+		}
+
+		// the blobs and virtual directories of a page are each in name
+		// order, merge them so that fn sees a single ordered sequence
+		items, prefixes := lr.Items, lr.Prefixes
+		for len(items) > 0 || len(prefixes) > 0 {
+			if len(prefixes) == 0 || (len(items) > 0 && *items[0].Name < *prefixes[0].Name) {
+				err = fn(*items[0].Name, items[0])
+				items = items[1:]
+				if errors.Is(err, fs.SkipDir) {
+					err = nil
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			dir := *prefixes[0].Name
+			prefixes = prefixes[1:]
+			err = fn(dir, nil)
+			if errors.Is(err, fs.SkipDir) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = walk(ctx, r, dir, delim, fn, opts); err != nil {
+				return err
+			}
+		}
+
+		if lr.Marker == nil || *lr.Marker == "" {
+			return nil
+		}
+		marker = lr.Marker
+	}
+}
+
+// Glob returns the names of the blobs matching pattern, in name order. The
+// pattern syntax is that of path.Match, so "*" does not match "/".
+//
+// Only the virtual directories which can contain matches are listed. For
+// example, "tenant/*/massifs/*.log" lists each tenant directory, but only the
+// massifs directory below each.
+func Glob(ctx context.Context, r Reader, pattern string, opts ...Option) ([]string, error) {
+
+	// check the pattern is well formed before listing anything
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	// list from the longest directory prefix with no special characters
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:strings.LastIndex(pattern[:i], "/")+1]
+	}
+
+	segments := strings.Split(pattern, "/")
+	var names []string
+	err := Walk(ctx, r, prefix, func(name string, item *azStorageBlob.BlobItemInternal) error {
+		if item == nil {
+			// a directory can only contain matches if it matches the
+			// same number of leading pattern segments
+			dir := strings.TrimSuffix(name, "/")
+			depth := strings.Count(dir, "/") + 1
+			if depth >= len(segments) {
+				return fs.SkipDir
+			}
+			if ok, _ := path.Match(strings.Join(segments[:depth], "/"), dir); !ok {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+		return nil
+	}, append(slices.Clip(opts), WithListDelim("/"))...)
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
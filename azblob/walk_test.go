@@ -0,0 +1,89 @@
+package azblob
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"testing"
+
+	azStorageBlob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datatrails/go-datatrails-common/logger"
+)
+
+func TestLocalStoreListHierarchy(t *testing.T) {
+	logger.New("NOOP")
+	defer logger.OnExit()
+
+	for name, store := range localStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			for _, name := range []string{
+				"root.log",
+				"tenant/a/massifs/0.log",
+				"tenant/a/massifs/1.log",
+				"tenant/a/seals/0.sth",
+				"tenant/b/massifs/0.log",
+				"tenant/b/readme",
+				"tenant-index",
+			} {
+				_, err := store.Put(ctx, name, NewBytesReaderCloser([]byte("VALUE")))
+				require.NoError(t, err)
+			}
+
+			var entries []string
+			var marker ListMarker
+			for {
+				r, err := store.List(ctx, WithListPrefix("tenant/"), WithListDelim("/"),
+					WithListMaxResults(1), WithListMarker(marker))
+				require.NoError(t, err)
+				for _, p := range r.Prefixes {
+					entries = append(entries, *p.Name)
+				}
+				for _, item := range r.Items {
+					entries = append(entries, *item.Name)
+				}
+				if r.Marker == nil {
+					break
+				}
+				marker = r.Marker
+			}
+			assert.Equal(t, []string{"tenant/a/", "tenant/b/"}, entries)
+
+			entries = nil
+			err := Walk(ctx, store, "tenant/", func(name string, item *azStorageBlob.BlobItemInternal) error {
+				entries = append(entries, name)
+				if name == "tenant/a/seals/" {
+					return fs.SkipDir
+				}
+				return nil
+			}, WithListMaxResults(2))
+			require.NoError(t, err)
+			assert.Equal(t, []string{
+				"tenant/a/",
+				"tenant/a/massifs/",
+				"tenant/a/massifs/0.log",
+				"tenant/a/massifs/1.log",
+				"tenant/a/seals/",
+				"tenant/b/",
+				"tenant/b/massifs/",
+				"tenant/b/massifs/0.log",
+				"tenant/b/readme",
+			}, entries)
+
+			names, err := Glob(ctx, store, "tenant/*/massifs/0.log")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"tenant/a/massifs/0.log", "tenant/b/massifs/0.log"}, names)
+
+			names, err = Glob(ctx, store, "*.log")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"root.log"}, names)
+
+			_, err = Glob(ctx, store, "tenant/[")
+			assert.ErrorIs(t, err, path.ErrBadPattern)
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 type WriteResponse struct {
 	HashValue         string
+	HashAlgorithm     HashAlgorithm
 	MimeType          string
 	Size              int64
 	TimestampAccepted string
@@ -23,6 +24,19 @@ type WriteResponse struct {
 	StatusCode   int // For If- header fails, err can be nil and code can be 304
 	Status       string
 	XMsErrorCode string // will be "ConditioNotMet" for If- header predicate fails, even when err is nil
+
+	// Set by Snapshot only
+	Snapshot *string // the time which identifies the snapshot
+	// Set by Snapshot and PromoteVersion, if versioning is enabled
+	VersionID *string
+
+	// Set by Copy and Move only
+	CopyID     *string
+	CopyStatus *CopyStatus
+
+	// Set by Append only
+	AppendOffset        *int64 // the offset at which the data was appended
+	CommittedBlockCount *int32 // the number of blocks in the append blob
 }
 
 // normaliseWriteResponseErr propagates appropriate err details to the response